@@ -0,0 +1,183 @@
+package images4
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// FrameOptions selects which frames of an animation produce icons
+// in func CustomIconsFromGIF. The zero value uses all frames.
+type FrameOptions struct {
+	// Step keeps every Step-th frame, starting with the first one.
+	// Values 0 and 1 keep all frames. All frames are composited
+	// regardless, so skipped frames still contribute to the canvas.
+	Step int
+	// MaxFrames limits the number of produced icons. 0 means no limit.
+	MaxFrames int
+	// Dedupe skips a frame when its icon is similar (func Similar)
+	// to the previously kept icon. Useful for animations with long
+	// static scenes.
+	Dedupe bool
+}
+
+var errNoFrames = errors.New("images4: GIF has no frames")
+
+// IconsFromGIF decodes all frames of a (possibly animated) GIF
+// and returns one icon per frame. Frames are composited onto the
+// canvas according to their disposal methods, so each icon
+// represents the picture as displayed, not the raw frame patch.
+func IconsFromGIF(r io.Reader) ([]IconT, error) {
+	return CustomIconsFromGIF(r, FrameOptions{})
+}
+
+// CustomIconsFromGIF is like IconsFromGIF, but allows sampling
+// and deduplication of frames.
+func CustomIconsFromGIF(r io.Reader, opts FrameOptions) ([]IconT, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	step := opts.Step
+	if step < 1 {
+		step = 1
+	}
+	var icons []IconT
+	err = compositeGIF(g, func(i int, canvas *image.RGBA) bool {
+		if i%step != 0 {
+			return true
+		}
+		icon := Icon(canvas)
+		icon.Meta.Format = "gif"
+		if opts.Dedupe && len(icons) > 0 &&
+			Similar(icons[len(icons)-1], icon) {
+			return true
+		}
+		icons = append(icons, icon)
+		return opts.MaxFrames <= 0 || len(icons) < opts.MaxFrames
+	})
+	return icons, err
+}
+
+// compositeGIF renders frames of g one by one onto a canvas and
+// calls fn for each rendered frame with its index. The canvas is
+// reused between calls. Rendering stops when fn returns false.
+func compositeGIF(g *gif.GIF, fn func(i int, canvas *image.RGBA) bool) error {
+	if len(g.Image) == 0 {
+		return errNoFrames
+	}
+
+	// Logical screen. Some encoders leave it empty, then frame
+	// bounds define the canvas.
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(bounds)
+	var previous *image.RGBA
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(bounds)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if !fn(i, canvas) {
+			return nil
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// Browsers restore to transparent rather than to
+			// the background color, and so do we.
+			draw.Draw(canvas, frame.Bounds(), image.Transparent,
+				image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return nil
+}
+
+// SimilarFrames reports whether any icon of iconsA is similar
+// (func Similar) to any icon of iconsB, and returns indices of
+// the first matching pair. A still image is a sequence of one
+// icon, so this also finds an animation containing a picture.
+func SimilarFrames(iconsA, iconsB []IconT) (a, b int, ok bool) {
+	return similarFrames(iconsA, iconsB, Similar)
+}
+
+// CustomSimilarFrames is like SimilarFrames, but uses func
+// CustomSimilar with the given coefficients.
+func CustomSimilarFrames(iconsA, iconsB []IconT,
+	coeff CustomCoefficients) (a, b int, ok bool) {
+	return similarFrames(iconsA, iconsB, func(iconA, iconB IconT) bool {
+		return CustomSimilar(iconA, iconB, coeff)
+	})
+}
+
+func similarFrames(iconsA, iconsB []IconT,
+	similar func(iconA, iconB IconT) bool) (a, b int, ok bool) {
+	for a = range iconsA {
+		for b = range iconsB {
+			if similar(iconsA[a], iconsB[b]) {
+				return a, b, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// SimilarSequence reports whether the shorter of the two icon
+// sequences matches, frame by frame, a run of consecutive frames
+// of the longer one. Animations loop, so the run may wrap around
+// the end of the longer sequence; this matches two animations with
+// the same content but different first frames. It returns the
+// offset of the run in the longer sequence.
+func SimilarSequence(iconsA, iconsB []IconT) (offset int, ok bool) {
+	return similarSequence(iconsA, iconsB, Similar)
+}
+
+// CustomSimilarSequence is like SimilarSequence, but uses func
+// CustomSimilar with the given coefficients.
+func CustomSimilarSequence(iconsA, iconsB []IconT,
+	coeff CustomCoefficients) (offset int, ok bool) {
+	return similarSequence(iconsA, iconsB, func(iconA, iconB IconT) bool {
+		return CustomSimilar(iconA, iconB, coeff)
+	})
+}
+
+func similarSequence(iconsA, iconsB []IconT,
+	similar func(iconA, iconB IconT) bool) (offset int, ok bool) {
+	short, long := iconsA, iconsB
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	if len(short) == 0 {
+		return 0, false
+	}
+	for offset = range long {
+		ok = true
+		for i := range short {
+			if !similar(short[i], long[(offset+i)%len(long)]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return offset, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,152 @@
+package images4
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path"
+	"testing"
+)
+
+func testIconsFromGIF(fileName string, opts FrameOptions,
+	t *testing.T) []IconT {
+	file, err := os.Open(path.Join("testdata", "animation", fileName))
+	if err != nil {
+		t.Fatal("Error opening file:", err)
+	}
+	defer file.Close()
+	icons, err := CustomIconsFromGIF(file, opts)
+	if err != nil {
+		t.Fatal("Error decoding GIF:", err)
+	}
+	return icons
+}
+
+func TestIconsFromGIF(t *testing.T) {
+	img0, _ := Open(path.Join("testdata", "rotate", "0.jpg"))
+	img180, _ := Open(path.Join("testdata", "rotate", "180.jpg"))
+	icon0 := Icon(img0)
+	icon180 := Icon(img180)
+
+	icons := testIconsFromGIF("0-180.gif", FrameOptions{}, t)
+	if len(icons) != 2 {
+		t.Fatalf("Expected 2 icons, got %d.", len(icons))
+	}
+	if !Similar(icons[0], icon0) || !Similar(icons[1], icon180) {
+		t.Errorf("Frames of 0-180.gif must be similar to 0.jpg and 180.jpg.")
+	}
+
+	// The still image matches the second frame.
+	a, b, ok := SimilarFrames([]IconT{icon180}, icons)
+	if !ok || a != 0 || b != 1 {
+		t.Errorf("Expected 180.jpg to match frame 1, got %v, %v, %v.",
+			a, b, ok)
+	}
+
+	icons = testIconsFromGIF("0-180.gif", FrameOptions{MaxFrames: 1}, t)
+	if len(icons) != 1 {
+		t.Errorf("Expected 1 icon with MaxFrames 1, got %d.", len(icons))
+	}
+	icons = testIconsFromGIF("0-180.gif", FrameOptions{Step: 2}, t)
+	if len(icons) != 1 {
+		t.Errorf("Expected 1 icon with Step 2, got %d.", len(icons))
+	}
+}
+
+// Frames 2 and 3 of partial.gif only cover the left and right
+// halves of the canvas. Only the composited frame 3 is the full
+// picture of 0.jpg.
+func TestIconsFromGIFCompositing(t *testing.T) {
+	img0, _ := Open(path.Join("testdata", "rotate", "0.jpg"))
+	icon0 := Icon(img0)
+
+	icons := testIconsFromGIF("partial.gif", FrameOptions{}, t)
+	if len(icons) != 3 {
+		t.Fatalf("Expected 3 icons, got %d.", len(icons))
+	}
+	if Similar(icons[0], icon0) || Similar(icons[1], icon0) {
+		t.Errorf("Frames 0 and 1 of partial.gif must NOT be similar to 0.jpg.")
+	}
+	if !Similar(icons[2], icon0) {
+		t.Errorf("Composited frame 2 of partial.gif must be similar to 0.jpg.")
+	}
+}
+
+func TestCompositeGIFDisposal(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	pal := color.Palette{color.Transparent, red, blue}
+	frame := func(r image.Rectangle, c uint8) *image.Paletted {
+		p := image.NewPaletted(r, pal)
+		for i := range p.Pix {
+			p.Pix[i] = c
+		}
+		return p
+	}
+	full := image.Rect(0, 0, 4, 4)
+	corner := image.Rect(0, 0, 2, 2)
+
+	tables := []struct {
+		disposal byte
+		want     color.RGBA // Top-left pixel of the last frame.
+	}{
+		{gif.DisposalNone, blue},
+		{gif.DisposalBackground, color.RGBA{}},
+		{gif.DisposalPrevious, red},
+	}
+	for _, table := range tables {
+		g := &gif.GIF{
+			// Red background, blue corner, then a fully transparent
+			// frame revealing the canvas after disposal of the corner.
+			Image:    []*image.Paletted{frame(full, 1), frame(corner, 2), frame(full, 0)},
+			Delay:    []int{0, 0, 0},
+			Disposal: []byte{gif.DisposalNone, table.disposal, gif.DisposalNone},
+			Config:   image.Config{ColorModel: pal, Width: 4, Height: 4},
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			t.Fatal("Error encoding GIF:", err)
+		}
+		decoded, err := gif.DecodeAll(&buf)
+		if err != nil {
+			t.Fatal("Error decoding GIF:", err)
+		}
+		var got color.RGBA
+		compositeGIF(decoded, func(i int, canvas *image.RGBA) bool {
+			got = canvas.RGBAAt(0, 0)
+			return true
+		})
+		if got != table.want {
+			t.Errorf("Disposal %d: expected %v, got %v.",
+				table.disposal, table.want, got)
+		}
+	}
+}
+
+func TestSimilarSequence(t *testing.T) {
+	iconsA := testIconsFromGIF("0-180.gif", FrameOptions{}, t)
+	iconsB := testIconsFromGIF("180-0.gif", FrameOptions{}, t)
+
+	// Same content, different first frames.
+	if _, ok := SimilarSequence(iconsA, iconsA); !ok {
+		t.Errorf("0-180.gif must match itself.")
+	}
+	offset, ok := SimilarSequence(iconsA, iconsB)
+	if !ok || offset != 1 {
+		t.Errorf("Expected 0-180.gif to match 180-0.gif at offset 1, got %v, %v.",
+			offset, ok)
+	}
+	if _, ok := SimilarSequence(iconsA, iconsB[1:]); !ok {
+		t.Errorf("A single frame must match its animation.")
+	}
+
+	img90, _ := Open(path.Join("testdata", "rotate", "90.jpg"))
+	if _, ok := SimilarSequence([]IconT{Icon(img90)}, iconsA); ok {
+		t.Errorf("90.jpg must NOT match 0-180.gif.")
+	}
+	if _, ok := SimilarSequence(nil, iconsA); ok {
+		t.Errorf("Empty sequence must NOT match.")
+	}
+}
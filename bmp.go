@@ -0,0 +1,403 @@
+package images4
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math/bits"
+)
+
+// Decoder of baseline Windows bitmaps (BMP): 1, 4 and 8 bit
+// paletted images, also RLE4 and RLE8 compressed, and 16, 24
+// and 32 bit true color images, also with BI_BITFIELDS masks.
+// It is registered with the image package, so that func Open
+// and image.Decode recognize BMP files.
+
+func init() {
+	image.RegisterFormat("bmp", "BM", decodeBMP, decodeBMPConfig)
+}
+
+var (
+	errBMPFormat      = errors.New("images4: bmp: invalid format")
+	errBMPUnsupported = errors.New("images4: bmp: unsupported format")
+)
+
+// maxDecodePixels protects in-module decoders from integer
+// overflows and absurd allocations on malformed headers.
+const maxDecodePixels = 1 << 30
+
+// Compression values of the BMP info header.
+const (
+	bmpRGB            = 0
+	bmpRLE8           = 1
+	bmpRLE4           = 2
+	bmpBitfields      = 3
+	bmpAlphaBitfields = 6
+)
+
+type bmpHeader struct {
+	width, height int
+	topDown       bool
+	bpp           int
+	compression   uint32
+	masks         [4]uint32 // R, G, B, A.
+	palette       color.Palette
+}
+
+// alpha reports whether the image has an alpha channel.
+func (h *bmpHeader) alpha() bool {
+	return h.bpp >= 16 && h.masks[3] != 0
+}
+
+func (h *bmpHeader) colorModel() color.Model {
+	switch {
+	case h.bpp <= 8:
+		return h.palette
+	case h.alpha():
+		return color.NRGBAModel
+	}
+	return color.RGBAModel
+}
+
+// readBMPHeader reads all headers and the palette, and leaves r
+// at the beginning of the pixel data.
+func readBMPHeader(r io.Reader) (*bmpHeader, error) {
+	var buf [18]byte
+	// File header (14 bytes) and the length of the info header.
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if buf[0] != 'B' || buf[1] != 'M' {
+		return nil, errBMPFormat
+	}
+	pixOffset := int64(binary.LittleEndian.Uint32(buf[10:]))
+	infoLen := int64(binary.LittleEndian.Uint32(buf[14:]))
+	if infoLen < 12 || infoLen > 124 {
+		return nil, errBMPUnsupported
+	}
+	// Offsets in info are relative to the info header start.
+	var infoBuf [124]byte
+	info := infoBuf[:infoLen]
+	if _, err := io.ReadFull(r, info[4:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	pos := 14 + infoLen
+
+	h := &bmpHeader{}
+	paletteEntryLen := 4
+	if infoLen == 12 { // BITMAPCOREHEADER.
+		h.width = int(binary.LittleEndian.Uint16(info[4:]))
+		h.height = int(binary.LittleEndian.Uint16(info[6:]))
+		h.bpp = int(binary.LittleEndian.Uint16(info[10:]))
+		paletteEntryLen = 3
+	} else {
+		if infoLen < 40 {
+			return nil, errBMPUnsupported
+		}
+		h.width = int(int32(binary.LittleEndian.Uint32(info[4:])))
+		h.height = int(int32(binary.LittleEndian.Uint32(info[8:])))
+		h.bpp = int(binary.LittleEndian.Uint16(info[14:]))
+		h.compression = binary.LittleEndian.Uint32(info[16:])
+	}
+	if h.height < 0 {
+		h.height, h.topDown = -h.height, true
+	}
+	if h.width <= 0 || h.height <= 0 ||
+		h.width > maxDecodePixels/h.height {
+		return nil, errBMPFormat
+	}
+
+	switch h.compression {
+	case bmpRGB:
+		switch h.bpp {
+		case 1, 4, 8, 24:
+		case 16: // 5-5-5.
+			h.masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+		case 32: // The fourth byte is unused.
+			h.masks = [4]uint32{0xff0000, 0xff00, 0xff, 0}
+		default:
+			return nil, errBMPUnsupported
+		}
+	case bmpRLE8:
+		if h.bpp != 8 || h.topDown {
+			return nil, errBMPFormat
+		}
+	case bmpRLE4:
+		if h.bpp != 4 || h.topDown {
+			return nil, errBMPFormat
+		}
+	case bmpBitfields, bmpAlphaBitfields:
+		if h.bpp != 16 && h.bpp != 32 {
+			return nil, errBMPFormat
+		}
+		n := 3
+		if h.compression == bmpAlphaBitfields {
+			n = 4
+		}
+		if infoLen >= 52 {
+			// Masks are part of the info header (V2 and later).
+			if infoLen >= 56 {
+				n = 4
+			}
+			for i := 0; i < n; i++ {
+				h.masks[i] = binary.LittleEndian.Uint32(info[40+4*i:])
+			}
+		} else {
+			// Masks follow the info header.
+			var masks [16]byte
+			if _, err := io.ReadFull(r, masks[:4*n]); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			for i := 0; i < n; i++ {
+				h.masks[i] = binary.LittleEndian.Uint32(masks[4*i:])
+			}
+			pos += int64(4 * n)
+		}
+	default:
+		return nil, errBMPUnsupported
+	}
+	if h.compression == bmpRGB && infoLen >= 56 && h.bpp == 32 {
+		// V3 and later headers may declare alpha also for BI_RGB.
+		h.masks[3] = binary.LittleEndian.Uint32(info[52:])
+	}
+
+	if h.bpp <= 8 {
+		// Palette, padded to full size with black, so that
+		// any index read from pixel data is valid.
+		n := 1 << uint(h.bpp)
+		if infoLen >= 40 {
+			if used := int(binary.LittleEndian.Uint32(info[32:])); used > 0 && used < n {
+				n = used
+			}
+		}
+		p := make([]byte, n*paletteEntryLen)
+		if _, err := io.ReadFull(r, p); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		pos += int64(len(p))
+		h.palette = make(color.Palette, 1<<uint(h.bpp))
+		for i := range h.palette {
+			h.palette[i] = color.RGBA{0, 0, 0, 255}
+			if i < n {
+				e := p[i*paletteEntryLen:]
+				h.palette[i] = color.RGBA{e[2], e[1], e[0], 255}
+			}
+		}
+	}
+
+	// Skip gaps (e.g. ICC profiles) before the pixel data.
+	if pixOffset > pos {
+		if _, err := io.CopyN(ioutil.Discard, r, pixOffset-pos); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return h, nil
+}
+
+// unexpectedEOF converts io.EOF in the middle of data to
+// io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func decodeBMPConfig(r io.Reader) (image.Config, error) {
+	h, err := readBMPHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
+
+func decodeBMP(r io.Reader) (image.Image, error) {
+	h, err := readBMPHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	switch h.compression {
+	case bmpRLE8, bmpRLE4:
+		return decodeBMPRLE(r, h)
+	}
+
+	// Rows are padded to 4 bytes. Reading everything first
+	// avoids allocating the image for truncated files.
+	rowLen := (h.bpp*h.width + 31) / 32 * 4
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(rowLen)*int64(h.height)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < rowLen*h.height {
+		return nil, io.ErrUnexpectedEOF
+	}
+	row := func(y int) []byte {
+		if !h.topDown {
+			y = h.height - 1 - y
+		}
+		return data[y*rowLen : (y+1)*rowLen]
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	if h.bpp <= 8 {
+		img := image.NewPaletted(rect, h.palette)
+		mask := byte(1<<uint(h.bpp) - 1)
+		for y := 0; y < h.height; y++ {
+			src, dst := row(y), img.Pix[y*img.Stride:]
+			for x := 0; x < h.width; x++ {
+				bit := x * h.bpp
+				dst[x] = src[bit/8] >> uint(8-h.bpp-bit%8) & mask
+			}
+		}
+		return img, nil
+	}
+
+	if h.bpp == 24 {
+		img := image.NewRGBA(rect)
+		for y := 0; y < h.height; y++ {
+			src, dst := row(y), img.Pix[y*img.Stride:]
+			for x := 0; x < h.width; x++ {
+				dst[4*x+0] = src[3*x+2]
+				dst[4*x+1] = src[3*x+1]
+				dst[4*x+2] = src[3*x+0]
+				dst[4*x+3] = 255
+			}
+		}
+		return img, nil
+	}
+
+	// 16 and 32 bit with bit masks.
+	var fields [4]bmpField
+	for i, m := range h.masks {
+		fields[i] = newBMPField(m)
+	}
+	img := image.NewNRGBA(rect)
+	transparent := true
+	for y := 0; y < h.height; y++ {
+		src, dst := row(y), img.Pix[y*img.Stride:]
+		for x := 0; x < h.width; x++ {
+			var v uint32
+			if h.bpp == 16 {
+				v = uint32(binary.LittleEndian.Uint16(src[2*x:]))
+			} else {
+				v = binary.LittleEndian.Uint32(src[4*x:])
+			}
+			dst[4*x+0] = fields[0].value(v)
+			dst[4*x+1] = fields[1].value(v)
+			dst[4*x+2] = fields[2].value(v)
+			dst[4*x+3] = 255
+			if h.alpha() {
+				dst[4*x+3] = fields[3].value(v)
+				transparent = transparent && dst[4*x+3] == 0
+			}
+		}
+	}
+	if !h.alpha() || transparent {
+		// Many encoders write a zero alpha channel, meaning
+		// the image is actually opaque.
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 255
+		}
+		return &image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}, nil
+	}
+	return img, nil
+}
+
+// bmpField extracts and scales to 8 bits a color component
+// defined by a bit mask.
+type bmpField struct {
+	shift, width uint
+}
+
+func newBMPField(mask uint32) bmpField {
+	if mask == 0 {
+		return bmpField{}
+	}
+	shift := uint(bits.TrailingZeros32(mask))
+	width := uint(bits.Len32(mask >> shift))
+	if width > 16 {
+		// Keep the most significant bits only.
+		shift, width = shift+width-16, 16
+	}
+	return bmpField{shift, width}
+}
+
+func (f bmpField) value(v uint32) uint8 {
+	if f.width == 0 {
+		return 0
+	}
+	max := uint32(1)<<f.width - 1
+	v = v >> f.shift & max
+	return uint8((v*255 + max/2) / max)
+}
+
+// decodeBMPRLE decodes RLE8 and RLE4 compressed pixel data.
+// Pixels not covered by the data (skipped with delta or
+// end-of-line codes) keep palette index 0.
+func decodeBMPRLE(r io.Reader, h *bmpHeader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewPaletted(image.Rect(0, 0, h.width, h.height), h.palette)
+	x, y := 0, 0 // y counts from the bottom row.
+	set := func(index byte) {
+		if x < h.width && y < h.height {
+			img.Pix[(h.height-1-y)*img.Stride+x] = index
+		}
+		x++
+	}
+	for i := 0; i+1 < len(data) && y < h.height; {
+		n, b := int(data[i]), data[i+1]
+		i += 2
+		if n > 0 { // Encoded run.
+			for k := 0; k < n; k++ {
+				if h.bpp == 8 {
+					set(b)
+				} else if k%2 == 0 {
+					set(b >> 4)
+				} else {
+					set(b & 0x0f)
+				}
+			}
+			continue
+		}
+		switch b {
+		case 0: // End of line.
+			x, y = 0, y+1
+		case 1: // End of bitmap.
+			return img, nil
+		case 2: // Delta.
+			if i+1 >= len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			x, y = x+int(data[i]), y+int(data[i+1])
+			i += 2
+		default: // Absolute run of b pixels, padded to 16 bits.
+			n = int(b)
+			byteLen := n
+			if h.bpp == 4 {
+				byteLen = (n + 1) / 2
+			}
+			if i+byteLen > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			for k := 0; k < n; k++ {
+				if h.bpp == 8 {
+					set(data[i+k])
+				} else if k%2 == 0 {
+					set(data[i+k/2] >> 4)
+				} else {
+					set(data[i+k/2] & 0x0f)
+				}
+			}
+			i += byteLen + byteLen%2
+		}
+	}
+	// Missing end-of-bitmap code is tolerated.
+	return img, nil
+}
@@ -0,0 +1,29 @@
+package images4
+
+import "testing"
+
+func TestDecodeBMP(t *testing.T) {
+	tables := []struct {
+		fileName, refName string
+	}{
+		{"1.bmp", "bilevel.png"},
+		{"4.bmp", "pal4.png"},
+		{"4-rle.bmp", "pal4.png"},
+		{"8.bmp", "pal8.png"},
+		{"8-rle.bmp", "pal8.png"},
+		{"16.bmp", "rgb555.png"},
+		{"24.bmp", "rgb.png"},
+		{"24-topdown.bmp", "rgb.png"},
+		{"32-bitfields.bmp", "rgb.png"},
+	}
+	for _, table := range tables {
+		testDecodeFormat(table.fileName, table.refName, "bmp", t)
+	}
+}
+
+func FuzzDecodeBMP(f *testing.F) {
+	fuzzSeeds(f, "*.bmp")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, data, decodeBMP, decodeBMPConfig)
+	})
+}
@@ -0,0 +1,192 @@
+package images4
+
+import (
+	"math"
+	"sort"
+)
+
+// LabeledPair is a pair of icons labeled by a human as similar
+// or not, for func Calibrate.
+type LabeledPair struct {
+	A, B    IconT
+	Similar bool
+}
+
+// Objective of func Calibrate.
+type Objective int
+
+const (
+	// ObjectiveF1 maximizes the F1 score, the harmonic mean of
+	// precision and recall.
+	ObjectiveF1 Objective = iota
+	// ObjectivePrecision maximizes precision at the recall of at
+	// least CalibrationOptions.MinRecall.
+	ObjectivePrecision
+)
+
+// CalibrationOptions configures func Calibrate.
+type CalibrationOptions struct {
+	Objective Objective
+	MinRecall float64 // For ObjectivePrecision, in [0, 1].
+}
+
+// Calibration is the result of func Calibrate.
+type Calibration struct {
+	// Coefficients for func CustomSimilar, best by the objective.
+	Coefficients CustomCoefficients
+	// Quality of Coefficients on the labeled pairs.
+	Precision, Recall, F1 float64
+	// Curve of precision, recall and false positive rate for all
+	// coefficients equal to Scale, with increasing Scale. It is
+	// both a ROC table (FalsePositiveRate, Recall) and a PR table
+	// (Recall, Precision).
+	Curve []CurvePoint
+}
+
+// CurvePoint is a point of Calibration.Curve.
+type CurvePoint struct {
+	Scale             float64
+	Precision, Recall float64
+	FalsePositiveRate float64
+}
+
+// Calibrate searches for CustomCoefficients which best separate
+// pairs labeled similar from pairs labeled not similar, by the
+// objective. Metrics of pairs are EucMetric and PropMetric relative
+// to the thresholds of func Similar.
+//
+// The search starts from the best common value of all coefficients
+// and improves one coefficient at a time, until no coefficient
+// improves the objective. Coefficients are placed midway between
+// metric values of pairs, not to overfit the boundary.
+func Calibrate(pairs []LabeledPair, opts CalibrationOptions) Calibration {
+	ratios := make([][4]float64, len(pairs))
+	labels := make([]bool, len(pairs))
+	for i, p := range pairs {
+		m1, m2, m3 := EucMetric(p.A, p.B)
+		ratios[i] = [4]float64{m1 / thY, m2 / thCbCr, m3 / thCbCr,
+			PropMetric(p.A, p.B) / thProp}
+		labels[i] = p.Similar
+	}
+	c := &calibrator{ratios: ratios, labels: labels, opts: opts}
+
+	// Common scale: a pair is similar when its largest ratio fits.
+	maxRatios := make([]float64, len(pairs))
+	for i, r := range ratios {
+		maxRatios[i] = math.Max(math.Max(r[0], r[1]), math.Max(r[2], r[3]))
+	}
+	var result Calibration
+	bestScale, best := 1.0, math.Inf(-1)
+	for _, s := range cutPoints(maxRatios) {
+		q := c.evaluate([4]float64{s, s, s, s})
+		result.Curve = append(result.Curve, CurvePoint{
+			Scale: s, Precision: q.precision, Recall: q.recall,
+			FalsePositiveRate: q.fpr,
+		})
+		if v := c.objective(q); v > best {
+			bestScale, best = s, v
+		}
+	}
+
+	// Coordinate ascent.
+	coeff := [4]float64{bestScale, bestScale, bestScale, bestScale}
+	for improved := true; improved; {
+		improved = false
+		for k := 0; k < 4; k++ {
+			values := make([]float64, len(ratios))
+			for i, r := range ratios {
+				values[i] = r[k]
+			}
+			for _, v := range cutPoints(values) {
+				trial := coeff
+				trial[k] = v
+				if o := c.objective(c.evaluate(trial)); o > best+1e-12 {
+					coeff, best, improved = trial, o, true
+				}
+			}
+		}
+	}
+
+	q := c.evaluate(coeff)
+	result.Coefficients = CustomCoefficients{
+		Y: coeff[0], Cb: coeff[1], Cr: coeff[2], Prop: coeff[3]}
+	result.Precision, result.Recall, result.F1 = q.precision, q.recall, q.f1
+	return result
+}
+
+type calibrator struct {
+	ratios [][4]float64
+	labels []bool
+	opts   CalibrationOptions
+}
+
+type calibrationQuality struct {
+	precision, recall, fpr, f1 float64
+}
+
+func (c *calibrator) evaluate(coeff [4]float64) (q calibrationQuality) {
+	var tp, fp, fn, tn int
+	for i, r := range c.ratios {
+		similar := r[0] <= coeff[0] && r[1] <= coeff[1] &&
+			r[2] <= coeff[2] && r[3] <= coeff[3]
+		switch {
+		case similar && c.labels[i]:
+			tp++
+		case similar:
+			fp++
+		case c.labels[i]:
+			fn++
+		default:
+			tn++
+		}
+	}
+	q.precision = 1
+	if tp+fp > 0 {
+		q.precision = float64(tp) / float64(tp+fp)
+	}
+	if tp+fn > 0 {
+		q.recall = float64(tp) / float64(tp+fn)
+	}
+	if fp+tn > 0 {
+		q.fpr = float64(fp) / float64(fp+tn)
+	}
+	if q.precision+q.recall > 0 {
+		q.f1 = 2 * q.precision * q.recall / (q.precision + q.recall)
+	}
+	return q
+}
+
+// objective values higher as better. Precision objectives below
+// the minimal recall rank by recall only.
+func (c *calibrator) objective(q calibrationQuality) float64 {
+	if c.opts.Objective == ObjectivePrecision {
+		if q.recall < c.opts.MinRecall {
+			return q.recall - 2
+		}
+		return q.precision + 1e-6*q.recall // Ties by recall.
+	}
+	return q.f1
+}
+
+// cutPoints returns values separating sorted distinct finite
+// values: midpoints between neighbours, 0 below the smallest and
+// 5% above the largest.
+func cutPoints(values []float64) []float64 {
+	var sorted []float64
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Float64s(sorted)
+	cuts := []float64{0}
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] != sorted[i-1] {
+			cuts = append(cuts, (sorted[i-1]+sorted[i])/2)
+		}
+	}
+	if n := len(sorted); n > 0 && sorted[n-1] > 0 {
+		cuts = append(cuts, sorted[n-1]*1.05)
+	}
+	return cuts
+}
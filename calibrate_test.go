@@ -0,0 +1,65 @@
+package images4
+
+import (
+	"image"
+	"testing"
+)
+
+// uniformIcon returns an icon of one color, in [0, 255] units.
+func uniformIcon(y, cb, cr float64, size image.Point) IconT {
+	icon := sizedIcon(IconSize)
+	for x := 0; x < IconSize; x++ {
+		for v := 0; v < IconSize; v++ {
+			Set(icon, IconSize, image.Point{x, v}, y, cb, cr)
+		}
+	}
+	icon.ImgSize = size
+	return icon
+}
+
+func TestCalibrate(t *testing.T) {
+	square := image.Point{100, 100}
+	base := uniformIcon(100, 128, 128, square)
+	var pairs []LabeledPair
+	// Squared luma differences per pixel of 25, 100, 225 and 400
+	// are 0.05, 0.2, 0.45 and 0.8 of the Y threshold.
+	for _, dy := range []float64{5, 10, 15, 20} {
+		pairs = append(pairs, LabeledPair{base, uniformIcon(100+dy, 128, 128, square), true})
+	}
+	pairs = append(pairs,
+		LabeledPair{base, uniformIcon(125, 128, 128, square), false}, // Y 1.25.
+		LabeledPair{base, uniformIcon(105, 168, 128, square), false}, // Cb 1.6.
+		LabeledPair{base, uniformIcon(105, 128, 128, image.Point{100, 150}), false},
+	)
+
+	c := Calibrate(pairs, CalibrationOptions{})
+	if c.F1 != 1 || c.Precision != 1 || c.Recall != 1 {
+		t.Errorf("Expected perfect separation, got %+v.", c)
+	}
+	if c.Coefficients.Y <= 0.8 || c.Coefficients.Y >= 1.25 {
+		t.Errorf("Coefficient Y %v does not separate 0.8 and 1.25.", c.Coefficients.Y)
+	}
+	for _, p := range pairs {
+		if CustomSimilar(p.A, p.B, c.Coefficients) != p.Similar {
+			t.Errorf("CustomSimilar disagrees with label %v.", p.Similar)
+		}
+	}
+	for i := 1; i < len(c.Curve); i++ {
+		if c.Curve[i].Scale <= c.Curve[i-1].Scale ||
+			c.Curve[i].Recall < c.Curve[i-1].Recall {
+			t.Errorf("Curve is not monotonic at %d: %+v.", i, c.Curve)
+		}
+	}
+
+	// A mislabeled pair: precision 1 is possible only with half
+	// of the recall.
+	pairs = append(pairs, LabeledPair{base, uniformIcon(112, 128, 128, square), false})
+	c = Calibrate(pairs, CalibrationOptions{Objective: ObjectivePrecision, MinRecall: 0.5})
+	if c.Precision != 1 || c.Recall != 0.5 {
+		t.Errorf("Expected precision 1 at recall 0.5, got %v, %v.", c.Precision, c.Recall)
+	}
+	c = Calibrate(pairs, CalibrationOptions{Objective: ObjectivePrecision, MinRecall: 1})
+	if c.Recall != 1 || c.Precision != 0.8 {
+		t.Errorf("Expected precision 0.8 at recall 1, got %v, %v.", c.Precision, c.Recall)
+	}
+}
@@ -0,0 +1,273 @@
+// Command images4 compares images from the command line.
+//
+// Usage:
+//
+//	images4 diff [-scale n] [-o diff.png] image1 image2
+//	images4 calibrate [-objective f1|precision] [-min-recall r] pairs.csv
+//	images4 serve [-addr :8080] [-max-upload bytes] [-max-pixels n] [-rotations] [-mirrors] [-uniform]
+//	images4 watch [-interval 2s] [-max-pixels n] [-max-bytes n] [-uniform] [-y c] [-cb c] [-cr c] [-prop c] dir...
+//
+// Subcommand diff prints similarity metrics of two images relative
+// to the thresholds of func Similar, and differences of their mean
+// colors with the verdict of func CheckColor. It saves a
+// visualization of their icons and icon differences (see func
+// DiffImage).
+//
+// Subcommand calibrate searches for coefficients of func
+// CustomSimilar (see func Calibrate) on labeled image pairs. Lines of
+// the CSV file are "path1,path2,label", where label is 1 for similar
+// and 0 for not similar images. It prints the coefficients and a
+// ROC/PR table for all coefficients equal.
+//
+// Subcommand serve runs an HTTP server computing icons, comparing
+// images and searching in-memory indexes, with the JSON API of
+// package github.com/vitali-fedulov/images4/server.
+//
+// Subcommand watch polls directories for new, changed and removed
+// images (see type Watcher), and prints events as JSON lines, such
+// as {"type":"duplicate","path":"b.jpg","duplicates":["a.jpg"]}.
+// Flags -y, -cb, -cr and -prop set coefficients of func
+// CustomSimilar. Images are decoded with the pixel and memory
+// limits of server.DefaultOptions, and images exceeding them or
+// -max-bytes give error events.
+//
+// Flag -uniform of serve and watch matches uniform images, such as
+// blank scans, only to images of the same color (see
+// IndexOptions.UniformSameColor).
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/vitali-fedulov/images4"
+	"github.com/vitali-fedulov/images4/server"
+)
+
+const usage = `Usage:
+  images4 diff [-scale n] [-o diff.png] image1 image2
+  images4 calibrate [-objective f1|precision] [-min-recall r] pairs.csv
+  images4 serve [-addr :8080] [-max-upload bytes] [-max-pixels n] [-rotations] [-mirrors] [-uniform]
+  images4 watch [-interval 2s] [-max-pixels n] [-max-bytes n] [-uniform] [-y c] [-cb c] [-cr c] [-prop c] dir...
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "diff":
+		err = diff(os.Args[2:])
+	case "calibrate":
+		err = calibrate(os.Args[2:])
+	case "serve":
+		err = serve(os.Args[2:])
+	case "watch":
+		err = watch(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func diff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	scale := flags.Int("scale", 20, "pixels per icon pixel")
+	out := flags.String("o", "diff.png", "output PNG file")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var icons [2]images4.IconT
+	for i, path := range flags.Args() {
+		img, err := images4.Open(path)
+		if err != nil {
+			return err
+		}
+		icons[i] = images4.Icon(img)
+	}
+
+	m1, m2, m3 := images4.EucMetric(icons[0], icons[1])
+	y, cbcr, _ := images4.Thresholds()
+	fmt.Printf("Y:  %.0f (%.1f%% of threshold)\n", m1, 100*m1/y)
+	fmt.Printf("Cb: %.0f (%.1f%% of threshold)\n", m2, 100*m2/cbcr)
+	fmt.Printf("Cr: %.0f (%.1f%% of threshold)\n", m3, 100*m3/cbcr)
+	fmt.Printf("Proportions: %.3f\n", images4.PropMetric(icons[0], icons[1]))
+	fmt.Printf("Similar: %v\n", images4.Similar(icons[0], icons[1]))
+	check := images4.CheckColor(icons[0], icons[1], images4.ColorCoefficients{Y: 1, Cb: 1, Cr: 1, Range: 1})
+	fmt.Printf("Mean color differences: Y %.1f, Cb %.1f, Cr %.1f\n",
+		check.Mean[0], check.Mean[1], check.Mean[2])
+	fmt.Printf("Same brightness, tint and contrast: %v\n", check.Passed)
+
+	images4.SaveToPNG(images4.DiffImage(icons[0], icons[1], *scale), *out)
+	return nil
+}
+
+func calibrate(args []string) error {
+	flags := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	objective := flags.String("objective", "f1", "f1 or precision")
+	minRecall := flags.Float64("min-recall", 0.9, "minimal recall for objective precision")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	opts := images4.CalibrationOptions{MinRecall: *minRecall}
+	switch *objective {
+	case "f1":
+	case "precision":
+		opts.Objective = images4.ObjectivePrecision
+	default:
+		return fmt.Errorf("unknown objective %q", *objective)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = 3
+	icons := map[string]images4.IconT{}
+	icon := func(path string) (images4.IconT, error) {
+		if ic, ok := icons[path]; ok {
+			return ic, nil
+		}
+		img, err := images4.OpenReduced(path)
+		if err != nil {
+			return images4.IconT{}, err
+		}
+		icons[path] = images4.Icon(img)
+		return icons[path], nil
+	}
+	var pairs []images4.LabeledPair
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		similar, err := strconv.ParseBool(record[2])
+		if err != nil {
+			return fmt.Errorf("label of %s, %s: %v", record[0], record[1], err)
+		}
+		a, err := icon(record[0])
+		if err != nil {
+			return err
+		}
+		b, err := icon(record[1])
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, images4.LabeledPair{A: a, B: b, Similar: similar})
+	}
+
+	c := images4.Calibrate(pairs, opts)
+	fmt.Printf("Coefficients: Y=%.3f Cb=%.3f Cr=%.3f Prop=%.3f\n",
+		c.Coefficients.Y, c.Coefficients.Cb, c.Coefficients.Cr, c.Coefficients.Prop)
+	fmt.Printf("Precision: %.3f, recall: %.3f, F1: %.3f\n\n", c.Precision, c.Recall, c.F1)
+	fmt.Println("Scale\tPrecision\tRecall\tFPR")
+	// At most about 20 rows.
+	step := (len(c.Curve) + 19) / 20
+	for i := 0; i < len(c.Curve); i++ {
+		if i%step != 0 && i != len(c.Curve)-1 {
+			continue
+		}
+		p := c.Curve[i]
+		fmt.Printf("%.3f\t%.3f\t%.3f\t%.3f\n", p.Scale, p.Precision, p.Recall, p.FalsePositiveRate)
+	}
+	return nil
+}
+
+func serve(args []string) error {
+	opts := server.DefaultOptions
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	flags.Int64Var(&opts.MaxUploadBytes, "max-upload", opts.MaxUploadBytes,
+		"maximum request body size in bytes, 0 for no limit")
+	flags.Int64Var(&opts.Limits.MaxPixels, "max-pixels", opts.Limits.MaxPixels,
+		"maximum pixels of uploaded images, 0 for no limit")
+	flags.BoolVar(&opts.Index.Rotations, "rotations", false,
+		"also find rotated images in indexes")
+	flags.BoolVar(&opts.Index.Mirrors, "mirrors", false,
+		"also find mirrored images in indexes, with -rotations")
+	flags.BoolVar(&opts.Index.UniformSameColor, "uniform", false,
+		"match uniform images in indexes only to the same color")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           server.New(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute, // Uploads of up to -max-upload bytes.
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	log.Printf("Listening on %s", *addr)
+	return srv.ListenAndServe()
+}
+
+func watch(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", 2*time.Second, "polling interval")
+	limits := server.DefaultOptions.Limits
+	limits.MaxBytes = 1 << 30
+	flags.Int64Var(&limits.MaxPixels, "max-pixels", limits.MaxPixels,
+		"maximum pixels of images, 0 for no limit")
+	flags.Int64Var(&limits.MaxBytes, "max-bytes", limits.MaxBytes,
+		"maximum file size in bytes, 0 for no limit")
+	uniform := flags.Bool("uniform", false, "match uniform images only to the same color")
+	coeff := images4.CustomCoefficients{Y: 1, Cb: 1, Cr: 1, Prop: 1}
+	flags.Float64Var(&coeff.Y, "y", 1, "coefficient of the luma threshold")
+	flags.Float64Var(&coeff.Cb, "cb", 1, "coefficient of the Cb threshold")
+	flags.Float64Var(&coeff.Cr, "cr", 1, "coefficient of the Cr threshold")
+	flags.Float64Var(&coeff.Prop, "prop", 1, "coefficient of the proportion threshold")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	opts := images4.WatchOptions{Interval: *interval, Limits: limits,
+		Index: images4.NewIndex(images4.IndexOptions{UniformSameColor: *uniform})}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "y", "cb", "cr", "prop":
+			opts.Coefficients = &coeff
+		}
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	enc := json.NewEncoder(os.Stdout)
+	err := images4.NewWatcher(flags.Args(), opts).Watch(ctx, func(e images4.WatchEvent) {
+		enc.Encode(e)
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package images4
+
+import "math"
+
+// ColorCoefficients are threshold multiplication coefficients of
+// func CheckColor, as CustomCoefficients are of func CustomSimilar.
+// All values equal to 1 give the default thresholds, which accept
+// differences of recompression and resizing. Smaller values are
+// stricter, down to 0 for equal colors.
+type ColorCoefficients struct {
+	Y     float64 // Brightness (mean luma).
+	Cb    float64 // Tint (mean chrominance b).
+	Cr    float64 // Tint (mean chrominance r).
+	Range float64 // Contrast (range of each channel).
+}
+
+// ColorCheck is the result of func CheckColor. Differences are
+// absolute, for channels Y, Cb and Cr in the [0, 255] range.
+type ColorCheck struct {
+	// Checked is false when an icon has no metadata. Such pairs
+	// pass.
+	Checked bool
+	// Passed is false when a difference exceeds its threshold.
+	Passed bool
+	Mean   [3]float64 // Differences of mean colors, as of func MeanMetric.
+	Range  [3]float64 // Differences of channel ranges.
+}
+
+// CheckColor compares brightness, tint and contrast of the images
+// of two icons, from their metadata of channels before
+// normalization. Func Similar ignores those, so that an image and
+// its darkened or tinted copy are similar. Use CheckColor as an
+// additional condition when such copies must differ:
+//
+//	similar := images4.Similar(iconA, iconB) &&
+//		images4.CheckColor(iconA, iconB, coeff).Passed
+func CheckColor(iconA, iconB IconT, coeff ColorCoefficients) ColorCheck {
+	check := ColorCheck{Passed: true}
+	m1, m2, m3, ok := MeanMetric(iconA, iconB)
+	if !ok {
+		return check
+	}
+	check.Checked = true
+	check.Mean = [3]float64{m1, m2, m3}
+	a, b := iconA.Meta, iconB.Meta
+	for ch := 0; ch < 3; ch++ {
+		check.Range[ch] = math.Abs((a.Max[ch] - a.Min[ch]) - (b.Max[ch] - b.Min[ch]))
+		if check.Range[ch] > thRange*coeff.Range {
+			check.Passed = false
+		}
+	}
+	if m1 > thMeanY*coeff.Y || m2 > thMeanCbCr*coeff.Cb || m3 > thMeanCbCr*coeff.Cr {
+		check.Passed = false
+	}
+	return check
+}
+
+// ColorThresholds returns the default thresholds of func
+// CheckColor: y for the difference of mean luma, cbcr for mean Cb
+// and Cr, and rng for channel ranges.
+func ColorThresholds() (y, cbcr, rng float64) {
+	return thMeanY, thMeanCbCr, thRange
+}
@@ -0,0 +1,89 @@
+package images4
+
+import (
+	"image"
+	"image/color"
+	"path"
+	"testing"
+)
+
+// mapColors returns a copy of an image with colors changed by f.
+func mapColors(img image.Image, f func(r, g, b float64) (float64, float64, float64)) *image.RGBA {
+	dst := image.NewRGBA(img.Bounds())
+	clamp := func(v float64) uint8 {
+		if v < 0 {
+			return 0
+		} else if v > 255 {
+			return 255
+		}
+		return uint8(v)
+	}
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			rf, gf, bf := f(float64(r>>8), float64(g>>8), float64(b>>8))
+			dst.SetRGBA(x, y, color.RGBA{clamp(rf), clamp(gf), clamp(bf), 255})
+		}
+	}
+	return dst
+}
+
+func TestCheckColor(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon := Icon(img)
+	ones := ColorCoefficients{1, 1, 1, 1}
+
+	tables := []struct {
+		name  string
+		f     func(r, g, b float64) (float64, float64, float64)
+		coeff ColorCoefficients
+		pass  bool
+	}{
+		{"darkened", func(r, g, b float64) (float64, float64, float64) {
+			return r * 0.6, g * 0.6, b * 0.6
+		}, ones, false},
+		{"tinted", func(r, g, b float64) (float64, float64, float64) {
+			return r, g, b + 40
+		}, ones, false},
+		{"low contrast", func(r, g, b float64) (float64, float64, float64) {
+			return 64 + r/2, 64 + g/2, 64 + b/2
+		}, ColorCoefficients{100, 100, 100, 1}, false},
+		{"low contrast, any range", func(r, g, b float64) (float64, float64, float64) {
+			return 64 + r/2, 64 + g/2, 64 + b/2
+		}, ColorCoefficients{100, 100, 100, 100}, true},
+		{"slightly brighter", func(r, g, b float64) (float64, float64, float64) {
+			return r + 5, g + 5, b + 5
+		}, ones, true},
+	}
+	for _, table := range tables {
+		changed := Icon(mapColors(img, table.f))
+		if !Similar(icon, changed) {
+			t.Errorf("%s: normalization must hide the change.", table.name)
+		}
+		check := CheckColor(icon, changed, table.coeff)
+		if !check.Checked || check.Passed != table.pass {
+			t.Errorf("%s: expected passed %v, got %+v.", table.name, table.pass, check)
+		}
+		if CheckColor(changed, icon, table.coeff) != check {
+			t.Errorf("%s: check must be symmetric.", table.name)
+		}
+	}
+
+	small := testIcon("small.jpg", t)
+	if check := CheckColor(icon, small, ones); !check.Passed {
+		t.Errorf("Resized image must pass, got %+v.", check)
+	}
+	if check := CheckColor(icon, small, ColorCoefficients{}); check.Passed {
+		t.Errorf("Resized image must fail with zero coefficients, got %+v.", check)
+	}
+	if check := CheckColor(icon, icon, ColorCoefficients{}); !check.Passed {
+		t.Errorf("Identical icons must pass, got %+v.", check)
+	}
+	icon.Meta = nil
+	if check := CheckColor(icon, small, ColorCoefficients{}); check.Checked || !check.Passed {
+		t.Errorf("Icons without metadata must pass unchecked, got %+v.", check)
+	}
+}
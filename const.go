@@ -36,11 +36,22 @@ const (
 	// Proportion similarity threshold (5%).
 	thProp = 0.05
 
+	// Color check thresholds of func CheckColor, in [0, 255]
+	// levels of channels before normalization.
+
+	// Difference of mean luma (brightness).
+	thMeanY = 16
+	// Difference of mean Cb or Cr (tint).
+	thMeanCbCr = 8
+	// Difference of the range of a channel (contrast).
+	thRange = 24
+
 	// Auxiliary constants.
 
 	numPix           = IconSize * IconSize
 	largeIconSize    = IconSize*2 + 1
 	resizedImgSize   = largeIconSize * samples
+	reducedMinSize   = largeIconSize * 4
 	invSamplePixels2 = 1 / float64(samples*samples)
 	oneNinth         = 1 / float64(9)
 	one255th         = 1 / float64(255)
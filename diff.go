@@ -0,0 +1,83 @@
+package images4
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// DiffImage renders icons A and B side by side, followed by heatmaps
+// of their differences in channels Y, Cb and Cr. It shows what
+// func Similar compares, e.g. to explain a disputed match. Each of
+// the 5 panels is an icon upscaled by the scale factor, separated
+// by scale pixels of white.
+//
+// Icons are rendered with func Render with default options.
+// Heatmap pixels go from black (no difference) over red to yellow,
+// where the squared difference reaches the per-pixel share of the
+// Similar threshold of the channel, and saturate beyond it.
+// Heatmaps stay blank when an icon has Pixels of a wrong length,
+// such as EmptyIcon.
+func DiffImage(iconA, iconB IconT, scale int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	side := IconSize * scale
+	img := image.NewRGBA(image.Rect(0, 0, 5*side+4*scale, side))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	opts := RenderOptions{Scale: scale}
+	draw.Draw(img, image.Rect(0, 0, side, side), iconA.Render(opts),
+		image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(side+scale, 0, 2*side+scale, side), iconB.Render(opts),
+		image.Point{}, draw.Src)
+
+	if len(iconA.Pixels) != 3*numPix || len(iconB.Pixels) != 3*numPix {
+		return img
+	}
+	thresholds := [3]float64{thY / numPix, thCbCr / numPix, thCbCr / numPix}
+	for x := 0; x < IconSize; x++ {
+		for y := 0; y < IconSize; y++ {
+			p := image.Point{x, y}
+			a1, a2, a3 := Get(iconA, IconSize, p)
+			b1, b2, b3 := Get(iconB, IconSize, p)
+			for ch, d := range [3]float64{a1 - b1, a2 - b2, a3 - b3} {
+				fillSquare(img, 2+ch, p, scale, heat(d*d/thresholds[ch]))
+			}
+		}
+	}
+	return img
+}
+
+// fillSquare paints an upscaled icon pixel p of a panel.
+func fillSquare(img *image.RGBA, panel int, p image.Point, scale int, c color.RGBA) {
+	x0 := panel*(IconSize+1)*scale + p.X*scale
+	y0 := p.Y * scale
+	for y := y0; y < y0+scale; y++ {
+		for x := x0; x < x0+scale; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// rgbaFromYCbCr converts icon channel values in [0, 255] to a
+// displayable color.
+func rgbaFromYCbCr(yc, cb, cr float64) color.RGBA {
+	r, g, b := rgb(yc, cb, cr)
+	return color.RGBA{clampUint8(r), clampUint8(g), clampUint8(b), 255}
+}
+
+// heat maps t in [0, 1] to black-red-yellow.
+func heat(t float64) color.RGBA {
+	return color.RGBA{clampUint8(510 * t), clampUint8(510*t - 255), 0, 255}
+}
+
+func clampUint8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
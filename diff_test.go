@@ -0,0 +1,53 @@
+package images4
+
+import (
+	"image"
+	"image/color"
+	"path"
+	"testing"
+)
+
+func TestDiffImage(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "uniform-green.png"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	green := IconNN(img)
+	black := IconNN(image.NewGray(image.Rect(0, 0, 10, 10)))
+	r, g, b, _ := img.At(0, 0).RGBA()
+	want := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+
+	const scale = 3
+	side := (IconSize + 1) * scale
+	diff := DiffImage(green, green, scale)
+	if diff.Bounds().Dx() != 5*side-scale || diff.Bounds().Dy() != IconSize*scale {
+		t.Fatalf("Unexpected size %v.", diff.Bounds())
+	}
+	for panel := 0; panel < 5; panel++ {
+		got := diff.RGBAAt(panel*side+scale*IconSize/2, scale*IconSize/2)
+		if panel < 2 && !closeRGBA(got, want, 2) {
+			t.Errorf("Panel %d: expected %v, got %v.", panel, want, got)
+		}
+		if panel >= 2 && got != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("Panel %d: expected no difference, got %v.", panel, got)
+		}
+	}
+
+	// Green vs black differ in luma beyond the threshold.
+	diff = DiffImage(green, black, scale)
+	if got := diff.RGBAAt(2*side, 0); got != (color.RGBA{255, 255, 0, 255}) {
+		t.Errorf("Expected saturated heat of Y, got %v.", got)
+	}
+
+	// Empty icons have no heatmaps.
+	diff = DiffImage(green, EmptyIcon(), scale)
+	if got := diff.RGBAAt(2*side, 0); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("Expected a blank heatmap, got %v.", got)
+	}
+}
+
+func closeRGBA(a, b color.RGBA, tolerance int) bool {
+	return absInt(int(a.R)-int(b.R)) <= tolerance &&
+		absInt(int(a.G)-int(b.G)) <= tolerance &&
+		absInt(int(a.B)-int(b.B)) <= tolerance
+}
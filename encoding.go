@@ -0,0 +1,308 @@
+package images4
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"math"
+)
+
+// Binary icon layout (all values big-endian):
+//
+//	byte 0        format version: iconEncodingVersion, or
+//	              iconEncodingVersion2 for icons with Normalization
+//	              other than NormalizeMinMax or with Linear, or
+//	              iconEncodingVersion3 for icons with Meta
+//	bytes 1..8    ImgSize.X and ImgSize.Y as int32
+//	byte 9        Normalization, with bit 0x80 set for Linear
+//	              (versions 2 and 3)
+//	bytes 10..11  length of metadata records (version 3)
+//	bytes 12..    metadata records (version 3)
+//	then          Pixels as 3*IconSize*IconSize uint16 values, or none for
+//	              empty icons
+//
+// A metadata record is a tag byte, a length byte and a value of that
+// length. Records of unknown tags are skipped, so that metadata can
+// be extended within version 3. The text form is the binary form in
+// unpadded base64url, which is also the form used for JSON (as a
+// string).
+const (
+	iconEncodingVersion  = 1
+	iconEncodingVersion2 = 2
+	iconEncodingVersion3 = 3
+	iconHeaderLen        = 9
+)
+
+// Tags of metadata records.
+const (
+	metaTagRange     = 1 // IconMeta.Min and Max as 6 uint16 icon pixel values.
+	metaTagMean      = 2 // IconMeta.Mean as 3 uint16 icon pixel values.
+	metaTagFormat    = 3 // IconMeta.Format as a string.
+	metaTagBitDepth  = 4 // IconMeta.BitDepth as a byte.
+	metaTagThumbnail = 5 // IconMeta.Thumbnail, empty, when true.
+)
+
+// Bit of IconT.Linear in the normalization byte.
+const linearBit = 0x80
+
+// ErrInvalidEncoding is returned when a serialized icon cannot
+// be decoded, for example because of an unknown version header
+// or a truncated pixel array.
+var ErrInvalidEncoding = errors.New("images4: invalid icon encoding")
+
+// iconText is the encoding of icon texts. URL-safe so that icons
+// can be used in query strings and file names as is.
+var iconText = base64.RawURLEncoding
+
+// MarshalBinary encodes an icon in the compact binary form
+// with a version header. It implements encoding.BinaryMarshaler.
+// Icons must have no pixels or 3*IconSize*IconSize of them.
+func (icon IconT) MarshalBinary() ([]byte, error) {
+	if !validPixels(len(icon.Pixels)) {
+		return nil, ErrInvalidEncoding
+	}
+	data := make([]byte, iconHeaderLen, iconHeaderLen+2*len(icon.Pixels))
+	data[0] = iconEncodingVersion
+	binary.BigEndian.PutUint32(data[1:], uint32(int32(icon.ImgSize.X)))
+	binary.BigEndian.PutUint32(data[5:], uint32(int32(icon.ImgSize.Y)))
+	mode := byte(icon.Normalization)
+	if icon.Linear {
+		mode |= linearBit
+	}
+	switch {
+	case icon.Meta != nil:
+		data[0] = iconEncodingVersion3
+		data = append(data, mode, 0, 0)
+		data = appendMeta(data, icon.Meta)
+		binary.BigEndian.PutUint16(data[iconHeaderLen+1:],
+			uint16(len(data)-iconHeaderLen-3))
+	case mode != byte(NormalizeMinMax):
+		data[0] = iconEncodingVersion2
+		data = append(data, mode)
+	}
+	for _, p := range icon.Pixels {
+		data = append(data, byte(p>>8), byte(p))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes an icon encoded with MarshalBinary.
+// It implements encoding.BinaryUnmarshaler.
+func (icon *IconT) UnmarshalBinary(data []byte) error {
+	if len(data) < iconHeaderLen {
+		return ErrInvalidEncoding
+	}
+	var decoded IconT
+	decoded.ImgSize = image.Point{
+		int(int32(binary.BigEndian.Uint32(data[1:]))),
+		int(int32(binary.BigEndian.Uint32(data[5:])))}
+	version, rest := data[0], data[iconHeaderLen:]
+	switch version {
+	case iconEncodingVersion:
+	case iconEncodingVersion2, iconEncodingVersion3:
+		if len(rest) < 1 {
+			return ErrInvalidEncoding
+		}
+		decoded.Normalization = NormalizationMode(rest[0] &^ linearBit)
+		decoded.Linear = rest[0]&linearBit != 0
+		rest = rest[1:]
+	default:
+		return ErrInvalidEncoding
+	}
+	if version == iconEncodingVersion3 {
+		if len(rest) < 2 {
+			return ErrInvalidEncoding
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return ErrInvalidEncoding
+		}
+		meta, err := parseMeta(rest[2 : 2+n])
+		if err != nil {
+			return err
+		}
+		decoded.Meta, rest = meta, rest[2+n:]
+	}
+	if len(rest)%2 != 0 || !validPixels(len(rest)/2) {
+		return ErrInvalidEncoding
+	}
+	if n := len(rest) / 2; n > 0 {
+		decoded.Pixels = make([]uint16, n)
+		for i := range decoded.Pixels {
+			decoded.Pixels[i] = binary.BigEndian.Uint16(rest[2*i:])
+		}
+	}
+	*icon = decoded
+	return nil
+}
+
+// appendMeta appends metadata records. Records of zero values
+// are omitted, except of channel ranges.
+func appendMeta(data []byte, meta *IconMeta) []byte {
+	data = append(data, metaTagRange, 12)
+	data = appendColors(data, meta.Min, meta.Max)
+	if meta.Mean != [3]float64{} {
+		data = append(data, metaTagMean, 6)
+		data = appendColors(data, meta.Mean)
+	}
+	if format := meta.Format; format != "" {
+		if len(format) > 255 {
+			format = format[:255]
+		}
+		data = append(data, metaTagFormat, byte(len(format)))
+		data = append(data, format...)
+	}
+	if meta.BitDepth > 0 && meta.BitDepth < 256 {
+		data = append(data, metaTagBitDepth, 1, byte(meta.BitDepth))
+	}
+	if meta.Thumbnail {
+		data = append(data, metaTagThumbnail, 0)
+	}
+	return data
+}
+
+// appendColors appends colors in [0, 255] as uint16 icon pixel
+// values.
+func appendColors(data []byte, colors ...[3]float64) []byte {
+	for _, values := range colors {
+		for _, v := range values {
+			p := uint16(math.Round(math.Max(0, math.Min(255, v)) * 255))
+			data = append(data, byte(p>>8), byte(p))
+		}
+	}
+	return data
+}
+
+// parseColors decodes colors written by func appendColors.
+func parseColors(data []byte, colors ...*[3]float64) {
+	for i, values := range colors {
+		for ch := range values {
+			values[ch] = float64(binary.BigEndian.Uint16(data[6*i+2*ch:])) * one255th
+		}
+	}
+}
+
+// parseMeta decodes metadata records.
+func parseMeta(data []byte) (*IconMeta, error) {
+	meta := new(IconMeta)
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, ErrInvalidEncoding
+		}
+		tag, value := data[0], data[2:2+int(data[1])]
+		data = data[2+len(value):]
+		switch tag {
+		case metaTagRange:
+			if len(value) != 12 {
+				return nil, ErrInvalidEncoding
+			}
+			parseColors(value, &meta.Min, &meta.Max)
+		case metaTagMean:
+			if len(value) != 6 {
+				return nil, ErrInvalidEncoding
+			}
+			parseColors(value, &meta.Mean)
+		case metaTagFormat:
+			meta.Format = string(value)
+		case metaTagBitDepth:
+			if len(value) != 1 {
+				return nil, ErrInvalidEncoding
+			}
+			meta.BitDepth = int(value[0])
+		case metaTagThumbnail:
+			meta.Thumbnail = true
+		}
+	}
+	return meta, nil
+}
+
+// MarshalText encodes an icon as an unpadded base64url string of
+// its binary form. It implements encoding.TextMarshaler.
+func (icon IconT) MarshalText() ([]byte, error) {
+	data, err := icon.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, iconText.EncodedLen(len(data)))
+	iconText.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText decodes an icon encoded with MarshalText.
+// It implements encoding.TextUnmarshaler.
+func (icon *IconT) UnmarshalText(text []byte) error {
+	data := make([]byte, iconText.DecodedLen(len(text)))
+	n, err := iconText.Decode(data, text)
+	if err != nil {
+		return ErrInvalidEncoding
+	}
+	return icon.UnmarshalBinary(data[:n])
+}
+
+// MarshalJSON encodes an icon as a JSON string holding its text
+// form. It implements json.Marshaler.
+func (icon IconT) MarshalJSON() ([]byte, error) {
+	text, err := icon.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// validPixels tells whether n pixel values make an icon: none, as
+// of EmptyIcon, or all values of the 3 channels.
+func validPixels(n int) bool {
+	return n == 0 || n == 3*numPix
+}
+
+// legacyIcon is the JSON layout of IconT before it implemented
+// json.Marshaler, e.g. {"Pixels":[...],"ImgSize":{"X":1,"Y":2}}.
+type legacyIcon struct {
+	Pixels  []uint16
+	ImgSize image.Point
+}
+
+// UnmarshalJSON decodes an icon from its JSON string form. It
+// also accepts the legacy object form with a numeric Pixels array,
+// as well as a bare array of pixel values. It implements
+// json.Unmarshaler.
+func (icon *IconT) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return ErrInvalidEncoding
+	}
+	switch data[0] {
+	case 'n': // null leaves the icon unchanged, as for other types.
+		return nil
+	case '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		return icon.UnmarshalText([]byte(text))
+	case '{':
+		var legacy legacyIcon
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		if !validPixels(len(legacy.Pixels)) {
+			return ErrInvalidEncoding
+		}
+		*icon = IconT{Pixels: legacy.Pixels, ImgSize: legacy.ImgSize}
+		return nil
+	case '[':
+		var pixels []uint16
+		if err := json.Unmarshal(data, &pixels); err != nil {
+			return err
+		}
+		if !validPixels(len(pixels)) {
+			return ErrInvalidEncoding
+		}
+		*icon = IconT{Pixels: pixels}
+		return nil
+	}
+	return ErrInvalidEncoding
+}
@@ -0,0 +1,214 @@
+package images4
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func testIcon(fileName string, t *testing.T) IconT {
+	img, err := Open(path.Join("testdata", "euclidean", fileName))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	return Icon(img)
+}
+
+func TestIconJSONRoundTrip(t *testing.T) {
+	for _, fileName := range []string{
+		"large.jpg", "small.gif", "uniform-green.png"} {
+		icon := testIcon(fileName, t)
+
+		data, err := json.Marshal(icon)
+		if err != nil {
+			t.Fatal("Error marshalling icon:", err)
+		}
+		var decoded IconT
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal("Error unmarshalling icon:", err)
+		}
+		if !reflect.DeepEqual(icon, decoded) {
+			t.Errorf("Decoded icon differs from original for %v.", fileName)
+		}
+		m1, m2, m3 := EucMetric(icon, decoded)
+		if m1 != 0 || m2 != 0 || m3 != 0 {
+			t.Errorf("Expected zero EucMetric for %v, got %v, %v, %v.",
+				fileName, m1, m2, m3)
+		}
+	}
+}
+
+func TestIconTextRoundTrip(t *testing.T) {
+	icon := testIcon("distorted.jpg", t)
+	text, err := icon.MarshalText()
+	if err != nil {
+		t.Fatal("Error marshalling icon:", err)
+	}
+	var decoded IconT
+	if err := decoded.UnmarshalText(text); err != nil {
+		t.Fatal("Error unmarshalling icon:", err)
+	}
+	if !reflect.DeepEqual(icon, decoded) {
+		t.Errorf("Decoded icon differs from original.")
+	}
+
+	// Text form must be much more compact than the numeric array.
+	legacy, _ := json.Marshal(legacyIcon{icon.Pixels, icon.ImgSize})
+	if len(text) >= len(legacy) {
+		t.Errorf("Expected text form shorter than %d, got %d.",
+			len(legacy), len(text))
+	}
+}
+
+func TestIconEncodingVersions(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	withoutMeta := func(icon IconT) IconT {
+		icon.Meta = nil
+		return icon
+	}
+	percentile := CustomIcon(img, IconOptions{Normalization: NormalizePercentile})
+	linear := CustomIcon(img, IconOptions{Linear: true})
+	tables := []struct {
+		icon    IconT
+		version byte
+	}{
+		// Icons without metadata stay readable by older versions.
+		{withoutMeta(Icon(img)), iconEncodingVersion},
+		{withoutMeta(percentile), iconEncodingVersion2},
+		{percentile, iconEncodingVersion3},
+		{withoutMeta(linear), iconEncodingVersion2},
+		{linear, iconEncodingVersion3},
+		{Icon(img), iconEncodingVersion3},
+		{IconNN(img), iconEncodingVersion3},
+	}
+	for _, table := range tables {
+		icon := table.icon
+		data, err := icon.MarshalBinary()
+		if err != nil {
+			t.Fatal("Error marshalling icon:", err)
+		}
+		if data[0] != table.version {
+			t.Errorf("Expected version %d, got %d.", table.version, data[0])
+		}
+		var decoded IconT
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal("Error unmarshalling icon:", err)
+		}
+		if !reflect.DeepEqual(icon, decoded) {
+			t.Errorf("Version %d: decoded icon differs from original.", table.version)
+		}
+		if table.version == iconEncodingVersion {
+			continue
+		}
+		for _, n := range []int{iconHeaderLen, iconHeaderLen + 2, len(data) - 2*3*numPix - 1} {
+			if err := decoded.UnmarshalBinary(data[:n]); err != ErrInvalidEncoding {
+				t.Errorf("Version %d: expected ErrInvalidEncoding for %d bytes, got %v.",
+					table.version, n, err)
+			}
+		}
+	}
+
+	// Unknown metadata records are skipped.
+	icon := Icon(img)
+	data, _ := icon.MarshalBinary()
+	metaEnd := iconHeaderLen + 3 + int(binary.BigEndian.Uint16(data[iconHeaderLen+1:]))
+	extended := append(append(append([]byte{}, data[:metaEnd]...),
+		200, 3, 1, 2, 3), data[metaEnd:]...)
+	binary.BigEndian.PutUint16(extended[iconHeaderLen+1:], uint16(metaEnd-iconHeaderLen-3+5))
+	var decoded IconT
+	if err := decoded.UnmarshalBinary(extended); err != nil || !reflect.DeepEqual(icon, decoded) {
+		t.Errorf("Expected an unknown record to be skipped, got %v.", err)
+	}
+}
+
+func TestIconJSONLegacy(t *testing.T) {
+	icon := testIcon("small.jpg", t)
+
+	// Object form as produced before IconT implemented json.Marshaler.
+	data, _ := json.Marshal(legacyIcon{icon.Pixels, icon.ImgSize})
+	var decoded IconT
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal("Error unmarshalling legacy icon:", err)
+	}
+	icon.Meta = nil // The legacy form has no metadata.
+	if !reflect.DeepEqual(icon, decoded) {
+		t.Errorf("Decoded legacy icon differs from original.")
+	}
+
+	// Bare array of pixel values.
+	data, _ = json.Marshal(icon.Pixels)
+	decoded = IconT{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal("Error unmarshalling pixel array:", err)
+	}
+	m1, m2, m3 := EucMetric(icon, decoded)
+	if m1 != 0 || m2 != 0 || m3 != 0 {
+		t.Errorf("Expected zero EucMetric, got %v, %v, %v.", m1, m2, m3)
+	}
+}
+
+func TestIconEncodingEmpty(t *testing.T) {
+	data, err := json.Marshal(EmptyIcon())
+	if err != nil {
+		t.Fatal("Error marshalling empty icon:", err)
+	}
+	decoded := testIcon("small.jpg", t)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal("Error unmarshalling empty icon:", err)
+	}
+	if decoded.Pixels != nil {
+		t.Errorf("Expected nil Pixels, got %v.", decoded.Pixels)
+	}
+}
+
+func TestIconEncodingInvalid(t *testing.T) {
+	icon := testIcon("small.jpg", t)
+	data, _ := icon.MarshalBinary()
+
+	tables := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:5]},
+		{"odd pixel bytes", data[:len(data)-1]},
+		{"truncated pixels", data[:len(data)-2]},
+		{"extra pixels", append(append([]byte{}, data...), 0, 0)},
+		{"unknown version", append([]byte{99}, data[1:]...)},
+	}
+	for _, table := range tables {
+		var decoded IconT
+		if err := decoded.UnmarshalBinary(table.data); err != ErrInvalidEncoding {
+			t.Errorf("%s: expected ErrInvalidEncoding, got %v.",
+				table.name, err)
+		}
+	}
+
+	var decoded IconT
+	if err := decoded.UnmarshalText([]byte("not base64!")); err != ErrInvalidEncoding {
+		t.Errorf("Expected ErrInvalidEncoding, got %v.", err)
+	}
+	if err := json.Unmarshal([]byte("42"), &decoded); err == nil {
+		t.Errorf("Expected error for a JSON number.")
+	}
+	for _, legacy := range []string{`{"Pixels":[1,2,3]}`, `[1,2,3]`} {
+		if err := json.Unmarshal([]byte(legacy), &decoded); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("%s: expected ErrInvalidEncoding, got %v.", legacy, err)
+		}
+	}
+
+	// Icons which cannot be decoded are not encoded.
+	icon.Pixels = icon.Pixels[:10]
+	if _, err := icon.MarshalBinary(); err != ErrInvalidEncoding {
+		t.Errorf("Expected ErrInvalidEncoding for 10 pixels, got %v.", err)
+	}
+	if _, err := json.Marshal(icon); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Expected ErrInvalidEncoding from json.Marshal, got %v.", err)
+	}
+}
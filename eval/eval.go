@@ -0,0 +1,121 @@
+// Package eval measures how robust image comparison of package
+// images4 is to common modifications of images. It applies synthetic
+// transformations (JPEG recompression, resizing, brightness shifts,
+// crops, watermarks, blur, rotation) to a corpus of images, and
+// reports for each transformation and comparison function:
+//
+//   - the true positive rate: how often a transformed image is
+//     found similar to its original;
+//   - the false positive rate: how often a transformed image is
+//     found similar to the other (unrelated) images of the corpus.
+//
+// Images of the corpus should be distinct.
+package eval
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"text/tabwriter"
+
+	"github.com/vitali-fedulov/images4"
+)
+
+// Comparator is a named similarity function of icons.
+type Comparator struct {
+	Name    string
+	Similar func(iconA, iconB images4.IconT) bool
+}
+
+// Similar compares with func images4.Similar.
+func Similar() Comparator {
+	return Comparator{"Similar", images4.Similar}
+}
+
+// Similar90270 compares with func images4.Similar90270.
+func Similar90270() Comparator {
+	return Comparator{"Similar90270", images4.Similar90270}
+}
+
+// Custom compares with func images4.CustomSimilar and coefficients.
+func Custom(name string, coeff images4.CustomCoefficients) Comparator {
+	return Comparator{name, func(iconA, iconB images4.IconT) bool {
+		return images4.CustomSimilar(iconA, iconB, coeff)
+	}}
+}
+
+// Rate is a count of positive verdicts out of a number of trials.
+type Rate struct {
+	Positive, Total int
+}
+
+// Value returns the rate in [0, 1], or 0 for no trials.
+func (r Rate) Value() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Positive) / float64(r.Total)
+}
+
+// Result holds rates of one transformation and comparator.
+type Result struct {
+	Transform, Comparator string
+	TruePositive          Rate // Transformed vs original images.
+	FalsePositive         Rate // Transformed vs other images.
+}
+
+// Run transforms each image of the corpus by each transformation
+// and compares icons with each comparator. Results are ordered by
+// transformation, then by comparator.
+func Run(corpus []image.Image, transforms []Transform,
+	comparators []Comparator) ([]Result, error) {
+	originals := make([]images4.IconT, len(corpus))
+	for i, img := range corpus {
+		originals[i] = images4.Icon(img)
+	}
+	var results []Result
+	for _, t := range transforms {
+		transformed := make([]images4.IconT, len(corpus))
+		for i, img := range corpus {
+			out, err := t.Apply(img)
+			if err != nil {
+				return nil, fmt.Errorf("eval: %s of image %d: %v", t.Name, i, err)
+			}
+			transformed[i] = images4.Icon(out)
+		}
+		for _, c := range comparators {
+			r := Result{Transform: t.Name, Comparator: c.Name}
+			for i, icon := range transformed {
+				for j, original := range originals {
+					similar := c.Similar(original, icon)
+					if i == j {
+						r.TruePositive.Total++
+						if similar {
+							r.TruePositive.Positive++
+						}
+						continue
+					}
+					r.FalsePositive.Total++
+					if similar {
+						r.FalsePositive.Positive++
+					}
+				}
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// WriteTable writes results as an aligned text table.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Transform\tComparator\tTPR\tFPR\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%.3f (%d/%d)\t%.4f (%d/%d)\t\n",
+			r.Transform, r.Comparator,
+			r.TruePositive.Value(), r.TruePositive.Positive, r.TruePositive.Total,
+			r.FalsePositive.Value(), r.FalsePositive.Positive, r.FalsePositive.Total)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,114 @@
+package eval
+
+import (
+	"bytes"
+	"image"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vitali-fedulov/images4"
+)
+
+func corpus(t *testing.T) []image.Image {
+	var imgs []image.Image
+	for _, name := range []string{
+		"euclidean/large.jpg", "custom/1.jpg", "euclidean/distorted.jpg",
+		"euclidean/flipped.jpg", "euclidean/uniform-green.png",
+	} {
+		img, err := images4.Open(filepath.Join("..", "testdata", name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+func TestRun(t *testing.T) {
+	imgs := corpus(t)
+	results, err := Run(imgs, DefaultTransforms(),
+		[]Comparator{Similar(), Similar90270(),
+			Custom("strict", images4.CustomCoefficients{Y: 0.1, Cb: 0.1, Cr: 0.1, Prop: 0.1})})
+	if err != nil {
+		t.Fatal("Error running evaluation:", err)
+	}
+	if len(results) != 3*len(DefaultTransforms()) {
+		t.Fatalf("Expected a result per transform and comparator, got %d.", len(results))
+	}
+	byName := map[string]Result{}
+	for _, r := range results {
+		byName[r.Transform+" "+r.Comparator] = r
+		if r.TruePositive.Total != len(imgs) ||
+			r.FalsePositive.Total != len(imgs)*(len(imgs)-1) {
+			t.Errorf("Unexpected totals of %+v.", r)
+		}
+	}
+	for name, want := range map[string]float64{
+		"identity Similar":       1,
+		"identity strict":        1,
+		"jpeg-75 Similar":        1,
+		"resize-0.5 Similar":     1,
+		"rotate-90 Similar90270": 1,
+	} {
+		if got := byName[name].TruePositive.Value(); got != want {
+			t.Errorf("%s: expected TPR %v, got %v.", name, want, got)
+		}
+	}
+	if r := byName["identity Similar"]; r.FalsePositive.Positive != 0 {
+		t.Errorf("Unexpected false positives %+v.", r.FalsePositive)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, results[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 ||
+		!strings.HasPrefix(lines[1], "identity   Similar ") {
+		t.Errorf("Unexpected table:\n%s", buf.String())
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	src := corpus(t)[0] // 533x400.
+	for _, table := range []struct {
+		transform Transform
+		size      image.Point
+	}{
+		{Resize(0.5), image.Point{267, 200}},
+		{Resize(1.5), image.Point{800, 600}},
+		{Crop(0.1), image.Point{481, 360}},
+		{Rotate90(), image.Point{400, 533}},
+		{Blur(3), image.Point{533, 400}},
+		{Watermark(1), image.Point{533, 400}},
+		{Brightness(30), image.Point{533, 400}},
+		{JPEG(50), image.Point{533, 400}},
+	} {
+		img, err := table.transform.Apply(src)
+		if err != nil {
+			t.Fatalf("%s: %v", table.transform.Name, err)
+		}
+		if img.Bounds().Size() != table.size {
+			t.Errorf("%s: expected size %v, got %v.", table.transform.Name,
+				table.size, img.Bounds().Size())
+		}
+	}
+
+	// A uniform image stays uniform when blurred and resized.
+	uniform := image.NewUniform(image.White)
+	img := image.NewRGBA(image.Rect(0, 0, 9, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 9; x++ {
+			img.Set(x, y, uniform.C)
+		}
+	}
+	for _, tr := range []Transform{Blur(2), Resize(0.4), Resize(2.5)} {
+		out, _ := tr.Apply(img)
+		rgba := out.(*image.RGBA)
+		for _, v := range rgba.Pix {
+			if v != 255 {
+				t.Fatalf("%s: pixel value %d is not white.", tr.Name, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+)
+
+// Transform is a synthetic modification of an image, which should
+// not change whether it is perceived as the same image.
+type Transform struct {
+	Name  string
+	Apply func(img image.Image) (image.Image, error)
+}
+
+// DefaultTransforms is a suite of common modifications of images
+// shared on the web or stored in photo libraries.
+func DefaultTransforms() []Transform {
+	return []Transform{
+		Identity(),
+		JPEG(75),
+		JPEG(30),
+		Resize(0.5),
+		Resize(0.25),
+		Brightness(20),
+		Brightness(-20),
+		Crop(0.05),
+		Crop(0.15),
+		Watermark(0.5),
+		Blur(2),
+		Rotate90(),
+	}
+}
+
+// Identity returns images unchanged, as a baseline.
+func Identity() Transform {
+	return Transform{"identity", func(img image.Image) (image.Image, error) {
+		return img, nil
+	}}
+}
+
+// JPEG recompresses images with the quality from 1 to 100.
+func JPEG(quality int) Transform {
+	return Transform{fmt.Sprintf("jpeg-%d", quality), func(img image.Image) (image.Image, error) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		return jpeg.Decode(&buf)
+	}}
+}
+
+// Resize scales images by a factor, with area averaging when
+// downscaling and bilinear interpolation when upscaling.
+func Resize(factor float64) Transform {
+	return Transform{fmt.Sprintf("resize-%g", factor), func(img image.Image) (image.Image, error) {
+		src := toRGBA(img)
+		w := int(math.Max(1, math.Round(float64(src.Rect.Dx())*factor)))
+		h := int(math.Max(1, math.Round(float64(src.Rect.Dy())*factor)))
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		sx := float64(src.Rect.Dx()) / float64(w)
+		sy := float64(src.Rect.Dy()) / float64(h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if factor < 1 {
+					dst.SetRGBA(x, y, average(src, image.Rect(
+						int(float64(x)*sx), int(float64(y)*sy),
+						int(math.Ceil(float64(x+1)*sx)), int(math.Ceil(float64(y+1)*sy)))))
+				} else {
+					dst.SetRGBA(x, y, bilinear(src,
+						(float64(x)+0.5)*sx-0.5, (float64(y)+0.5)*sy-0.5))
+				}
+			}
+		}
+		return dst, nil
+	}}
+}
+
+// Brightness adds delta to all RGB values.
+func Brightness(delta int) Transform {
+	return Transform{fmt.Sprintf("brightness%+d", delta), func(img image.Image) (image.Image, error) {
+		dst := toRGBA(img)
+		for i := 0; i < len(dst.Pix); i += 4 {
+			for c := i; c < i+3; c++ {
+				dst.Pix[c] = clamp(float64(int(dst.Pix[c]) + delta))
+			}
+		}
+		return dst, nil
+	}}
+}
+
+// Crop removes the fraction of width and height from the image
+// borders, keeping the center.
+func Crop(fraction float64) Transform {
+	return Transform{fmt.Sprintf("crop-%g", fraction), func(img image.Image) (image.Image, error) {
+		src := toRGBA(img)
+		dx := int(float64(src.Rect.Dx()) * fraction / 2)
+		dy := int(float64(src.Rect.Dy()) * fraction / 2)
+		r := image.Rect(src.Rect.Min.X+dx, src.Rect.Min.Y+dy,
+			src.Rect.Max.X-dx, src.Rect.Max.Y-dy)
+		return src.SubImage(r), nil
+	}}
+}
+
+// Watermark blends a white banner with dark stripes, imitating
+// text, into the lower right corner with the opacity from 0 to 1.
+func Watermark(opacity float64) Transform {
+	return Transform{fmt.Sprintf("watermark-%g", opacity), func(img image.Image) (image.Image, error) {
+		dst := toRGBA(img)
+		w, h := dst.Rect.Dx(), dst.Rect.Dy()
+		banner := image.Rect(w*5/8, h*13/16, w*15/16, h*15/16)
+		a := uint8(255 * opacity)
+		white := image.NewUniform(color.NRGBA{255, 255, 255, a})
+		dark := image.NewUniform(color.NRGBA{40, 40, 40, a})
+		draw.Draw(dst, banner, white, image.Point{}, draw.Over)
+		stripe := banner.Dx() / 12
+		for x := banner.Min.X + stripe; x+stripe <= banner.Max.X-stripe; x += 2 * stripe {
+			r := image.Rect(x, banner.Min.Y+banner.Dy()/4, x+stripe, banner.Max.Y-banner.Dy()/4)
+			draw.Draw(dst, r, dark, image.Point{}, draw.Over)
+		}
+		return dst, nil
+	}}
+}
+
+// Blur applies a box blur of the radius in pixels, horizontally
+// and vertically.
+func Blur(radius int) Transform {
+	return Transform{fmt.Sprintf("blur-%d", radius), func(img image.Image) (image.Image, error) {
+		src := toRGBA(img)
+		tmp := boxBlur(src, radius, 4, src.Stride)
+		return boxBlur(tmp, radius, src.Stride, 4), nil
+	}}
+}
+
+// Rotate90 rotates images by 90 degrees clockwise. Func Similar
+// is not expected to match them, while Similar90270 is.
+func Rotate90() Transform {
+	return Transform{"rotate-90", func(img image.Image) (image.Image, error) {
+		src := toRGBA(img)
+		w, h := src.Rect.Dx(), src.Rect.Dy()
+		dst := image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				dst.SetRGBA(h-1-y, x, src.RGBAAt(x, y))
+			}
+		}
+		return dst, nil
+	}}
+}
+
+// toRGBA copies an image to a new RGBA image with bounds at 0, 0.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// boxBlur averages pixels within the radius along one direction,
+// given by the byte steps between neighbours along (step) and
+// across (cross) that direction.
+func boxBlur(src *image.RGBA, radius, step, cross int) *image.RGBA {
+	dst := image.NewRGBA(src.Rect)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	n, lines := w, h
+	if step != 4 {
+		n, lines = h, w
+	}
+	for line := 0; line < lines; line++ {
+		base := line * cross
+		for i := 0; i < n; i++ {
+			var sum [4]int
+			count := 0
+			for k := i - radius; k <= i+radius; k++ {
+				if k < 0 || k >= n {
+					continue
+				}
+				p := base + k*step
+				for c := 0; c < 4; c++ {
+					sum[c] += int(src.Pix[p+c])
+				}
+				count++
+			}
+			p := base + i*step
+			for c := 0; c < 4; c++ {
+				dst.Pix[p+c] = uint8((sum[c] + count/2) / count)
+			}
+		}
+	}
+	return dst
+}
+
+func average(src *image.RGBA, r image.Rectangle) color.RGBA {
+	r = r.Intersect(src.Rect)
+	var sum [4]int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := src.RGBAAt(x, y)
+			sum[0] += int(c.R)
+			sum[1] += int(c.G)
+			sum[2] += int(c.B)
+			sum[3] += int(c.A)
+		}
+	}
+	n := r.Dx() * r.Dy()
+	if n == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n),
+		uint8((sum[2] + n/2) / n), uint8((sum[3] + n/2) / n)}
+}
+
+func bilinear(src *image.RGBA, x, y float64) color.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	x = math.Max(0, math.Min(x, float64(w-1)))
+	y = math.Max(0, math.Min(y, float64(h-1)))
+	x0, y0 := int(x), int(y)
+	x1, y1 := x0+1, y0+1
+	if x1 >= w {
+		x1 = x0
+	}
+	if y1 >= h {
+		y1 = y0
+	}
+	fx, fy := x-float64(x0), y-float64(y0)
+	var out [4]float64
+	for _, p := range []struct {
+		x, y int
+		f    float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)}, {x1, y0, fx * (1 - fy)},
+		{x0, y1, (1 - fx) * fy}, {x1, y1, fx * fy},
+	} {
+		c := src.RGBAAt(p.x, p.y)
+		out[0] += p.f * float64(c.R)
+		out[1] += p.f * float64(c.G)
+		out[2] += p.f * float64(c.B)
+		out[3] += p.f * float64(c.A)
+	}
+	return color.RGBA{clamp(out[0]), clamp(out[1]), clamp(out[2]), clamp(out[3])}
+}
+
+func clamp(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
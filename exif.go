@@ -0,0 +1,131 @@
+package images4
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Reader of the few EXIF fields used by the package, from JPEG
+// APP1 segments and from TIFF files, which share the format.
+
+// EXIF tags.
+const (
+	exifDateTime         = 0x0132
+	exifIFDPointer       = 0x8769
+	exifDateTimeOriginal = 0x9003
+)
+
+// exifInfo holds EXIF fields. Zero values mean missing fields.
+type exifInfo struct {
+	date time.Time // DateTimeOriginal, or else DateTime.
+}
+
+// readEXIF reads EXIF fields of a JPEG or TIFF file. It returns
+// false when there is no valid EXIF data.
+func readEXIF(data []byte) (info exifInfo, ok bool) {
+	tiff := data
+	if len(data) >= 2 && data[0] == 0xff && data[1] == jpegSOI {
+		if tiff = jpegEXIF(data); tiff == nil {
+			return info, false
+		}
+	}
+	if len(tiff) < 8 {
+		return info, false
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II\x2A\x00":
+		bo = binary.LittleEndian
+	case "MM\x00\x2A":
+		bo = binary.BigEndian
+	default:
+		return info, false
+	}
+
+	var dateTime, dateTimeOriginal string
+	ifd0 := bo.Uint32(tiff[4:])
+	ok = exifIFD(tiff, bo, ifd0, func(tag uint16, value []byte, count uint32, typ uint16) {
+		switch {
+		case tag == exifDateTime && typ == 2:
+			dateTime = string(value)
+		case tag == exifIFDPointer && typ == 4 && count == 1:
+			exifIFD(tiff, bo, bo.Uint32(value), func(tag uint16, value []byte, count uint32, typ uint16) {
+				if tag == exifDateTimeOriginal && typ == 2 {
+					dateTimeOriginal = string(value)
+				}
+			})
+		}
+	})
+	for _, s := range []string{dateTimeOriginal, dateTime} {
+		// Values are "YYYY:MM:DD HH:MM:SS" with a terminating zero.
+		if len(s) >= 19 {
+			if t, err := time.Parse("2006:01:02 15:04:05", s[:19]); err == nil {
+				info.date = t
+				break
+			}
+		}
+	}
+	return info, ok
+}
+
+// jpegEXIF returns the TIFF structured payload of the EXIF APP1
+// segment of a JPEG file, or nil.
+func jpegEXIF(data []byte) []byte {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xff {
+		marker := data[pos+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		seg := data[pos+4 : pos+2+length]
+		if marker == 0xe1 && len(seg) >= 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return seg[6:]
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+// exifIFD calls fn with the raw value of each entry of an image
+// file directory. Entries of unknown types are skipped.
+func exifIFD(tiff []byte, bo binary.ByteOrder, offset uint32,
+	fn func(tag uint16, value []byte, count uint32, typ uint16)) bool {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return false
+	}
+	n := uint64(bo.Uint16(tiff[offset:]))
+	if uint64(offset)+2+12*n > uint64(len(tiff)) {
+		return false
+	}
+	for i := uint64(0); i < n; i++ {
+		entry := tiff[uint64(offset)+2+12*i:]
+		typ := bo.Uint16(entry[2:])
+		count := bo.Uint32(entry[4:])
+		var size uint64
+		switch typ {
+		case 1, 2, 7: // BYTE, ASCII, UNDEFINED.
+			size = 1
+		case 3: // SHORT.
+			size = 2
+		case 4: // LONG.
+			size = 4
+		default:
+			continue
+		}
+		total := size * uint64(count)
+		value := entry[8:12]
+		if total > 4 {
+			off := uint64(bo.Uint32(entry[8:]))
+			if off+total > uint64(len(tiff)) {
+				continue
+			}
+			value = tiff[off : off+total]
+		}
+		fn(bo.Uint16(entry), value[:total], count, typ)
+	}
+	return true
+}
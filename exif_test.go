@@ -0,0 +1,62 @@
+package images4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// exifJPEG encodes a small JPEG image with an EXIF segment holding
+// the date as DateTimeOriginal.
+func exifJPEG(t testing.TB, date string) []byte {
+	bo := binary.BigEndian
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	// IFD0 at 8: EXIF IFD pointer.
+	tiff = append(tiff, 0, 1)
+	tiff = append(tiff, 0x87, 0x69, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0)
+	bo.PutUint32(tiff[len(tiff)-4:], uint32(len(tiff)+4))
+	tiff = append(tiff, 0, 0, 0, 0) // No next IFD.
+	// EXIF IFD: DateTimeOriginal, value following the IFD.
+	tiff = append(tiff, 0, 1)
+	tiff = append(tiff, 0x90, 0x03, 0, 2, 0, 0, 0, 20, 0, 0, 0, 0)
+	bo.PutUint32(tiff[len(tiff)-4:], uint32(len(tiff)+4))
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, date+"\x00"...)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 8)), nil); err != nil {
+		t.Fatal("Error encoding JPEG:", err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, 0xff, 0xe1, byte((len(app1)+2)>>8), byte(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestReadEXIF(t *testing.T) {
+	info, ok := readEXIF(exifJPEG(t, "2019:07:14 10:30:00"))
+	if !ok {
+		t.Fatal("EXIF not found.")
+	}
+	want := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	if !info.date.Equal(want) {
+		t.Errorf("Expected date %v, got %+v.", want, info)
+	}
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	if _, ok := readEXIF(buf.Bytes()); ok {
+		t.Error("Unexpected EXIF in a plain JPEG.")
+	}
+	// Truncated EXIF data must not panic. TIFF data follows SOI,
+	// the APP1 header and "Exif\x00\x00".
+	tiff := exifJPEG(t, "2019:07:14 10:30:00")[12:]
+	for n := 0; n < 80; n++ {
+		readEXIF(tiff[:n])
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/vitali-fedulov/images4
 
-go 1.16
+go 1.18
@@ -3,6 +3,9 @@ package images4
 import (
 	"image"
 	"image/color"
+	"image/draw"
+	"math"
+	"sync"
 )
 
 // Icon has square shape. Its pixels are uint16 values
@@ -12,6 +15,14 @@ import (
 type IconT struct {
 	Pixels  []uint16    // Visual signature.
 	ImgSize image.Point // Original image size.
+	// Normalization of pixels. Icons of func Icon have the zero
+	// value NormalizeMinMax.
+	Normalization NormalizationMode
+	// Linear tells that colors were averaged in linear light, see
+	// IconOptions.Linear.
+	Linear bool
+	// Meta is information about the image, nil when unknown.
+	Meta *IconMeta
 }
 
 // Icon generates a normalized image signature ("icon").
@@ -20,7 +31,7 @@ type IconT struct {
 // vs less robust func IconNN.
 func Icon(img image.Image) IconT {
 
-	icon := IconNN(img)
+	icon := iconNN(img, IconOptions{})
 
 	// Maximizing icon contrast. This to reflect on the human visual
 	// experience, when high contrast (normalized) images are easier
@@ -38,34 +49,87 @@ func Icon(img image.Image) IconT {
 // better understand how the algorithm works, or performing
 // less agressive customized normalization. Not for general use.
 func IconNN(img image.Image) IconT {
+	icon := iconNN(img, IconOptions{})
+	icon.Normalization = NormalizeNone
+	return icon
+}
+
+// IconOptions configure func CustomIcon. The zero value gives
+// icons of func Icon.
+type IconOptions struct {
+	// Linear averages colors in linear light: sRGB values are
+	// decoded before averaging and encoded after. Plain averaging
+	// of sRGB values darkens fine high-contrast detail, such as
+	// text and fences, more in larger images. Icons record it, and
+	// icons made with and without Linear are never similar.
+	Linear bool
+	// Normalization of icon channels, see NormalizationMode.
+	Normalization NormalizationMode
+}
+
+// CustomIcon is like func Icon with options.
+func CustomIcon(img image.Image, opts IconOptions) IconT {
+	icon := iconNN(img, opts)
+	icon.normalizeMode(opts.Normalization)
+	return icon
+}
+
+func iconNN(img image.Image, opts IconOptions) IconT {
+
+	// Color values are 16-bit. Those of 8-bit images are reduced
+	// to their top byte, as always, while high bit depth images
+	// keep their precision.
+	decode, shift := identity8[:], uint32(8)
+	if highBitDepth(img.ColorModel()) {
+		decode, shift = tables16().identity[:], 0
+		if opts.Linear {
+			decode = tables16().linear[:]
+		}
+	} else if opts.Linear {
+		decode = linear8[:]
+	}
 
 	// Resizing to a large icon approximating average color
 	// values of the source image. YCbCr space is used instead
 	// of RGB for better results in image comparison.
-	resImg, imgSize := ResizeByNearest(
-		img, image.Point{resizedImgSize, resizedImgSize})
+	// Source pixels are sampled as by func ResizeByNearest, with
+	// srcX and srcY positions of a resized image of resizedImgSize.
+	bounds := img.Bounds()
+	imgSize := bounds.Size()
+	if r, ok := img.(*ReducedImage); ok {
+		imgSize = r.FullSize
+	}
+	var srcX, srcY [resizedImgSize]int
+	xScale := float64(bounds.Dx()) / float64(resizedImgSize)
+	yScale := float64(bounds.Dy()) / float64(resizedImgSize)
+	for i := range srcX {
+		srcX[i] = int(float64(i)*xScale) + bounds.Min.X
+		srcY[i] = int(float64(i)*yScale) + bounds.Min.Y
+	}
+
 	largeIcon := sizedIcon(largeIconSize)
-	var r, g, b, sumR, sumG, sumB uint32
+	var r, g, b uint32
+	var sumR, sumG, sumB float64
 	var yc, cb, cr float64
 	// For each pixel of the largeIcon.
 	for x := 0; x < largeIconSize; x++ {
 		for y := 0; y < largeIconSize; y++ {
 			sumR, sumG, sumB = 0, 0, 0
-			// Sum over pixels of resImg.
+			// Sum over pixels of the resized image.
 			for m := 0; m < samples; m++ {
 				for n := 0; n < samples; n++ {
 					r, g, b, _ =
-						resImg.At(
-							x*samples+m, y*samples+n).RGBA()
-					sumR += r >> 8
-					sumG += g >> 8
-					sumB += b >> 8
+						img.At(
+							srcX[x*samples+m], srcY[y*samples+n]).RGBA()
+					sumR += decode[r>>shift]
+					sumG += decode[g>>shift]
+					sumB += decode[b>>shift]
 				}
 			}
 			Set(largeIcon, largeIconSize, image.Point{x, y},
-				float64(sumR)*invSamplePixels2,
-				float64(sumG)*invSamplePixels2,
-				float64(sumB)*invSamplePixels2)
+				sumR*invSamplePixels2,
+				sumG*invSamplePixels2,
+				sumB*invSamplePixels2)
 		}
 	}
 
@@ -90,8 +154,11 @@ func IconNN(img image.Image) IconT {
 					s1, s2, s3 = s1+c1, s2+c2, s3+c3
 				}
 			}
-			yc, cb, cr = yCbCr(
-				s1*oneNinth, s2*oneNinth, s3*oneNinth)
+			s1, s2, s3 = s1*oneNinth, s2*oneNinth, s3*oneNinth
+			if opts.Linear {
+				s1, s2, s3 = linearToSRGB(s1), linearToSRGB(s2), linearToSRGB(s3)
+			}
+			yc, cb, cr = yCbCr(s1, s2, s3)
 			Set(icon, IconSize, image.Point{xd, yd},
 				yc, cb, cr)
 			s1, s2, s3 = 0, 0, 0
@@ -99,9 +166,75 @@ func IconNN(img image.Image) IconT {
 	}
 
 	icon.ImgSize = imgSize
+	icon.Linear = opts.Linear
+	icon.Meta = newIconMeta(icon)
+	icon.Meta.BitDepth = 8
+	if shift == 0 {
+		icon.Meta.BitDepth = 16
+	}
+	_, icon.Meta.Thumbnail = img.(*ReducedImage)
 	return icon
 }
 
+// identity8 and linear8 decode 8-bit color values for averaging,
+// in the [0, 255] range: as is, and from sRGB to linear light.
+var identity8, linear8 = func() (identity, linear [256]float64) {
+	for i := range identity {
+		identity[i] = float64(i)
+		linear[i] = 255 * sRGBToLinear(float64(i)/255)
+	}
+	return identity, linear
+}()
+
+// decodeTables16 are as identity8 and linear8, for 16-bit values.
+type decodeTables16 struct {
+	identity, linear [65536]float64
+}
+
+var (
+	tables16Once sync.Once
+	tables16Ptr  *decodeTables16
+)
+
+// tables16 makes 16-bit tables on first use only, because they are
+// needed for high bit depth images only.
+func tables16() *decodeTables16 {
+	tables16Once.Do(func() {
+		t := new(decodeTables16)
+		for i := range t.identity {
+			t.identity[i] = float64(i) / 257
+			t.linear[i] = 255 * sRGBToLinear(float64(i)/65535)
+		}
+		tables16Ptr = t
+	})
+	return tables16Ptr
+}
+
+// highBitDepth tells whether colors of a model have more than
+// 8 bits per channel.
+func highBitDepth(m color.Model) bool {
+	return m == color.RGBA64Model || m == color.NRGBA64Model ||
+		m == color.Gray16Model
+}
+
+// sRGBToLinear decodes an sRGB value in the [0, 1] range.
+func sRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB encodes a linear light value to sRGB,
+// both in the [0, 255] range.
+func linearToSRGB(v float64) float64 {
+	v /= 255
+	if v <= 0.0031308 {
+		return 255 * 12.92 * v
+	}
+	return 255 * (1.055*math.Pow(v, 1/2.4) - 0.055)
+}
+
 // EmptyIcon is an icon constructor in case you need an icon
 // with nil values, for example for convenient error handling.
 // Then you can use icon.Pixels == nil condition.
@@ -155,6 +288,15 @@ func yCbCr(r, g, b float64) (yc, cb, cr float64) {
 	return yc, cb, cr
 }
 
+// rgb is the inverse of func yCbCr. Results may fall outside
+// the [0, 255] range and need clamping for display.
+func rgb(yc, cb, cr float64) (r, g, b float64) {
+	r = yc + 1.402000*(cr-128)
+	g = yc - 0.344136*(cb-128) - 0.714136*(cr-128)
+	b = yc + 1.772000*(cb-128)
+	return r, g, b
+}
+
 // Normalize stretches histograms for the 3 channels of an icon, so that
 // min/max values of each channel are 0/255 correspondingly.
 // Note: values of IconT are premultiplied by 255, thus having maximum
@@ -221,7 +363,9 @@ func (src IconT) normalize() {
 }
 
 // ToRGBA transforms a sized icon to image.RGBA. This is
-// an auxiliary function to visually evaluate an icon.
+// an auxiliary function to visually evaluate an icon. Channel
+// values are drawn as RGB without conversion, so colors are false.
+// See func Render for true colors.
 func (icon IconT) ToRGBA(size int) *image.RGBA {
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
 	for x := 0; x < size; x++ {
@@ -234,21 +378,90 @@ func (icon IconT) ToRGBA(size int) *image.RGBA {
 	return img
 }
 
-// Rotate rotates an icon by 90 degrees clockwise.
-func Rotate90(icon IconT) IconT {
+// RenderOptions configures func Render.
+type RenderOptions struct {
+	// Scale enlarges each icon pixel to Scale x Scale pixels
+	// (nearest neighbour) for human inspection. 0 means 1.
+	Scale int
+	// NonNormalized renders icons, which hold the YCbCr averages
+	// of the source image, in their original colors. It is implied
+	// for icons of func IconNN and modes NormalizeNone and
+	// NormalizeLuma. Otherwise icons are taken as normalized by
+	// func Icon.
+	NonNormalized bool
+}
+
+// Chroma compression of normalized icons, for func Render. It maps
+// a stretched chroma channel to ±32 around neutral, about the chroma
+// range of an icon of a photo.
+const chromaCompression = 0.25
 
-	var c1, c2, c3 float64
-	rotated := sizedIcon(IconSize)
+// Render transforms an icon to an RGBA image, converting YCbCr
+// channels back to RGB. Normalization of func Icon stretches each
+// non-uniform channel to the full range and cannot be inverted.
+// Luma is rendered stretched, as it is compared. Stretched chroma
+// would render with exaggerated saturation, so it is compressed
+// around neutral gray by chromaCompression. Uniform channels are
+// not changed by normalization and render exactly. For icons of
+// func IconNN set opts.NonNormalized to render original colors.
+// Icons with Pixels of a wrong length, such as EmptyIcon, render
+// as neutral gray.
+func (icon IconT) Render(opts RenderOptions) *image.RGBA {
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, IconSize*scale, IconSize*scale))
+	if len(icon.Pixels) != 3*numPix {
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{128, 128, 128, 255}),
+			image.Point{}, draw.Src)
+		return img
+	}
+	var compress [3]bool
+	if !opts.NonNormalized && icon.Normalization != NormalizeNone &&
+		icon.Normalization != NormalizeLuma {
+		for ch := 1; ch < 3; ch++ {
+			compress[ch] = icon.stretched(ch)
+		}
+	}
 	for x := 0; x < IconSize; x++ {
 		for y := 0; y < IconSize; y++ {
-			c1, c2, c3 = Get(icon, IconSize, image.Point{y, IconSize - 1 - x})
-			Set(rotated, IconSize, image.Point{x, y},
-				c1, c2, c3)
+			var c [3]float64
+			c[0], c[1], c[2] = Get(icon, IconSize, image.Point{x, y})
+			for ch := 1; ch < 3; ch++ {
+				if compress[ch] {
+					c[ch] = 128 + (c[ch]-128)*chromaCompression
+				}
+			}
+			rgba := rgbaFromYCbCr(c[0], c[1], c[2])
+			for v := y * scale; v < (y+1)*scale; v++ {
+				for u := x * scale; u < (x+1)*scale; u++ {
+					img.SetRGBA(u, v, rgba)
+				}
+			}
 		}
 	}
+	return img
+}
 
-	// Swap image sizes.
-	rotated.ImgSize.X, rotated.ImgSize.Y = icon.ImgSize.Y, icon.ImgSize.X
+// stretched tells whether normalization stretched channel ch
+// to the full range, with a tolerance of 1 display level.
+func (icon IconT) stretched(ch int) bool {
+	var cMin, cMax uint16 = maxUint16, 0
+	for _, c := range icon.Pixels[ch*numPix : (ch+1)*numPix] {
+		if c < cMin {
+			cMin = c
+		}
+		if c > cMax {
+			cMax = c
+		}
+	}
+	return cMin == 0 && cMax > sq255-255
+}
 
-	return rotated
+// Rotate rotates an icon by 90 degrees clockwise.
+// Malformed icons, with Pixels of a wrong length, only get
+// their image size swapped.
+func Rotate90(icon IconT) IconT {
+	return turn(icon, Rotation90, nil)
 }
@@ -2,6 +2,8 @@ package images4
 
 import (
 	"image"
+	"image/color"
+	"image/draw"
 	"math"
 	"path"
 	"reflect"
@@ -20,7 +22,7 @@ func TestSizedIcon(t *testing.T) {
 
 func TestEmptyIcon(t *testing.T) {
 	icon1 := EmptyIcon()
-	icon2 := IconT{nil, image.Point{0, 0}}
+	icon2 := IconT{Pixels: nil, ImgSize: image.Point{0, 0}}
 
 	if !reflect.DeepEqual(icon1.Pixels, icon2.Pixels) {
 		t.Errorf("Icons' Pixels mismatch. They must be equal: %v %v",
@@ -169,3 +171,207 @@ func TestRotate(t *testing.T) {
 		return
 	}
 }
+
+func TestRGB(t *testing.T) {
+	for _, c := range [][3]float64{{0, 0, 0}, {255, 255, 255}, {10, 200, 90}, {255, 0, 128}} {
+		r, g, b := rgb(yCbCr(c[0], c[1], c[2]))
+		if math.Abs(r-c[0]) > 0.01 || math.Abs(g-c[1]) > 0.01 || math.Abs(b-c[2]) > 0.01 {
+			t.Errorf("Expected %v, got %v, %v, %v.", c, r, g, b)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	want := color.RGBA{200, 100, 50, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(img, img.Bounds(), &image.Uniform{want}, image.Point{}, draw.Src)
+
+	// Uniform channels are not changed by normalization.
+	for _, table := range []struct {
+		icon IconT
+		opts RenderOptions
+	}{
+		{IconNN(img), RenderOptions{NonNormalized: true}},
+		{Icon(img), RenderOptions{Scale: 3}},
+	} {
+		rendered := table.icon.Render(table.opts)
+		size := IconSize * table.opts.Scale
+		if table.opts.Scale == 0 {
+			size = IconSize
+		}
+		if rendered.Bounds() != image.Rect(0, 0, size, size) {
+			t.Errorf("Unexpected bounds %v.", rendered.Bounds())
+		}
+		for _, p := range []image.Point{{0, 0}, {size / 2, size / 3}, {size - 1, size - 1}} {
+			if got := rendered.RGBAAt(p.X, p.Y); !closeRGBA(got, want, 2) {
+				t.Errorf("Expected %v at %v, got %v.", want, p, got)
+			}
+		}
+	}
+
+	// Stretched chroma of a normalized icon is compressed.
+	photo, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	icon := Icon(photo)
+	if !icon.stretched(1) || !icon.stretched(2) || IconNN(photo).stretched(1) {
+		t.Fatal("Unexpected stretched channels.")
+	}
+	rendered := icon.Render(RenderOptions{})
+	for i := 0; i < len(rendered.Pix); i += 4 {
+		yc, cb, cr := yCbCr(float64(rendered.Pix[i]),
+			float64(rendered.Pix[i+1]), float64(rendered.Pix[i+2]))
+		if yc > 1 && yc < 254 && (math.Abs(cb-128) > 34 || math.Abs(cr-128) > 34) {
+			t.Fatalf("Chroma %v, %v is not compressed.", cb, cr)
+		}
+	}
+
+	// Malformed icons render gray.
+	gray := color.RGBA{128, 128, 128, 255}
+	for _, malformed := range []IconT{EmptyIcon(), {Pixels: icon.Pixels[:10]}} {
+		if got := malformed.Render(RenderOptions{}).RGBAAt(5, 5); got != gray {
+			t.Errorf("Expected %v for %d pixels, got %v.", gray, len(malformed.Pixels), got)
+		}
+	}
+}
+
+func FuzzIcon(f *testing.F) {
+	f.Add(int16(0), int16(0), uint16(10), uint16(10), []byte{1, 2, 3, 255})
+	f.Add(int16(-100), int16(50), uint16(1), uint16(1), []byte{7})
+	f.Add(int16(1), int16(-1), uint16(1), uint16(60000), []byte{9, 200})
+	f.Add(int16(0), int16(0), uint16(0), uint16(0), []byte{})
+	f.Fuzz(func(t *testing.T, minX, minY int16, w, h uint16, data []byte) {
+		if int(w)*int(h) > 1<<16 {
+			return
+		}
+		img := fuzzImage(minX, minY, w, h, data)
+		for _, icon := range []IconT{Icon(img), IconNN(img)} {
+			if len(icon.Pixels) != 3*numPix {
+				t.Fatalf("Icon has %d values.", len(icon.Pixels))
+			}
+			if icon.ImgSize != (image.Point{int(w), int(h)}) {
+				t.Errorf("Expected image size %dx%d, got %v.", w, h, icon.ImgSize)
+			}
+			if !Similar(icon, icon) {
+				t.Error("Icon is not similar to itself.")
+			}
+		}
+	})
+}
+
+func TestCustomIconLinear(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(CustomIcon(img, IconOptions{}), Icon(img)) {
+		t.Error("CustomIcon with zero options differs from Icon.")
+	}
+	linear := CustomIcon(img, IconOptions{Linear: true})
+	if m1, _, _ := EucMetric(linear, Icon(img)); !linear.Linear || !math.IsInf(m1, 1) {
+		t.Errorf("Linear and non-linear icons must be at infinite distance, got %v.", m1)
+	}
+
+	// A fine black and white checkerboard is mid-gray 128 when
+	// averaged in sRGB, and 188 in linear light. Its size keeps
+	// all pixels in func ResizeByNearest.
+	board := image.NewGray(image.Rect(0, 0, resizedImgSize, resizedImgSize))
+	for i := range board.Pix {
+		if (i%resizedImgSize+i/resizedImgSize)%2 == 0 {
+			board.Pix[i] = 255
+		}
+	}
+	for _, c := range []struct {
+		opts IconOptions
+		want float64
+	}{{IconOptions{}, 127.5}, {IconOptions{Linear: true}, 187.5}} {
+		yc, _, _ := Get(iconNN(board, c.opts), IconSize, image.Point{5, 5})
+		if math.Abs(yc-c.want) > 1 {
+			t.Errorf("Linear %v: expected Y %v, got %v.", c.opts.Linear, c.want, yc)
+		}
+	}
+	gray := image.NewGray(image.Rect(0, 0, 50, 50))
+	for i := range gray.Pix {
+		gray.Pix[i] = 200
+	}
+	yc, _, _ := Get(CustomIcon(gray, IconOptions{Linear: true}), IconSize, image.Point{5, 5})
+	if math.Abs(yc-200) > 0.01 {
+		t.Errorf("Uniform color must not change, got Y %v.", yc)
+	}
+
+	// Scale invariance. Linear light is closer for images resized
+	// with the nearest neighbour method, but not for small.jpg,
+	// which was likely resized by averaging in sRGB.
+	for _, c := range []struct {
+		a, b           string
+		linearIsBetter bool
+	}{
+		{"euclidean/large.jpg", "euclidean/small.jpg", false},
+		{"resample/original.png", "resample/nearest100x100.png", true},
+		{"resample/original.png", "resample/nearest533x400.png", true},
+	} {
+		a, err := Open(path.Join("testdata", c.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Open(path.Join("testdata", c.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var y [2]float64
+		for i, opts := range []IconOptions{{}, {Linear: true}} {
+			iconA, iconB := CustomIcon(a, opts), CustomIcon(b, opts)
+			if !eucSimilar(iconA, iconB) {
+				t.Errorf("Icons of %s and %s must be similar, linear %v.", c.a, c.b, opts.Linear)
+			}
+			y[i], _, _ = EucMetric(iconA, iconB)
+		}
+		t.Logf("%s vs %s: Y metric %.0f in sRGB, %.0f in linear light.", c.a, c.b, y[0], y[1])
+		if (y[1] < y[0]) != c.linearIsBetter {
+			t.Errorf("%s vs %s: expected linear light better %v.", c.a, c.b, c.linearIsBetter)
+		}
+	}
+}
+
+func TestIconHighBitDepth(t *testing.T) {
+	src, err := Open(path.Join("testdata", "euclidean", "small.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	// The same 8-bit colors in a 16-bit image give the same icons.
+	deep := image.NewRGBA64(img.Bounds())
+	draw.Draw(deep, deep.Bounds(), img, img.Bounds().Min, draw.Src)
+	iconDeep, icon8 := IconNN(deep), IconNN(img)
+	if !reflect.DeepEqual(iconDeep.Pixels, icon8.Pixels) {
+		t.Error("Icons of 8-bit colors in 16-bit and 8-bit images differ.")
+	}
+	if iconDeep.Meta.BitDepth != 16 || icon8.Meta.BitDepth != 8 {
+		t.Errorf("Expected bit depths 16 and 8, got %d and %d.",
+			iconDeep.Meta.BitDepth, icon8.Meta.BitDepth)
+	}
+	linearA := CustomIcon(deep, IconOptions{Linear: true})
+	linearB := CustomIcon(img, IconOptions{Linear: true})
+	for i := range linearA.Pixels {
+		if absInt(int(linearA.Pixels[i])-int(linearB.Pixels[i])) > 1 {
+			t.Fatalf("Linear icons differ at %d: %d vs %d.",
+				i, linearA.Pixels[i], linearB.Pixels[i])
+		}
+	}
+
+	// A gradient within one 8-bit level is uniform in 8 bits only.
+	gradient := image.NewGray16(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			gradient.SetGray16(x, y, color.Gray16{uint16(0x8000 + x)})
+		}
+	}
+	icon := IconNN(gradient)
+	left, _, _ := Get(icon, IconSize, image.Point{0, 5})
+	right, _, _ := Get(icon, IconSize, image.Point{IconSize - 1, 5})
+	if !(right-left > 0.5 && right-left < 1) {
+		t.Errorf("Expected Y to grow by about 0.75, got %v to %v.", left, right)
+	}
+}
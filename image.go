@@ -1,32 +1,92 @@
 package images4
 
 import (
+	"bytes"
 	"image"
 	"image/color"
 	_ "image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 )
 
 // Open opens and decodes an image file for a given path.
+// Supported formats are JPEG, PNG, GIF, BMP, TIFF, PNM and WebP.
+// Errors are of type *OpenError. See also OpenReader, OpenBytes
+// and OpenFS.
 func Open(path string) (img image.Image, err error) {
-	file, err := os.Open(path)
+	return openFile(path, false)
+}
+
+// ReducedImage is an image decoded at a reduced resolution by
+// func DecodeReduced. FullSize is the size of the image at full
+// resolution. Func Icon records FullSize as the icon image size,
+// so that proportions of reduced and full images compare alike.
+type ReducedImage struct {
+	image.Image
+	FullSize image.Point
+}
+
+// DecodeReduced decodes an image at a resolution, which is still
+// sufficient for func Icon. Sequential JPEG images are decoded
+// with DCT scaling by 1/2, 1/4 or 1/8 (DC coefficients only),
+// for as long as both sides of the decoded image remain at least
+// 92 pixels, i.e. 4 pixels per pixel of the intermediate 23x23
+// icon. Progressive JPEG and other formats decode at full
+// resolution. The returned image is a *ReducedImage when reduced.
+//
+// Icons of reduced images are within 2% of the Similar thresholds
+// from icons of fully decoded images, in squared Euclidean
+// distance of each channel (measured on JPEG files in testdata,
+// with the maximum of 1%).
+func DecodeReduced(r io.Reader) (img image.Image, format string, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return decodeReduced(data)
+}
+
+func decodeReduced(data []byte) (img image.Image, format string, err error) {
+	w, h, err := jpegConfig(data)
 	if err != nil {
-		return nil, err
+		// Not a sequential JPEG image, or an invalid one.
+		return image.Decode(bytes.NewReader(data))
+	}
+	n := 8 // Decoded block size.
+	for n > 1 && w*n/2 >= 8*reducedMinSize && h*n/2 >= 8*reducedMinSize {
+		n /= 2
+	}
+	if n == 8 {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		return img, "jpeg", err
+	}
+	img, err = decodeJPEGScaled(data, n)
+	if err == errJPEGUnsupported {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		return img, "jpeg", err
 	}
-	defer file.Close()
-	img, _, err = image.Decode(file)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return img, err
+	return &ReducedImage{img, image.Point{w, h}}, "jpeg", nil
+}
+
+// OpenReduced is like func Open, but decodes images at a reduced
+// resolution with func DecodeReduced. It is faster than func Open
+// for large JPEG images, when only an icon is needed.
+func OpenReduced(path string) (img image.Image, err error) {
+	return openFile(path, true)
 }
 
 // ResizeByNearest resizes an image to the destination size
 // with the nearest neighbour method. It also returns the source
-// image size.
+// image size. Non-positive destination sizes give an empty image.
+// The result has 8 bits per channel. Func Icon samples images the
+// same way, but keeps 16 bits of high bit depth images.
 func ResizeByNearest(
 	src image.Image, dstSize image.Point) (
 	dst image.RGBA, srcSize image.Point) {
@@ -35,6 +95,9 @@ func ResizeByNearest(
 	yMax, yMin := src.Bounds().Max.Y, src.Bounds().Min.Y
 	srcX := xMax - xMin
 	srcY := yMax - yMin
+	if dstSize.X <= 0 || dstSize.Y <= 0 {
+		return *image.NewRGBA(image.Rectangle{}), image.Point{srcX, srcY}
+	}
 	xScale := float64(srcX) / float64(dstSize.X)
 	yScale := float64(srcY) / float64(dstSize.Y)
 
@@ -1,8 +1,13 @@
 package images4
 
 import (
+	"bytes"
 	"image"
+	"io"
+	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -47,3 +52,133 @@ func TestResizeByNearest(t *testing.T) {
 		}
 	}
 }
+
+// testDecodeFormat opens an image with func Open and compares
+// it pixel by pixel with a reference PNG image.
+func testDecodeFormat(fileName, refName, format string, t *testing.T) {
+	p := path.Join("testdata", "formats")
+	file, err := os.Open(path.Join(p, fileName))
+	if err != nil {
+		t.Fatal("Error opening file:", err)
+	}
+	defer file.Close()
+	config, name, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Errorf("Cannot decode config of %v: %v", fileName, err)
+		return
+	}
+	if name != format {
+		t.Errorf("Expected format %v for %v, got %v.", format, fileName, name)
+	}
+
+	img, err := Open(path.Join(p, fileName))
+	if err != nil {
+		t.Errorf("Cannot decode %v: %v", fileName, err)
+		return
+	}
+	ref, err := Open(path.Join(p, refName))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	if img.Bounds() != ref.Bounds() ||
+		config.Width != ref.Bounds().Dx() ||
+		config.Height != ref.Bounds().Dy() {
+		t.Errorf("Expected bounds %v for %v, got %v (config %dx%d).",
+			ref.Bounds(), fileName, img.Bounds(), config.Width, config.Height)
+		return
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r1, g1, b1, a1 := img.At(x, y).RGBA()
+			r2, g2, b2, a2 := ref.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Errorf("Pixel (%d, %d) of %v: expected %v, got %v.",
+					x, y, fileName, ref.At(x, y), img.At(x, y))
+				return
+			}
+		}
+	}
+}
+
+// fuzzSeeds adds testdata files matching a pattern to the seed
+// corpus of a fuzz test.
+func fuzzSeeds(f *testing.F, pattern string) {
+	files, _ := filepath.Glob(path.Join("testdata", "formats", pattern))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal("Error reading file:", err)
+		}
+		f.Add(data)
+	}
+}
+
+// fuzzDecode checks that a decoder does not panic on malformed
+// data, and that decoded images agree with their configs.
+func fuzzDecode(t *testing.T, data []byte,
+	decode func(io.Reader) (image.Image, error),
+	decodeConfig func(io.Reader) (image.Config, error)) {
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	if config.Width*config.Height > 1<<20 {
+		return // Valid, but too large for fuzzing.
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	if img.Bounds().Dx() != config.Width || img.Bounds().Dy() != config.Height {
+		t.Errorf("Config size %dx%d differs from image bounds %v.",
+			config.Width, config.Height, img.Bounds())
+	}
+	Icon(img)
+}
+
+// fuzzImage builds an RGBA image with arbitrary bounds, filled
+// with repeated data.
+func fuzzImage(minX, minY int16, w, h uint16, data []byte) *image.RGBA {
+	img := image.NewRGBA(image.Rect(int(minX), int(minY),
+		int(minX)+int(w), int(minY)+int(h)))
+	if len(data) > 0 {
+		for i := range img.Pix {
+			img.Pix[i] = data[i%len(data)]
+		}
+	}
+	return img
+}
+
+func FuzzResizeByNearest(f *testing.F) {
+	f.Add(int16(0), int16(0), uint16(10), uint16(10), int16(23), int16(23), []byte{1, 2, 3})
+	f.Add(int16(-5), int16(7), uint16(1), uint16(1), int16(100), int16(3), []byte{255})
+	f.Add(int16(3), int16(-3), uint16(4000), uint16(1), int16(-1), int16(5), []byte{})
+	f.Fuzz(func(t *testing.T, minX, minY int16, w, h uint16,
+		dstX, dstY int16, data []byte) {
+		if int(w)*int(h) > 1<<16 || int(dstX)*int(dstY) > 1<<16 {
+			return
+		}
+		src := fuzzImage(minX, minY, w, h, data)
+		dst, srcSize := ResizeByNearest(src, image.Point{int(dstX), int(dstY)})
+		if srcSize != (image.Point{int(w), int(h)}) {
+			t.Errorf("Expected source size %dx%d, got %v.", w, h, srcSize)
+		}
+		want := image.Rect(0, 0, int(dstX), int(dstY))
+		if dstX <= 0 || dstY <= 0 {
+			want = image.Rectangle{}
+		}
+		if dst.Bounds() != want {
+			t.Errorf("Expected bounds %v, got %v.", want, dst.Bounds())
+		}
+		// Samples come from the source, with opaque pixels only
+		// when the source is not empty.
+		if w > 0 && h > 0 && len(data) > 0 && dst.Bounds().Dx() > 0 {
+			c := dst.RGBAAt(0, 0)
+			if c != src.RGBAAt(int(minX), int(minY)) {
+				t.Errorf("Expected the top left pixel %v, got %v.",
+					src.RGBAAt(int(minX), int(minY)), c)
+			}
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package images4
+
+import (
+	"sort"
+	"sync"
+)
+
+// IndexOptions configure an Index. With the zero value, icons
+// are matched as by func Similar.
+type IndexOptions struct {
+	// Rotations matches images rotated by 90°, 180° and 270°,
+	// as func SimilarAnyRotation.
+	Rotations bool
+	// Mirrors also matches mirrored images, when Rotations is true.
+	Mirrors bool
+	// UniformSameColor matches uniform icons only to uniform icons
+	// of the same color, as func SimilarUniform. Their matches have
+	// Distance 0.
+	UniformSameColor bool
+}
+
+// Index is an in-memory collection of icons searchable by
+// similarity. Icons are compared one by one, so for large
+// collections consider hash prefiltering with package imagehash2.
+// An Index is safe for concurrent use.
+type Index struct {
+	opts    IndexOptions
+	mu      sync.RWMutex
+	entries map[string]OrientedIcon
+}
+
+// Match is an icon found in an Index.
+type Match struct {
+	ID string
+	// Rotation turning the query image to look like the
+	// indexed one. Always Rotation0 without IndexOptions.Rotations.
+	Rotation Rotation
+	// Distance is the sum of metrics of func EucMetric relative
+	// to the default thresholds. Smaller is more similar.
+	Distance float64
+}
+
+// NewIndex creates an empty index.
+func NewIndex(opts IndexOptions) *Index {
+	return &Index{opts: opts, entries: make(map[string]OrientedIcon)}
+}
+
+// Add puts an icon in the index, replacing an icon with the same id.
+func (x *Index) Add(id string, icon IconT) {
+	o := OrientedIcon{[]IconT{icon.ownMeta()}}
+	if x.opts.Rotations {
+		o = Orient(icon, x.opts.Mirrors)
+	}
+	x.mu.Lock()
+	x.entries[id] = o
+	x.mu.Unlock()
+}
+
+// Remove deletes an icon from the index. It reports whether
+// the icon was there.
+func (x *Index) Remove(id string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	_, ok := x.entries[id]
+	delete(x.entries, id)
+	return ok
+}
+
+// Icon returns the icon added with id.
+func (x *Index) Icon(id string) (IconT, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	o, ok := x.entries[id]
+	return o.Icon().ownMeta(), ok
+}
+
+// Len returns the number of icons in the index.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.entries)
+}
+
+// IDs returns ids of all icons in the index, sorted.
+func (x *Index) IDs() []string {
+	x.mu.RLock()
+	ids := make([]string, 0, len(x.entries))
+	for id := range x.entries {
+		ids = append(ids, id)
+	}
+	x.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// Search finds icons similar to the icon, the most similar first.
+func (x *Index) Search(icon IconT) []Match {
+	return x.search(icon, nil)
+}
+
+// CustomSearch is like Search, with thresholds of func
+// CustomSimilar.
+func (x *Index) CustomSearch(icon IconT, coeff CustomCoefficients) []Match {
+	return x.search(icon, &coeff)
+}
+
+func (x *Index) search(icon IconT, coeff *CustomCoefficients) []Match {
+	query := OrientedIcon{[]IconT{icon}}
+	if x.opts.Rotations {
+		query = Orient(icon, x.opts.Mirrors)
+	}
+
+	x.mu.RLock()
+	var matches []Match
+	for id, o := range x.entries {
+		if x.opts.UniformSameColor && (icon.IsUniform() || o.Icon().IsUniform()) {
+			if r, ok := uniformRotation(query, o.Icon(), coeff); ok {
+				matches = append(matches, Match{id, r, 0})
+			}
+			continue
+		}
+		r, ok := similarOriented(query, o, coeff)
+		if !ok {
+			continue
+		}
+		matches = append(matches,
+			Match{id, r, relativeDistance(query.Turned(r), o.Icon())})
+	}
+	x.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+// uniformRotation finds the first orientation of the query, in
+// which it matches an icon as by func similarUniform. Orientations
+// of uniform icons differ only in their proportions.
+func uniformRotation(query OrientedIcon, icon IconT,
+	coeff *CustomCoefficients) (Rotation, bool) {
+	for r, turned := range query.variants {
+		if similarUniform(turned, icon, coeff) {
+			return Rotation(r), true
+		}
+	}
+	return Rotation0, false
+}
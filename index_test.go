@@ -0,0 +1,118 @@
+package images4
+
+import (
+	"image"
+	"image/color"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	icons := map[string]IconT{}
+	for _, p := range []string{
+		"rotate/0.jpg", "rotate/90.jpg", "rotate/180.jpg",
+		"custom/1.jpg", "euclidean/uniform-green.png"} {
+		img, err := Open(path.Join("testdata", p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		icons[p] = Icon(img)
+	}
+
+	x := NewIndex(IndexOptions{})
+	var wg sync.WaitGroup
+	for p, icon := range icons {
+		wg.Add(1)
+		go func(p string, icon IconT) {
+			defer wg.Done()
+			x.Add(p, icon)
+		}(p, icon)
+	}
+	wg.Wait()
+	if x.Len() != len(icons) {
+		t.Fatalf("Expected %d icons, got %d.", len(icons), x.Len())
+	}
+	if ids := x.IDs(); ids[0] != "custom/1.jpg" {
+		t.Errorf("Expected sorted ids, got %v.", ids)
+	}
+
+	matches := x.Search(icons["rotate/0.jpg"])
+	if len(matches) != 1 || matches[0].ID != "rotate/0.jpg" ||
+		matches[0].Distance != 0 || matches[0].Rotation != Rotation0 {
+		t.Errorf("Expected an exact match of rotate/0.jpg, got %v.", matches)
+	}
+
+	x = NewIndex(IndexOptions{Rotations: true})
+	for p, icon := range icons {
+		x.Add(p, icon)
+	}
+	matches = x.Search(icons["rotate/0.jpg"])
+	want := []Match{{ID: "rotate/0.jpg"}, {ID: "rotate/90.jpg", Rotation: Rotation90},
+		{ID: "rotate/180.jpg", Rotation: Rotation180}}
+	if len(matches) != len(want) {
+		t.Fatalf("Expected %d matches, got %v.", len(want), matches)
+	}
+	for i := range want {
+		if matches[i].Rotation != want[i].Rotation {
+			t.Errorf("Expected %v, got %v.", want[i], matches[i])
+		}
+		if i > 0 && matches[i].Distance < matches[i-1].Distance {
+			t.Error("Matches are not sorted by distance.")
+		}
+	}
+	found := map[string]bool{}
+	for _, m := range matches {
+		found[m.ID] = true
+	}
+	for _, w := range want {
+		if !found[w.ID] {
+			t.Errorf("%s is not found.", w.ID)
+		}
+	}
+
+	if !x.Remove("rotate/90.jpg") || x.Remove("rotate/90.jpg") {
+		t.Error("Wrong result of Remove.")
+	}
+	if _, ok := x.Icon("rotate/90.jpg"); ok {
+		t.Error("Removed icon is still there.")
+	}
+	if icon, ok := x.Icon("rotate/0.jpg"); !ok || !Similar(icon, icons["rotate/0.jpg"]) {
+		t.Error("Icon differs from the added one.")
+	}
+
+	// Tight thresholds leave only the identical image.
+	if matches := x.CustomSearch(icons["rotate/0.jpg"],
+		CustomCoefficients{0, 0, 0, 0}); len(matches) != 1 {
+		t.Errorf("Expected 1 match, got %v.", matches)
+	}
+}
+
+func TestIndexUniformSameColor(t *testing.T) {
+	gray := Icon(nearUniform(color.RGBA{100, 100, 100, 255}))
+	gray.ImgSize = image.Point{100, 200}
+	light := Icon(nearUniform(color.RGBA{140, 140, 140, 255}))
+	light.ImgSize = gray.ImgSize
+	grayFlat := Icon(image.NewUniform(color.RGBA{102, 102, 102, 255}))
+	grayFlat.ImgSize = image.Point{200, 100}
+
+	for _, table := range []struct {
+		opts IndexOptions
+		want []Match
+	}{
+		{IndexOptions{Rotations: true}, []Match{{ID: "gray"}}},
+		{IndexOptions{UniformSameColor: true}, []Match{{ID: "gray"}}},
+		{IndexOptions{UniformSameColor: true, Rotations: true},
+			[]Match{{ID: "flat", Rotation: Rotation90}, {ID: "gray"}}},
+	} {
+		x := NewIndex(table.opts)
+		x.Add("gray", gray)
+		x.Add("light", light)
+		x.Add("flat", grayFlat)
+		x.Add("photo", testIcon("large.jpg", t))
+		if matches := x.Search(gray); !reflect.DeepEqual(matches, table.want) {
+			t.Errorf("%+v: expected %v, got %v.", table.opts, table.want, matches)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package images4
+
+import (
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+// Sequential (baseline and extended) Huffman JPEG decoder with DCT
+// scaling. Blocks of 8x8 pixels decode to 4x4, 2x2 or 1x1 pixels
+// by inverse transforms of only the low frequency coefficients,
+// which skips most of the work of a full decode. It serves func
+// DecodeReduced. Progressive, arithmetic coded, 12-bit, CMYK and
+// RGB JPEG files are reported with errJPEGUnsupported, and are
+// left to the image/jpeg package.
+
+var (
+	errJPEGFormat      = errors.New("images4: jpeg: invalid format")
+	errJPEGUnsupported = errors.New("images4: jpeg: unsupported format")
+)
+
+// JPEG markers.
+const (
+	jpegSOF0  = 0xc0 // Baseline.
+	jpegSOF1  = 0xc1 // Extended sequential, Huffman.
+	jpegDHT   = 0xc4
+	jpegRST0  = 0xd0
+	jpegRST7  = 0xd7
+	jpegSOI   = 0xd8
+	jpegEOI   = 0xd9
+	jpegSOS   = 0xda
+	jpegDQT   = 0xdb
+	jpegDRI   = 0xdd
+	jpegAPP14 = 0xee
+)
+
+// jpegUnzigzag maps zig-zag order to natural order of coefficients.
+var jpegUnzigzag = [64]uint8{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegHuffman is a Huffman table (JPEG specification, annex C).
+// Codes up to 8 bits are decoded with one lookup.
+type jpegHuffman struct {
+	lut     [256]uint16 // Value<<8 | code length, 0 for longer codes.
+	maxCode [18]int32   // Largest code of each length, -1 if none.
+	valPtr  [17]int32   // Index in vals of the first code of each length.
+	minCode [17]int32
+	vals    []uint8
+}
+
+func (h *jpegHuffman) build(counts []uint8, vals []uint8) {
+	h.vals = vals
+	h.lut = [256]uint16{}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		h.valPtr[l], h.minCode[l] = k, code
+		h.maxCode[l] = -1
+		if n > 0 {
+			h.maxCode[l] = code + n - 1
+		}
+		if l <= 8 {
+			for i := int32(0); i < n; i++ {
+				c := (code + i) << uint(8-l)
+				for j := int32(0); j < 1<<uint(8-l); j++ {
+					h.lut[c+j] = uint16(vals[k+i])<<8 | uint16(l)
+				}
+			}
+		}
+		code, k = (code+n)<<1, k+n
+	}
+	h.maxCode[17] = math.MaxInt32 // Sentinel ending invalid codes.
+}
+
+// jpegBits reads entropy coded data, removing stuffed zero bytes.
+// At a marker or at the end of data it supplies zero bits.
+type jpegBits struct {
+	data []byte
+	pos  int
+	acc  uint64 // Bits aligned to the most significant end.
+	n    uint
+	eof  bool // Data ended without a marker.
+}
+
+func (b *jpegBits) fill() {
+	for b.n <= 56 {
+		var c byte
+		switch {
+		case b.pos >= len(b.data):
+			b.eof = true
+		case b.data[b.pos] != 0xff:
+			c = b.data[b.pos]
+			b.pos++
+		case b.pos+1 < len(b.data) && b.data[b.pos+1] == 0:
+			c = 0xff
+			b.pos += 2
+		}
+		b.acc |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+}
+
+func (b *jpegBits) bits(n uint) int32 {
+	if b.n < n {
+		b.fill()
+	}
+	v := int32(b.acc >> (64 - n))
+	b.acc <<= n
+	b.n -= n
+	return v
+}
+
+func (b *jpegBits) skip(n uint) {
+	if b.n < n {
+		b.fill()
+	}
+	b.acc <<= n
+	b.n -= n
+}
+
+// receiveExtend reads an n-bit magnitude category value (F.12).
+func (b *jpegBits) receiveExtend(n uint8) int32 {
+	if n == 0 {
+		return 0
+	}
+	v := b.bits(uint(n))
+	if v < 1<<(n-1) {
+		v += -1<<n + 1
+	}
+	return v
+}
+
+func (b *jpegBits) decode(h *jpegHuffman) (uint8, error) {
+	if b.n < 16 {
+		b.fill()
+	}
+	if e := h.lut[b.acc>>56]; e != 0 {
+		b.acc <<= e & 0xff
+		b.n -= uint(e & 0xff)
+		return uint8(e >> 8), nil
+	}
+	code := b.bits(1)
+	l := 1
+	for code > h.maxCode[l] {
+		if l == 16 {
+			return 0, errJPEGFormat
+		}
+		code = code<<1 | b.bits(1)
+		l++
+	}
+	return h.vals[h.valPtr[l]+code-h.minCode[l]], nil
+}
+
+// restart skips to the byte following an RSTn marker.
+func (b *jpegBits) restart() error {
+	b.acc, b.n = 0, 0
+	for b.pos+1 < len(b.data) && b.data[b.pos] == 0xff && b.data[b.pos+1] == 0xff {
+		b.pos++ // Fill bytes.
+	}
+	if b.pos+1 >= len(b.data) || b.data[b.pos] != 0xff ||
+		b.data[b.pos+1] < jpegRST0 || b.data[b.pos+1] > jpegRST7 {
+		return errJPEGFormat
+	}
+	b.pos += 2
+	return nil
+}
+
+type jpegComponent struct {
+	id     uint8
+	h, v   int // Sampling factors.
+	tq     uint8
+	td, ta uint8 // Huffman tables.
+	pred   int32 // DC predictor.
+	plane  []uint8
+	stride int
+}
+
+type jpegScaledDecoder struct {
+	data          []byte
+	width, height int
+	comps         []jpegComponent
+	quant         [4][64]int32
+	dc, ac        [4]jpegHuffman
+	restartEvery  int
+	adobeRGB      bool
+	n             int // Output size of one block: 1, 2, 4 or 8.
+	idct          [8][8]float64
+}
+
+// jpegConfig scans markers up to the start of frame and returns
+// the image size. Only sequential Huffman frames are supported.
+func jpegConfig(data []byte) (width, height int, err error) {
+	d := &jpegScaledDecoder{data: data}
+	_, err = d.readMarkers(false)
+	return d.width, d.height, err
+}
+
+// decodeJPEGScaled decodes a JPEG image with each 8x8 block
+// reduced to n×n pixels, n being 1, 2, 4 or 8.
+func decodeJPEGScaled(data []byte, n int) (image.Image, error) {
+	d := &jpegScaledDecoder{data: data, n: n}
+	pos, err := d.readMarkers(true)
+	if err != nil {
+		return nil, err
+	}
+	for x := 0; x < n; x++ {
+		for u := 0; u < n; u++ {
+			c := math.Cos(float64((2*x+1)*u) * math.Pi / float64(2*n))
+			if u == 0 {
+				c *= math.Sqrt2 / 2
+			}
+			d.idct[x][u] = c
+		}
+	}
+	return d.decodeScan(pos)
+}
+
+// readMarkers parses segments from the start of the file. It stops
+// after the start of frame if scan is false, or else after the
+// start of scan header, and returns the position following it.
+func (d *jpegScaledDecoder) readMarkers(scan bool) (int, error) {
+	data := d.data
+	if len(data) < 2 || data[0] != 0xff || data[1] != jpegSOI {
+		return 0, errJPEGFormat
+	}
+	pos := 2
+	for {
+		for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++ // Fill bytes.
+		}
+		if pos+4 > len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if data[pos] != 0xff {
+			return 0, errJPEGFormat
+		}
+		marker := data[pos+1]
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		seg := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		var err error
+		switch {
+		case marker == jpegSOF0 || marker == jpegSOF1:
+			if err = d.readSOF(seg); err == nil && !scan {
+				return pos, nil
+			}
+		case marker >= 0xc2 && marker <= 0xcf && marker != jpegDHT && marker != 0xc8 && marker != 0xcc:
+			return 0, errJPEGUnsupported // Progressive, lossless, arithmetic.
+		case marker == jpegDHT:
+			err = d.readDHT(seg)
+		case marker == jpegDQT:
+			err = d.readDQT(seg)
+		case marker == jpegDRI:
+			if len(seg) < 2 {
+				return 0, errJPEGFormat
+			}
+			d.restartEvery = int(seg[0])<<8 | int(seg[1])
+		case marker == jpegAPP14:
+			// Adobe transform flag 0 means RGB or CMYK data.
+			if len(seg) >= 12 && string(seg[:5]) == "Adobe" && seg[11] == 0 {
+				d.adobeRGB = true
+			}
+		case marker == jpegSOS:
+			if d.comps == nil {
+				return 0, errJPEGFormat
+			}
+			return pos, d.readSOS(seg)
+		case marker == jpegEOI:
+			return 0, errJPEGFormat
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (d *jpegScaledDecoder) readSOF(seg []byte) error {
+	if d.comps != nil || len(seg) < 6 {
+		return errJPEGFormat
+	}
+	if seg[0] != 8 {
+		return errJPEGUnsupported // 12-bit precision.
+	}
+	d.height = int(seg[1])<<8 | int(seg[2])
+	d.width = int(seg[3])<<8 | int(seg[4])
+	nc := int(seg[5])
+	if d.width == 0 || d.height == 0 || len(seg) < 6+3*nc {
+		return errJPEGFormat
+	}
+	if nc != 1 && nc != 3 || d.adobeRGB {
+		return errJPEGUnsupported
+	}
+	d.comps = make([]jpegComponent, nc)
+	for i := range d.comps {
+		c := &d.comps[i]
+		c.id = seg[6+3*i]
+		c.h, c.v = int(seg[7+3*i]>>4), int(seg[7+3*i]&15)
+		c.tq = seg[8+3*i]
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errJPEGFormat
+		}
+		if nc == 1 {
+			c.h, c.v = 1, 1 // A single component is never interleaved.
+		}
+		if i > 0 && (c.h != 1 || c.v != 1) {
+			return errJPEGUnsupported // Chroma subsampling layout.
+		}
+	}
+	if _, ok := jpegSubsampleRatio(d.comps[0].h, d.comps[0].v); !ok && nc == 3 {
+		return errJPEGUnsupported
+	}
+	return nil
+}
+
+func jpegSubsampleRatio(h, v int) (image.YCbCrSubsampleRatio, bool) {
+	switch {
+	case h == 1 && v == 1:
+		return image.YCbCrSubsampleRatio444, true
+	case h == 2 && v == 1:
+		return image.YCbCrSubsampleRatio422, true
+	case h == 2 && v == 2:
+		return image.YCbCrSubsampleRatio420, true
+	case h == 1 && v == 2:
+		return image.YCbCrSubsampleRatio440, true
+	case h == 4 && v == 1:
+		return image.YCbCrSubsampleRatio411, true
+	case h == 4 && v == 2:
+		return image.YCbCrSubsampleRatio410, true
+	}
+	return 0, false
+}
+
+func (d *jpegScaledDecoder) readDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		if tq > 3 || pq > 1 {
+			return errJPEGFormat
+		}
+		size := 64 << pq
+		if len(seg) < 1+size {
+			return errJPEGFormat
+		}
+		for k := 0; k < 64; k++ {
+			if pq == 0 {
+				d.quant[tq][k] = int32(seg[1+k])
+			} else {
+				d.quant[tq][k] = int32(seg[1+2*k])<<8 | int32(seg[2+2*k])
+			}
+		}
+		seg = seg[1+size:]
+	}
+	return nil
+}
+
+func (d *jpegScaledDecoder) readDHT(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return errJPEGFormat
+		}
+		tc, th := seg[0]>>4, seg[0]&15
+		if tc > 1 || th > 3 {
+			return errJPEGFormat
+		}
+		counts := seg[1:17]
+		total := 0
+		for _, c := range counts {
+			total += int(c)
+		}
+		if total > 256 || len(seg) < 17+total {
+			return errJPEGFormat
+		}
+		vals := append([]uint8(nil), seg[17:17+total]...)
+		if tc == 0 {
+			d.dc[th].build(counts, vals)
+		} else {
+			d.ac[th].build(counts, vals)
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+// readSOS reads the scan header. The scan must contain all
+// components, in the frame order.
+func (d *jpegScaledDecoder) readSOS(seg []byte) error {
+	if len(seg) < 1 || int(seg[0]) != len(d.comps) || len(seg) < 4+2*len(d.comps) {
+		return errJPEGUnsupported // Multiple scans.
+	}
+	for i := range d.comps {
+		c := &d.comps[i]
+		if seg[1+2*i] != c.id {
+			return errJPEGUnsupported
+		}
+		c.td, c.ta = seg[2+2*i]>>4, seg[2+2*i]&15
+		if c.td > 3 || c.ta > 3 || d.dc[c.td].vals == nil || d.ac[c.ta].vals == nil {
+			return errJPEGFormat
+		}
+	}
+	return nil
+}
+
+// decodeScan decodes entropy coded data starting at pos and
+// returns the scaled image.
+func (d *jpegScaledDecoder) decodeScan(pos int) (image.Image, error) {
+	n := d.n
+	hMax, vMax := d.comps[0].h, d.comps[0].v
+	mcusX := (d.width + 8*hMax - 1) / (8 * hMax)
+	mcusY := (d.height + 8*vMax - 1) / (8 * vMax)
+	if mcusX > maxDecodePixels/mcusY/(hMax*vMax) {
+		return nil, errJPEGFormat
+	}
+	rect := image.Rect(0, 0, (d.width*n+7)/8, (d.height*n+7)/8)
+	full := image.Rect(0, 0, mcusX*hMax*n, mcusY*vMax*n)
+
+	var img image.Image
+	if len(d.comps) == 1 {
+		gray := image.NewGray(full)
+		d.comps[0].plane, d.comps[0].stride = gray.Pix, gray.Stride
+		gray.Rect = rect
+		img = gray
+	} else {
+		ratio, _ := jpegSubsampleRatio(hMax, vMax)
+		ycbcr := image.NewYCbCr(full, ratio)
+		d.comps[0].plane, d.comps[0].stride = ycbcr.Y, ycbcr.YStride
+		d.comps[1].plane, d.comps[1].stride = ycbcr.Cb, ycbcr.CStride
+		d.comps[2].plane, d.comps[2].stride = ycbcr.Cr, ycbcr.CStride
+		ycbcr.Rect = rect
+		img = ycbcr
+	}
+
+	b := &jpegBits{data: d.data[pos:]}
+	var coef [64]int32
+	// Single component scans have no MCUs, only blocks.
+	blocksX, blocksY := mcusX, mcusY
+	if len(d.comps) == 1 {
+		blocksX, blocksY = (d.width+7)/8, (d.height+7)/8
+	}
+	mcu := 0
+	for my := 0; my < blocksY; my++ {
+		for mx := 0; mx < blocksX; mx++ {
+			if d.restartEvery > 0 && mcu > 0 && mcu%d.restartEvery == 0 {
+				if err := b.restart(); err != nil {
+					return nil, err
+				}
+				for i := range d.comps {
+					d.comps[i].pred = 0
+				}
+			}
+			mcu++
+			for i := range d.comps {
+				c := &d.comps[i]
+				for by := 0; by < c.v; by++ {
+					for bx := 0; bx < c.h; bx++ {
+						if err := d.decodeBlock(b, c, &coef); err != nil {
+							return nil, err
+						}
+						x := (mx*c.h + bx) * n
+						y := (my*c.v + by) * n
+						d.reconstruct(&coef, c.plane[y*c.stride+x:], c.stride)
+					}
+				}
+			}
+		}
+		if b.eof {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+	return img, nil
+}
+
+// decodeBlock reads the coefficients of one block and keeps the
+// dequantized ones of the n×n low frequencies, in natural order.
+func (d *jpegScaledDecoder) decodeBlock(b *jpegBits, c *jpegComponent,
+	coef *[64]int32) error {
+	q := &d.quant[c.tq]
+	t, err := b.decode(&d.dc[c.td])
+	if err != nil {
+		return err
+	}
+	if t > 16 {
+		return errJPEGFormat
+	}
+	c.pred += b.receiveExtend(t)
+	*coef = [64]int32{}
+	coef[0] = c.pred * q[0]
+
+	ac := &d.ac[c.ta]
+	for k := 1; k < 64; k++ {
+		rs, err := b.decode(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), rs&15
+		if s == 0 {
+			if r != 15 {
+				break // End of block.
+			}
+			k += 15
+			continue
+		}
+		k += r
+		if k > 63 {
+			return errJPEGFormat
+		}
+		if z := int(jpegUnzigzag[k]); z%8 < d.n && z/8 < d.n {
+			coef[z] = b.receiveExtend(s) * q[k]
+		} else {
+			b.skip(uint(s))
+		}
+	}
+	return nil
+}
+
+// reconstruct computes the n×n inverse DCT of the n×n lowest
+// frequencies of a block. Scaled by 8/n, this approximates an
+// n×n average of the 8×8 block.
+func (d *jpegScaledDecoder) reconstruct(coef *[64]int32, dst []uint8, stride int) {
+	n := d.n
+	if n == 1 {
+		dst[0] = clampUint8(float64(coef[0])/8 + 128)
+		return
+	}
+	var tmp [8][8]float64 // tmp[v][x], rows transformed.
+	for v := 0; v < n; v++ {
+		for x := 0; x < n; x++ {
+			s := 0.0
+			for u := 0; u < n; u++ {
+				s += d.idct[x][u] * float64(coef[8*v+u])
+			}
+			tmp[v][x] = s
+		}
+	}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			s := 0.0
+			for v := 0; v < n; v++ {
+				s += d.idct[y][v] * tmp[v][x]
+			}
+			dst[y*stride+x] = clampUint8(s/4 + 128)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package images4
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeJPEGScaled(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 61, 45))
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 61; x++ {
+			src.Set(x, y, color.RGBA{uint8(4 * x), uint8(5 * y), uint8(x * y), 255})
+		}
+	}
+	gray := image.NewGray(src.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = src.Pix[4*i+1]
+	}
+	for _, img := range []image.Image{src, gray} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal("Error encoding JPEG:", err)
+		}
+		ref, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal("Error decoding JPEG:", err)
+		}
+		// Without scaling, the result differs from image/jpeg only
+		// by rounding.
+		for n, size := range map[int]image.Point{
+			8: {61, 45}, 4: {31, 23}, 2: {16, 12}, 1: {8, 6}} {
+			got, err := decodeJPEGScaled(buf.Bytes(), n)
+			if err != nil {
+				t.Fatalf("Cannot decode at scale %d/8: %v", n, err)
+			}
+			if got.Bounds().Size() != size {
+				t.Errorf("Expected size %v at scale %d/8, got %v.",
+					size, n, got.Bounds().Size())
+			}
+			if n != 8 {
+				continue
+			}
+			for y := 0; y < 45; y++ {
+				for x := 0; x < 61; x++ {
+					r1, g1, b1, _ := ref.At(x, y).RGBA()
+					r2, g2, b2, _ := got.At(x, y).RGBA()
+					if absInt(int(r1>>8)-int(r2>>8)) > 3 ||
+						absInt(int(g1>>8)-int(g2>>8)) > 3 ||
+						absInt(int(b1>>8)-int(b2>>8)) > 3 {
+						t.Fatalf("Pixel (%d, %d) differs from image/jpeg.", x, y)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Tests the documented distance between icons of reduced and
+// full images.
+func TestDecodeReduced(t *testing.T) {
+	files, _ := filepath.Glob(path.Join("testdata", "*", "*.jpg"))
+	for _, file := range files {
+		full, err := Open(file)
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		reduced, err := OpenReduced(file)
+		if err != nil {
+			t.Fatal("Error opening reduced image:", err)
+		}
+		if _, ok := reduced.(*ReducedImage); !ok {
+			t.Errorf("Image %v is not reduced.", file)
+		}
+		icon1, icon2 := Icon(full), Icon(reduced)
+		if icon1.ImgSize != icon2.ImgSize {
+			t.Errorf("Image size %v of reduced %v differs from %v.",
+				icon2.ImgSize, file, icon1.ImgSize)
+		}
+		m1, m2, m3 := EucMetric(icon1, icon2)
+		if m1 > 0.02*thY || m2 > 0.02*thCbCr || m3 > 0.02*thCbCr {
+			t.Errorf("Icon of reduced %v is too far: %v, %v, %v.",
+				file, m1, m2, m3)
+		}
+	}
+
+	// Small and non-JPEG images decode at full resolution.
+	for _, file := range []string{"small.gif", "uniform-green.png"} {
+		img, err := OpenReduced(path.Join("testdata", "euclidean", file))
+		if err != nil {
+			t.Fatal("Error opening reduced image:", err)
+		}
+		if _, ok := img.(*ReducedImage); ok {
+			t.Errorf("Image %v must not be reduced.", file)
+		}
+	}
+}
+
+func FuzzDecodeJPEGScaled(f *testing.F) {
+	files, _ := filepath.Glob(path.Join("testdata", "*", "*.jpg"))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal("Error reading file:", err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		w, h, err := jpegConfig(data)
+		if err != nil || w*h > 1<<20 {
+			return
+		}
+		img, err := decodeJPEGScaled(data, 2)
+		if err != nil {
+			return
+		}
+		if size := img.Bounds().Size(); size != (image.Point{(w + 3) / 4, (h + 3) / 4}) {
+			t.Errorf("Size %v differs from config %dx%d.", size, w, h)
+		}
+	})
+}
+
+var benchmarkFile = path.Join("testdata", "euclidean", "large.jpg")
+
+func BenchmarkIconOpen(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		img, err := Open(benchmarkFile)
+		if err != nil {
+			b.Fatal("Error opening image:", err)
+		}
+		Icon(img)
+	}
+}
+
+func BenchmarkIconOpenReduced(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		img, err := OpenReduced(benchmarkFile)
+		if err != nil {
+			b.Fatal("Error opening image:", err)
+		}
+		Icon(img)
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := Open(benchmarkFile); err != nil {
+			b.Fatal("Error opening image:", err)
+		}
+	}
+}
+
+func BenchmarkOpenReduced(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := OpenReduced(benchmarkFile); err != nil {
+			b.Fatal("Error opening image:", err)
+		}
+	}
+}
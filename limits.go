@@ -0,0 +1,115 @@
+package images4
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Limits protects func OpenLimited and OpenReaderLimited from
+// decompression bombs: small files declaring huge images. Image
+// headers are checked with image.DecodeConfig before decoding.
+// The zero value imposes no limits.
+type Limits struct {
+	// MaxPixels limits width*height of an image. 0 means no limit.
+	MaxPixels int64
+	// MaxMemory limits the estimated size in bytes of the decoded
+	// image, by the bytes per pixel of its color model. 0 means
+	// no limit.
+	MaxMemory int64
+	// MaxBytes limits the size of the input data. 0 means no limit.
+	MaxBytes int64
+}
+
+// LimitError reports an image exceeding one of Limits. It is
+// wrapped in *OpenError, and can be found with errors.As.
+type LimitError struct {
+	Limit string // "pixels", "memory" or "bytes".
+	Value int64  // Declared or read value, at least Max+1 for bytes.
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("images4: image %s %d exceed limit %d", e.Limit, e.Value, e.Max)
+}
+
+// OpenLimited is like func Open, but rejects images exceeding
+// the limits without decoding them.
+func OpenLimited(path string, limits Limits) (img image.Image, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &OpenError{Op: "read", Name: path, Err: err}
+	}
+	defer file.Close()
+	img, _, err = openLimited(path, file, limits)
+	return img, err
+}
+
+// OpenReaderLimited is like func OpenReader, but rejects images
+// exceeding the limits. Reading stops after MaxBytes.
+func OpenReaderLimited(r io.Reader, limits Limits) (
+	img image.Image, format string, err error) {
+	return openLimited("", r, limits)
+}
+
+func openLimited(name string, r io.Reader, limits Limits) (
+	image.Image, string, error) {
+	if limits.MaxBytes > 0 {
+		r = io.LimitReader(r, limits.MaxBytes+1)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", &OpenError{Op: "read", Name: name, Err: err}
+	}
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return nil, "", &OpenError{Op: "read", Name: name,
+			Err: &LimitError{"bytes", int64(len(data)), limits.MaxBytes}}
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == image.ErrFormat {
+		err = ErrUnknownFormat
+	}
+	if err == nil {
+		err = limits.check(config)
+	}
+	if err != nil {
+		return nil, format, &OpenError{Op: "decode", Name: name, Format: format, Err: err}
+	}
+	return decodeBytes(name, data, false)
+}
+
+func (limits Limits) check(config image.Config) error {
+	pixels := int64(config.Width) * int64(config.Height)
+	if limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+		return &LimitError{"pixels", pixels, limits.MaxPixels}
+	}
+	memory := pixels * bytesPerPixel(config.ColorModel)
+	if limits.MaxMemory > 0 && memory > limits.MaxMemory {
+		return &LimitError{"memory", memory, limits.MaxMemory}
+	}
+	return nil
+}
+
+// bytesPerPixel estimates memory per pixel of decoded images.
+func bytesPerPixel(m color.Model) int64 {
+	switch m {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel: // At most, without chroma subsampling.
+		return 3
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel, color.NYCbCrAModel:
+		return 4
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	if _, ok := m.(color.Palette); ok {
+		return 1
+	}
+	return 8
+}
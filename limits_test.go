@@ -0,0 +1,73 @@
+package images4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// Headers declaring 60000x60000 pixels, with no pixel data.
+func bombPNG() []byte {
+	ihdr := []byte("IHDR\x00\x00\xea\x60\x00\x00\xea\x60")
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8-bit RGB.
+	b := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	b = append(b, ihdr...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(ihdr))
+	return append(b, crc[:]...)
+}
+
+func bombGIF() []byte {
+	return []byte("GIF89a\x60\xea\x60\xea\x00\x00\x00;")
+}
+
+func TestOpenLimited(t *testing.T) {
+	limits := Limits{MaxPixels: 1 << 24, MaxMemory: 1 << 26, MaxBytes: 1 << 20}
+	for _, data := range [][]byte{bombPNG(), bombGIF()} {
+		_, _, err := OpenReaderLimited(bytes.NewReader(data), limits)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != "pixels" ||
+			limitErr.Value != 60000*60000 {
+			t.Errorf("Expected pixel limit error, got %v.", err)
+		}
+	}
+
+	file := path.Join("testdata", "euclidean", "large.jpg") // 533x400.
+	tables := []struct {
+		limits Limits
+		limit  string
+	}{
+		{Limits{}, ""},
+		{Limits{MaxPixels: 533 * 400, MaxMemory: 533 * 400 * 3}, ""},
+		{Limits{MaxPixels: 533*400 - 1}, "pixels"},
+		{Limits{MaxMemory: 533*400*3 - 1}, "memory"}, // YCbCr.
+		{Limits{MaxBytes: 1000}, "bytes"},
+	}
+	for _, table := range tables {
+		img, err := OpenLimited(file, table.limits)
+		var limitErr *LimitError
+		if table.limit == "" {
+			if err != nil || img == nil {
+				t.Errorf("Limits %+v: unexpected error %v.", table.limits, err)
+			}
+			continue
+		}
+		var openErr *OpenError
+		if !errors.As(err, &limitErr) || limitErr.Limit != table.limit ||
+			!errors.As(err, &openErr) || openErr.Name != file {
+			t.Errorf("Limits %+v: expected %s limit error, got %v.",
+				table.limits, table.limit, err)
+		}
+	}
+
+	// Unknown formats are reported before limits.
+	data, _ := ioutil.ReadFile(path.Join("testdata", "euclidean", "small.gif"))
+	_, _, err := OpenReaderLimited(bytes.NewReader(data[1:]), limits)
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected unknown format, got %v.", err)
+	}
+}
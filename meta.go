@@ -0,0 +1,160 @@
+package images4
+
+import "math"
+
+// IconMeta is information about the image of an icon beyond its
+// pixels. Icons of func Icon and its variants have it, while icons
+// made by hand or decoded from forms without metadata may not.
+type IconMeta struct {
+	// Min, Max and Mean are the extreme and mean values of icon
+	// channels Y, Cb and Cr before normalization, in the [0, 255]
+	// range, with the precision of icon pixels. Normalization
+	// hides differences of brightness and tint, which they keep.
+	Min, Max, Mean [3]float64
+	// Format of the image, such as "jpeg", when known. Func Icon
+	// does not know it, while func ReadIcon and IconsFromGIF set it.
+	Format string
+	// BitDepth is the number of bits per channel of image colors
+	// used for the icon: 16 for 16-bit images, otherwise 8.
+	BitDepth int
+	// Thumbnail tells that the icon was made from a reduced image,
+	// as of func DecodeReduced, rather than from the full image.
+	Thumbnail bool
+}
+
+// Largest range of any channel before normalization, in the
+// [0, 255] range, of icons of uniform images. Blank scans and
+// black video frames are within it, while normalization would
+// stretch their noise to full contrast.
+const uniformRange = 8
+
+// ownMeta returns the icon with its own copy of metadata, so that
+// changes of metadata of other icons do not show in it.
+func (icon IconT) ownMeta() IconT {
+	if icon.Meta != nil {
+		meta := *icon.Meta
+		icon.Meta = &meta
+	}
+	return icon
+}
+
+// newIconMeta computes metadata of a non-normalized icon.
+func newIconMeta(icon IconT) *IconMeta {
+	meta := new(IconMeta)
+	for ch := 0; ch < 3; ch++ {
+		var lo, hi uint16 = maxUint16, 0
+		var sum int
+		for _, v := range icon.channel(ch) {
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+			sum += int(v)
+		}
+		meta.Min[ch] = float64(lo) * one255th
+		meta.Max[ch] = float64(hi) * one255th
+		meta.Mean[ch] = math.Round(float64(sum)/numPix) * one255th
+	}
+	return meta
+}
+
+// Flatness tells how close the image of an icon is to a single
+// color, from 0 for images spanning the full range of a channel
+// to 1 for uniform images. It is 0 for icons without metadata.
+func (icon IconT) Flatness() float64 {
+	if icon.Meta == nil {
+		return 0
+	}
+	var r float64
+	for ch := 0; ch < 3; ch++ {
+		r = math.Max(r, icon.Meta.Max[ch]-icon.Meta.Min[ch])
+	}
+	return math.Max(0, 1-r/255)
+}
+
+// IsUniform tells whether the image of an icon is of one color,
+// or almost. Normalization does not stretch the channels of such
+// images, or stretches only their noise, so their icons carry
+// little information and may match each other in confusing ways.
+// Func Similar does not treat them specially. Use func
+// SimilarUniform or IndexOptions.UniformSameColor to match them
+// only to icons of the same color.
+func (icon IconT) IsUniform() bool {
+	return icon.Flatness() >= 1-uniformRange/255.0
+}
+
+// uniformColor returns the color of a uniform icon, in units of
+// icon pixels.
+func (icon IconT) uniformColor() (c [3]uint16, ok bool) {
+	if !icon.IsUniform() {
+		return c, false
+	}
+	for ch := 0; ch < 3; ch++ {
+		c[ch] = uint16(math.Round((icon.Meta.Min[ch] + icon.Meta.Max[ch]) * 255 / 2))
+	}
+	return c, true
+}
+
+// SameColor tells whether both icons are uniform and of the same
+// color, within one level of each channel. Image proportions are
+// not compared. See func SimilarUniform.
+func SameColor(iconA, iconB IconT) bool {
+	a, okA := iconA.uniformColor()
+	b, okB := iconB.uniformColor()
+	if !okA || !okB {
+		return false
+	}
+	for ch := 0; ch < 3; ch++ {
+		if absInt(int(a[ch])-int(b[ch])) > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// SimilarUniform is like func Similar, but matches uniform icons
+// (see IconT.IsUniform) only to uniform icons of the same color
+// and similar proportions. Func Similar may match them to other
+// uniform or low-contrast images, because normalization stretches
+// their noise.
+func SimilarUniform(iconA, iconB IconT) bool {
+	if iconA.IsUniform() || iconB.IsUniform() {
+		return similarUniform(iconA, iconB, nil)
+	}
+	return Similar(iconA, iconB)
+}
+
+// CustomSimilarUniform is like SimilarUniform, with thresholds of
+// func CustomSimilar. Colors of uniform icons are compared as by
+// func SameColor, with coefficient Prop for proportions only.
+func CustomSimilarUniform(iconA, iconB IconT, coeff CustomCoefficients) bool {
+	if iconA.IsUniform() || iconB.IsUniform() {
+		return similarUniform(iconA, iconB, &coeff)
+	}
+	return CustomSimilar(iconA, iconB, coeff)
+}
+
+// similarUniform compares icons, of which at least one is uniform,
+// with default proportion thresholds when coeff is nil.
+func similarUniform(iconA, iconB IconT, coeff *CustomCoefficients) bool {
+	if coeff == nil && !propSimilar(iconA, iconB) ||
+		coeff != nil && !customPropSimilar(iconA, iconB, *coeff) {
+		return false
+	}
+	return SameColor(iconA, iconB)
+}
+
+// MeanMetric returns absolute differences of mean colors of the
+// images of two icons, for channels Y, Cb and Cr in the [0, 255]
+// range. Unlike func EucMetric, it tells brightness and tint
+// apart, for example of an image and its darkened copy. ok is
+// false when an icon has no metadata.
+func MeanMetric(iconA, iconB IconT) (m1, m2, m3 float64, ok bool) {
+	if iconA.Meta == nil || iconB.Meta == nil {
+		return 0, 0, 0, false
+	}
+	a, b := iconA.Meta.Mean, iconB.Meta.Mean
+	return math.Abs(a[0] - b[0]), math.Abs(a[1] - b[1]), math.Abs(a[2] - b[2]), true
+}
@@ -0,0 +1,211 @@
+package images4
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// nearUniform returns an image of one color with a faint gradient
+// and noise, as a blank scan.
+func nearUniform(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			d := uint8(x/50 + (x*7+y*13)%3)
+			img.SetRGBA(x, y, color.RGBA{c.R + d, c.G + d, c.B + d, 255})
+		}
+	}
+	return img
+}
+
+func TestIsUniform(t *testing.T) {
+	for _, name := range []string{"uniform-black.png", "uniform-green.png",
+		"uniform-white.png", "large.jpg"} {
+		icon := testIcon(name, t)
+		uniform := name != "large.jpg"
+		if icon.IsUniform() != uniform {
+			t.Errorf("%s: expected IsUniform %v, flatness %v.", name, uniform, icon.Flatness())
+		}
+		if uniform && icon.Flatness() != 1 {
+			t.Errorf("%s: expected flatness 1, got %v.", name, icon.Flatness())
+		}
+	}
+	icon := Icon(nearUniform(color.RGBA{100, 100, 100, 255}))
+	if !icon.IsUniform() || icon.Flatness() == 1 {
+		t.Errorf("Expected a near-uniform icon, flatness %v.", icon.Flatness())
+	}
+	icon.Meta = nil
+	if icon.IsUniform() || icon.Flatness() != 0 {
+		t.Error("Icons without metadata must not be uniform.")
+	}
+}
+
+func TestSameColor(t *testing.T) {
+	gray := Icon(nearUniform(color.RGBA{100, 100, 100, 255}))
+	light := Icon(nearUniform(color.RGBA{140, 140, 140, 255}))
+	grayFlat := Icon(image.NewUniform(color.RGBA{102, 102, 102, 255}))
+	grayFlat.ImgSize = gray.ImgSize
+
+	// Normalization stretches noise to full contrast, so that the
+	// icon differs from its own color.
+	if Similar(gray, grayFlat) {
+		t.Error("Expected a normalized near-uniform icon to differ from its color.")
+	}
+
+	if !SameColor(gray, grayFlat) || !SameColor(grayFlat, gray) {
+		t.Error("Expected the same color.")
+	}
+	for _, pair := range [][2]IconT{
+		{gray, light},
+		{testIcon("uniform-black.png", t), testIcon("uniform-white.png", t)},
+		{testIcon("large.jpg", t), testIcon("large.jpg", t)},
+	} {
+		if SameColor(pair[0], pair[1]) {
+			t.Error("Expected different colors or non-uniform icons.")
+		}
+	}
+}
+
+func TestSimilarUniform(t *testing.T) {
+	gray := Icon(nearUniform(color.RGBA{100, 100, 100, 255}))
+	light := Icon(nearUniform(color.RGBA{140, 140, 140, 255}))
+	grayFlat := Icon(image.NewUniform(color.RGBA{102, 102, 102, 255}))
+	grayFlat.ImgSize = gray.ImgSize
+	grayWide := grayFlat
+	grayWide.ImgSize = image.Point{400, 200}
+	photo := testIcon("large.jpg", t)
+	ones := CustomCoefficients{1, 1, 1, 1}
+
+	for _, table := range []struct {
+		name    string
+		a, b    IconT
+		similar bool
+	}{
+		{"same color", gray, grayFlat, true},
+		{"different colors", gray, light, false},
+		{"different proportions", gray, grayWide, false},
+		{"uniform and photo", grayFlat, photo, false},
+		{"photos", photo, photo, true},
+	} {
+		if got := SimilarUniform(table.a, table.b); got != table.similar {
+			t.Errorf("%s: expected %v, got %v.", table.name, table.similar, got)
+		}
+		if got := CustomSimilarUniform(table.b, table.a, ones); got != table.similar {
+			t.Errorf("%s: expected %v with custom coefficients, got %v.",
+				table.name, table.similar, got)
+		}
+	}
+	if !CustomSimilarUniform(gray, grayWide, CustomCoefficients{1, 1, 1, 20}) {
+		t.Error("Expected a match with a large proportion coefficient.")
+	}
+}
+
+func TestMetaPreserved(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "distorted.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nn := IconNN(img)
+	icon := Icon(img)
+	if *icon.Meta != *nn.Meta {
+		t.Errorf("Normalization must not change metadata, %+v vs %+v.", *icon.Meta, *nn.Meta)
+	}
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255.0, 0.0
+		for _, v := range nn.channel(ch) {
+			lo, hi = math.Min(lo, float64(v)*one255th), math.Max(hi, float64(v)*one255th)
+		}
+		if icon.Meta.Min[ch] != lo || icon.Meta.Max[ch] != hi {
+			t.Errorf("Channel %d: expected range %v..%v, got %v..%v.",
+				ch, lo, hi, icon.Meta.Min[ch], icon.Meta.Max[ch])
+		}
+	}
+	for _, turned := range []IconT{Rotate90(icon), Mirror(icon), Orient(icon, true).Turned(Rotation0),
+		Orient(icon, false).Turned(Rotation90)} {
+		if turned.Meta == icon.Meta || *turned.Meta != *icon.Meta {
+			t.Error("Rotations must keep a copy of metadata.")
+		}
+	}
+	x := NewIndex(IndexOptions{})
+	x.Add("a", icon)
+	icon.Meta.Format = "changed"
+	if stored, _ := x.Icon("a"); stored.Meta.Format != "" {
+		t.Error("Indexed metadata must not change with the added icon.")
+	}
+}
+
+func TestIconMetaFields(t *testing.T) {
+	data, err := ioutil.ReadFile(path.Join("testdata", "rotate", "0.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon, err := ReadIcon(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := icon.Meta
+	if meta.Format != "jpeg" || meta.BitDepth != 8 || !meta.Thumbnail {
+		t.Errorf("Expected a reduced 8-bit jpeg image, got %+v.", *meta)
+	}
+	for ch := 0; ch < 3; ch++ {
+		if meta.Mean[ch] < meta.Min[ch] || meta.Mean[ch] > meta.Max[ch] {
+			t.Errorf("Channel %d: mean %v out of range %v..%v.",
+				ch, meta.Mean[ch], meta.Min[ch], meta.Max[ch])
+		}
+	}
+	if full := testIcon("large.jpg", t); full.Meta.Thumbnail || full.Meta.Format != "" {
+		t.Errorf("Expected a full image of unknown format, got %+v.", *full.Meta)
+	}
+
+	// All fields survive serialization.
+	text, err := json.Marshal(icon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded IconT
+	if err := json.Unmarshal(text, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(icon, decoded) {
+		t.Errorf("Decoded metadata %+v differs from %+v.", *decoded.Meta, *meta)
+	}
+}
+
+func TestMeanMetric(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A darkened and blue tinted copy.
+	tinted := image.NewRGBA(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			tinted.SetRGBA(x, y, color.RGBA{uint8(r >> 9), uint8(g >> 9),
+				uint8(b>>9) + 40, 255})
+		}
+	}
+	icon, iconTinted := Icon(img), Icon(tinted)
+	if !Similar(icon, iconTinted) {
+		t.Fatal("Normalization must hide brightness and tint.")
+	}
+	m1, m2, m3, ok := MeanMetric(icon, iconTinted)
+	t.Logf("Mean differences: %.1f, %.1f, %.1f.", m1, m2, m3)
+	if !ok || m1 < 30 || m2 < 10 {
+		t.Errorf("Expected large Y and Cb differences, got %v, %v, %v.", m1, m2, m3)
+	}
+	if m1, m2, m3, ok := MeanMetric(icon, icon); !ok || m1 != 0 || m2 != 0 || m3 != 0 {
+		t.Error("Expected zero differences to itself.")
+	}
+	icon.Meta = nil
+	if _, _, _, ok := MeanMetric(icon, iconTinted); ok {
+		t.Error("Expected no metric without metadata.")
+	}
+}
@@ -0,0 +1,117 @@
+package images4
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// NormalizationMode is a method of func CustomIcon to stretch icon
+// channels for contrast. Icons record their mode, and icons of
+// different modes are never similar.
+type NormalizationMode uint8
+
+const (
+	// NormalizeMinMax stretches each channel so that its minimum
+	// and maximum become 0 and 255. It is the mode of func Icon.
+	// A single outlier pixel, such as a watermark, changes the
+	// whole icon.
+	NormalizeMinMax NormalizationMode = iota
+	// NormalizeNone keeps average colors, as func IconNN.
+	NormalizeNone
+	// NormalizePercentile is like NormalizeMinMax for the values
+	// of percentileClip pixels from either end of each channel,
+	// clipping more extreme values. Few outlier pixels do not
+	// change the rest of the icon.
+	NormalizePercentile
+	// NormalizeStandardize maps the mean of each channel to 127.5
+	// and ±2 standard deviations to 0 and 255, clipping values
+	// outside.
+	NormalizeStandardize
+	// NormalizeLuma stretches luma as NormalizeMinMax and keeps
+	// chroma, so that colors stay comparable.
+	NormalizeLuma
+)
+
+func (m NormalizationMode) String() string {
+	switch m {
+	case NormalizeMinMax:
+		return "minmax"
+	case NormalizeNone:
+		return "none"
+	case NormalizePercentile:
+		return "percentile"
+	case NormalizeStandardize:
+		return "standardize"
+	case NormalizeLuma:
+		return "luma"
+	}
+	return fmt.Sprintf("NormalizationMode(%d)", int(m))
+}
+
+// Number of icon pixels clipped at either end of a channel by
+// NormalizePercentile, about 2.5% of them.
+const percentileClip = 3
+
+// normalizeMode normalizes an icon in a given mode and records it.
+func (icon *IconT) normalizeMode(mode NormalizationMode) {
+	icon.Normalization = mode
+	switch mode {
+	case NormalizeMinMax:
+		icon.normalize()
+	case NormalizePercentile:
+		var values [numPix]uint16
+		for ch := 0; ch < 3; ch++ {
+			copy(values[:], icon.channel(ch))
+			sort.Slice(values[:], func(i, j int) bool { return values[i] < values[j] })
+			icon.stretch(ch, float64(values[percentileClip]),
+				float64(values[numPix-1-percentileClip]))
+		}
+	case NormalizeStandardize:
+		for ch := 0; ch < 3; ch++ {
+			var sum, sum2 float64
+			for _, v := range icon.channel(ch) {
+				sum += float64(v)
+				sum2 += float64(v) * float64(v)
+			}
+			mean := sum / numPix
+			sd := math.Sqrt(math.Max(0, sum2/numPix-mean*mean))
+			icon.stretch(ch, mean-2*sd, mean+2*sd)
+		}
+	case NormalizeLuma:
+		var lo, hi uint16 = maxUint16, 0
+		for _, v := range icon.channel(0) {
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		icon.stretch(0, float64(lo), float64(hi))
+	}
+}
+
+// channel returns pixels of channel ch.
+func (icon IconT) channel(ch int) []uint16 {
+	return icon.Pixels[ch*numPix : (ch+1)*numPix]
+}
+
+// stretch maps values lo and hi of channel ch linearly to 0 and
+// 255, clipping values outside. Uniform channels with hi equal
+// to lo are not changed.
+func (icon IconT) stretch(ch int, lo, hi float64) {
+	if hi-lo < 1 { // Must not divide by zero, nor blow up noise.
+		return
+	}
+	scale := sq255 / (hi - lo)
+	for i, v := range icon.channel(ch) {
+		s := (float64(v) - lo) * scale
+		if s < 0 {
+			s = 0
+		} else if s > sq255 {
+			s = sq255
+		}
+		icon.Pixels[ch*numPix+i] = uint16(s)
+	}
+}
@@ -0,0 +1,126 @@
+package images4
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"path"
+	"reflect"
+	"testing"
+)
+
+var normalizationModes = []NormalizationMode{NormalizeMinMax,
+	NormalizeNone, NormalizePercentile, NormalizeStandardize, NormalizeLuma}
+
+func TestNormalizeUniform(t *testing.T) {
+	// Uniform images have nothing to stretch, and keep their
+	// colors in all modes.
+	var icons [3][]IconT
+	for i, name := range []string{"uniform-black.png", "uniform-green.png",
+		"uniform-white.png"} {
+		img, err := Open(path.Join("testdata", "euclidean", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nn := IconNN(img)
+		for _, mode := range normalizationModes {
+			icon := CustomIcon(img, IconOptions{Normalization: mode})
+			if icon.Normalization != mode {
+				t.Errorf("%s: expected mode %v, got %v.", name, mode, icon.Normalization)
+			}
+			if !reflect.DeepEqual(icon.Pixels, nn.Pixels) {
+				t.Errorf("%s: %v changed a uniform icon.", name, mode)
+			}
+			icons[i] = append(icons[i], icon)
+		}
+	}
+	for m, mode := range normalizationModes {
+		if eucSimilar(icons[0][m], icons[2][m]) {
+			t.Errorf("%v: black and white must not be similar.", mode)
+		}
+		if eucSimilar(icons[1][m], icons[2][m]) {
+			t.Errorf("%v: green and white must not be similar.", mode)
+		}
+	}
+}
+
+func TestNormalizeOutlier(t *testing.T) {
+	// A dim gradient, and the same with a small bright spot, as a
+	// watermark or a specular highlight.
+	img := image.NewGray(image.Rect(0, 0, 220, 220))
+	for y := 0; y < 220; y++ {
+		for x := 0; x < 220; x++ {
+			img.SetGray(x, y, color.Gray{uint8(60 + (x+y)/5)})
+		}
+	}
+	spot := image.NewGray(img.Rect)
+	copy(spot.Pix, img.Pix)
+	for y := 10; y < 20; y++ {
+		for x := 10; x < 20; x++ {
+			spot.SetGray(x, y, color.Gray{255})
+		}
+	}
+
+	var diff [2]float64
+	for i, mode := range []NormalizationMode{NormalizeMinMax, NormalizePercentile} {
+		opts := IconOptions{Normalization: mode}
+		diff[i], _, _ = EucMetric(CustomIcon(img, opts), CustomIcon(spot, opts))
+	}
+	t.Logf("Y distance of the spot: minmax %.0f, percentile %.0f.", diff[0], diff[1])
+	if diff[1]*4 > diff[0] {
+		t.Errorf("Expected the spot to change minmax icons much more, got %v.", diff)
+	}
+}
+
+func TestNormalizeLuma(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nn := IconNN(img)
+	luma := CustomIcon(img, IconOptions{Normalization: NormalizeLuma})
+	if !reflect.DeepEqual(luma.Pixels[numPix:], nn.Pixels[numPix:]) {
+		t.Error("Luma normalization must keep chroma.")
+	}
+	if reflect.DeepEqual(luma.Pixels[:numPix], nn.Pixels[:numPix]) {
+		t.Error("Luma normalization must stretch luma.")
+	}
+}
+
+func TestNormalizeIncompatible(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := IconNN(img).Normalization; mode != NormalizeNone {
+		t.Errorf("IconNN must record NormalizeNone, got %v.", mode)
+	}
+	for _, a := range normalizationModes {
+		iconA := CustomIcon(img, IconOptions{Normalization: a})
+		for _, b := range normalizationModes {
+			iconB := CustomIcon(img, IconOptions{Normalization: b})
+			m1, _, _ := EucMetric(iconA, iconB)
+			if similar := Similar(iconA, iconB); similar != (a == b) {
+				t.Errorf("%v vs %v: expected similar %v.", a, b, a == b)
+			}
+			if a != b && !math.IsInf(m1, 1) {
+				t.Errorf("%v vs %v: expected infinite distance, got %v.", a, b, m1)
+			}
+		}
+	}
+}
+
+func TestNormalizationModeString(t *testing.T) {
+	for mode, want := range map[NormalizationMode]string{
+		NormalizeMinMax:      "minmax",
+		NormalizeNone:        "none",
+		NormalizePercentile:  "percentile",
+		NormalizeStandardize: "standardize",
+		NormalizeLuma:        "luma",
+		NormalizationMode(9): "NormalizationMode(9)",
+	} {
+		if got := mode.String(); got != want {
+			t.Errorf("Expected %q, got %q.", want, got)
+		}
+	}
+}
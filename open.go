@@ -0,0 +1,106 @@
+package images4
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+// ErrUnknownFormat is the error of decoding data of no registered
+// image format. Test for it with errors.Is.
+var ErrUnknownFormat = errors.New("images4: unknown image format")
+
+// OpenError is the error returned by the Open functions and
+// ReadIcon. It records the image name and the operation: "read"
+// for I/O errors of files and readers, "decode" for unknown formats
+// (Err is ErrUnknownFormat) and for invalid or unsupported images.
+type OpenError struct {
+	Op     string // "read" or "decode".
+	Name   string // Path or name of the image, empty for readers.
+	Format string // Detected format for decode errors, if known.
+	Err    error
+}
+
+func (e *OpenError) Error() string {
+	s := "images4: " + e.Op
+	if e.Name != "" {
+		s += " " + e.Name
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *OpenError) Unwrap() error { return e.Err }
+
+// OpenReader decodes an image from a reader, such as an HTTP body.
+// It returns the image and the format name, as registered with
+// the image package.
+func OpenReader(r io.Reader) (img image.Image, format string, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", &OpenError{Op: "read", Err: err}
+	}
+	return decodeBytes("", data, false)
+}
+
+// OpenBytes decodes an image from a byte slice.
+func OpenBytes(b []byte) (img image.Image, format string, err error) {
+	return decodeBytes("", b, false)
+}
+
+// OpenFS opens and decodes an image file of a file system,
+// such as embed.FS or a zip archive.
+func OpenFS(fsys fs.FS, name string) (img image.Image, format string, err error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, "", &OpenError{Op: "read", Name: name, Err: err}
+	}
+	return decodeBytes(name, data, false)
+}
+
+// ReadIcon decodes an image from a reader and generates its icon.
+// Images are decoded at a reduced resolution with func
+// DecodeReduced, which is faster for large JPEG images.
+func ReadIcon(r io.Reader) (IconT, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return IconT{}, &OpenError{Op: "read", Err: err}
+	}
+	img, format, err := decodeBytes("", data, true)
+	if err != nil {
+		return IconT{}, err
+	}
+	icon := Icon(img)
+	icon.Meta.Format = format
+	return icon, nil
+}
+
+// openFile reads and decodes an image file for a given path.
+func openFile(path string, reduced bool) (image.Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &OpenError{Op: "read", Name: path, Err: err}
+	}
+	img, _, err := decodeBytes(path, data, reduced)
+	return img, err
+}
+
+// decodeBytes decodes image data, at full resolution or reduced
+// with func DecodeReduced, and wraps errors with the image name.
+func decodeBytes(name string, data []byte, reduced bool) (
+	img image.Image, format string, err error) {
+	if reduced {
+		img, format, err = decodeReduced(data)
+	} else {
+		img, format, err = image.Decode(bytes.NewReader(data))
+	}
+	if err == image.ErrFormat {
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, format, &OpenError{Op: "decode", Name: name, Format: format, Err: err}
+	}
+	return img, format, nil
+}
@@ -0,0 +1,99 @@
+package images4
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+)
+
+func TestOpenVariants(t *testing.T) {
+	name := path.Join("euclidean", "small.gif")
+	data, err := ioutil.ReadFile(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	want, err := Open(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+
+	img1, format1, err1 := OpenReader(bytes.NewReader(data))
+	img2, format2, err2 := OpenBytes(data)
+	img3, format3, err3 := OpenFS(os.DirFS("testdata"), name)
+	for i, table := range []struct {
+		img    image.Image
+		format string
+		err    error
+	}{{img1, format1, err1}, {img2, format2, err2}, {img3, format3, err3}} {
+		if table.err != nil {
+			t.Errorf("Variant %d: %v", i, table.err)
+			continue
+		}
+		if table.format != "gif" {
+			t.Errorf("Variant %d: expected format gif, got %v.", i, table.format)
+		}
+		if !reflect.DeepEqual(table.img, want) {
+			t.Errorf("Variant %d: image differs from func Open.", i)
+		}
+	}
+
+	icon, err := ReadIcon(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error reading icon:", err)
+	}
+	if icon.Meta.Format != "gif" {
+		t.Errorf("Expected format gif in metadata, got %q.", icon.Meta.Format)
+	}
+	icon.Meta.Format = "" // Func Icon does not know the format.
+	if !reflect.DeepEqual(icon, Icon(want)) {
+		t.Error("Icon differs from func Icon.")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	var openErr *OpenError
+	fsys := fstest.MapFS{
+		"text.txt":      {Data: []byte("not an image")},
+		"truncated.png": {Data: []byte("\x89PNG\r\n\x1a\n\x00\x00")},
+	}
+
+	_, _, err := OpenFS(fsys, "text.txt")
+	if !errors.Is(err, ErrUnknownFormat) || !errors.As(err, &openErr) ||
+		openErr.Op != "decode" || openErr.Name != "text.txt" {
+		t.Errorf("Expected unknown format of text.txt, got %v.", err)
+	}
+
+	_, _, err = OpenFS(fsys, "truncated.png")
+	if errors.Is(err, ErrUnknownFormat) || !errors.As(err, &openErr) ||
+		openErr.Op != "decode" || openErr.Format != "png" {
+		t.Errorf("Expected png decode error, got %v.", err)
+	}
+
+	_, _, err = OpenFS(fsys, "missing.png")
+	if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &openErr) ||
+		openErr.Op != "read" || openErr.Name != "missing.png" {
+		t.Errorf("Expected read error of missing.png, got %v.", err)
+	}
+
+	errRead := errors.New("connection reset")
+	_, _, err = OpenReader(iotest.ErrReader(errRead))
+	if !errors.Is(err, errRead) || !errors.As(err, &openErr) || openErr.Op != "read" {
+		t.Errorf("Expected read error, got %v.", err)
+	}
+	if _, err = ReadIcon(iotest.ErrReader(errRead)); !errors.Is(err, errRead) {
+		t.Errorf("Expected read error of ReadIcon, got %v.", err)
+	}
+
+	_, err = Open(path.Join("testdata", "missing.png"))
+	if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &openErr) {
+		t.Errorf("Expected read error of Open, got %v.", err)
+	}
+}
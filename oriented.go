@@ -0,0 +1,174 @@
+package images4
+
+import "encoding/json"
+
+// OrientedIcon holds an icon in all orientations: 4 rotations, or
+// 8 with mirrors. Orientations are computed once by func Orient,
+// so that comparisons of rotated images with func SimilarOriented
+// neither rotate icons nor allocate memory.
+//
+// Serialized oriented icons hold only the original icon and the
+// number of orientations. The rest is computed again on decoding.
+type OrientedIcon struct {
+	variants []IconT // Indexed by Rotation.
+}
+
+// Orient computes all orientations of an icon, also mirrored
+// when mirrors is true. The original icon shares its pixels
+// with the oriented icon, but not its metadata.
+func Orient(icon IconT, mirrors bool) OrientedIcon {
+	n := int(Rotation270) + 1
+	if mirrors {
+		n = int(Mirror270) + 1
+	}
+	// Pixels of all turned icons in one allocation.
+	var buf []uint16
+	if len(icon.Pixels) == 3*numPix {
+		buf = make([]uint16, (n-1)*3*numPix)
+	}
+	variants := make([]IconT, n)
+	variants[Rotation0] = icon.ownMeta()
+	for r := 1; r < n; r++ {
+		var b []uint16
+		if buf != nil {
+			b = buf[(r-1)*3*numPix:]
+		}
+		variants[r] = turn(icon, Rotation(r), b)
+	}
+	return OrientedIcon{variants}
+}
+
+// Icon returns the original icon.
+func (o OrientedIcon) Icon() IconT {
+	if len(o.variants) == 0 {
+		return IconT{}
+	}
+	return o.variants[Rotation0]
+}
+
+// Mirrors tells whether mirrored orientations are included.
+func (o OrientedIcon) Mirrors() bool {
+	return len(o.variants) > int(Mirror0)
+}
+
+// Turned returns the icon turned by rotation r. Mirrored icons
+// are computed on the fly when they are not included.
+func (o OrientedIcon) Turned(r Rotation) IconT {
+	if int(r) < len(o.variants) {
+		return o.variants[r]
+	}
+	return turn(o.Icon(), r, nil)
+}
+
+// SimilarOriented is like SimilarAnyRotation for oriented icons.
+// Mirrors are considered when both icons include them.
+func SimilarOriented(a, b OrientedIcon) (Rotation, bool) {
+	return similarOriented(a, b, nil)
+}
+
+// CustomSimilarOriented is like CustomSimilarAnyRotation for
+// oriented icons. Mirrors are considered when both icons
+// include them.
+func CustomSimilarOriented(a, b OrientedIcon,
+	coeff CustomCoefficients) (Rotation, bool) {
+	return similarOriented(a, b, &coeff)
+}
+
+func similarOriented(a, b OrientedIcon,
+	coeff *CustomCoefficients) (Rotation, bool) {
+	if len(a.variants) == 0 || len(b.variants) == 0 {
+		return Rotation0, false
+	}
+	n := len(a.variants)
+	if len(b.variants) < n {
+		n = len(b.variants)
+	}
+	// As in func anyRotation, only the icon first in order is turned.
+	if iconLess(b.variants[Rotation0], a.variants[Rotation0]) {
+		r, ok := closestRotation(b.variants[:n], a.variants[Rotation0], coeff)
+		return r.Inverse(), ok
+	}
+	return closestRotation(a.variants[:n], b.variants[Rotation0], coeff)
+}
+
+// MarshalBinary encodes an oriented icon as the number of
+// orientations (0, 4 or 8) in one byte, followed by the binary
+// form of the original icon. It implements
+// encoding.BinaryMarshaler.
+func (o OrientedIcon) MarshalBinary() ([]byte, error) {
+	data, err := o.Icon().MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(len(o.variants))}, data...), nil
+}
+
+// UnmarshalBinary decodes an oriented icon encoded with
+// MarshalBinary and computes its orientations. It implements
+// encoding.BinaryUnmarshaler.
+func (o *OrientedIcon) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrInvalidEncoding
+	}
+	var icon IconT
+	if err := icon.UnmarshalBinary(data[1:]); err != nil {
+		return err
+	}
+	switch int(data[0]) {
+	case 0:
+		*o = OrientedIcon{}
+	case int(Rotation270) + 1:
+		*o = Orient(icon, false)
+	case int(Mirror270) + 1:
+		*o = Orient(icon, true)
+	default:
+		return ErrInvalidEncoding
+	}
+	return nil
+}
+
+// MarshalText encodes an oriented icon as an unpadded base64url
+// string of its binary form. It implements encoding.TextMarshaler.
+func (o OrientedIcon) MarshalText() ([]byte, error) {
+	data, err := o.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, iconText.EncodedLen(len(data)))
+	iconText.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText decodes an oriented icon encoded with MarshalText.
+// It implements encoding.TextUnmarshaler.
+func (o *OrientedIcon) UnmarshalText(text []byte) error {
+	data := make([]byte, iconText.DecodedLen(len(text)))
+	n, err := iconText.Decode(data, text)
+	if err != nil {
+		return ErrInvalidEncoding
+	}
+	return o.UnmarshalBinary(data[:n])
+}
+
+// MarshalJSON encodes an oriented icon as a JSON string holding
+// its text form. It implements json.Marshaler.
+func (o OrientedIcon) MarshalJSON() ([]byte, error) {
+	text, err := o.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// UnmarshalJSON decodes an oriented icon from its JSON string
+// form. It implements json.Unmarshaler.
+func (o *OrientedIcon) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return o.UnmarshalText([]byte(text))
+}
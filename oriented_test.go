@@ -0,0 +1,116 @@
+package images4
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func orientedTestIcons(t testing.TB) (icon0, icon90 IconT) {
+	img0, err := Open(path.Join("testdata", "rotate", "0.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img90, err := Open(path.Join("testdata", "rotate", "90.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Icon(img0), Icon(img90)
+}
+
+func TestOrient(t *testing.T) {
+	icon0, icon90 := orientedTestIcons(t)
+	o := Orient(icon0, true)
+	if !o.Mirrors() || Orient(icon0, false).Mirrors() {
+		t.Error("Wrong mirrors flag.")
+	}
+	if !reflect.DeepEqual(o.Icon(), icon0) {
+		t.Error("Original icon changed.")
+	}
+	if !reflect.DeepEqual(o.Turned(Rotation90), Rotate90(icon0)) {
+		t.Error("Turned by 90° differs from Rotate90.")
+	}
+	if !reflect.DeepEqual(o.Turned(Mirror0), Mirror(icon0)) {
+		t.Error("Mirrored icon differs from Mirror.")
+	}
+	if !reflect.DeepEqual(Orient(icon0, false).Turned(Mirror180), o.Turned(Mirror180)) {
+		t.Error("Mirrors computed on the fly differ.")
+	}
+
+	for _, mirrors := range []bool{false, true} {
+		a, b := Orient(icon0, mirrors), Orient(Mirror(icon90), mirrors)
+		r, ok := SimilarOriented(a, b)
+		wantR, wantOK := SimilarAnyRotation(icon0, Mirror(icon90), mirrors)
+		if r != wantR || ok != wantOK {
+			t.Errorf("Mirrors %v: expected %v, %v, got %v, %v.",
+				mirrors, wantR, wantOK, r, ok)
+		}
+		r, ok = CustomSimilarOriented(b, a, CustomCoefficients{1, 1, 1, 1})
+		wantR, wantOK = CustomSimilarAnyRotation(Mirror(icon90), icon0,
+			CustomCoefficients{1, 1, 1, 1}, mirrors)
+		if r != wantR || ok != wantOK {
+			t.Errorf("Mirrors %v: expected %v, %v, got %v, %v in reverse.",
+				mirrors, wantR, wantOK, r, ok)
+		}
+	}
+	if _, ok := SimilarOriented(OrientedIcon{}, Orient(icon0, false)); ok {
+		t.Error("Empty oriented icon must not be similar.")
+	}
+}
+
+func TestSimilarOrientedAllocs(t *testing.T) {
+	icon0, icon90 := orientedTestIcons(t)
+	a, b := Orient(icon0, true), Orient(icon90, true)
+	allocs := testing.AllocsPerRun(100, func() {
+		SimilarOriented(a, b)
+		CustomSimilarOriented(b, a, CustomCoefficients{1, 1, 1, 1})
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %v.", allocs)
+	}
+}
+
+func TestOrientedIconEncoding(t *testing.T) {
+	icon0, _ := orientedTestIcons(t)
+	for _, o := range []OrientedIcon{
+		Orient(icon0, false), Orient(icon0, true), {}} {
+		data, err := json.Marshal(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded OrientedIcon
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, o) {
+			t.Errorf("Decoded oriented icon differs, mirrors %v.", o.Mirrors())
+		}
+	}
+
+	var o OrientedIcon
+	for _, data := range [][]byte{{}, {3, 1, 0, 0, 0, 0, 0, 0, 0, 0}, {4, 2}} {
+		if err := o.UnmarshalBinary(data); err != ErrInvalidEncoding {
+			t.Errorf("Expected ErrInvalidEncoding for %v, got %v.", data, err)
+		}
+	}
+}
+
+func BenchmarkSimilarOriented(b *testing.B) {
+	icon0, icon90 := orientedTestIcons(b)
+	o0, o90 := Orient(icon0, true), Orient(icon90, true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SimilarOriented(o0, o90)
+	}
+}
+
+func BenchmarkSimilarAnyRotation(b *testing.B) {
+	icon0, icon90 := orientedTestIcons(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SimilarAnyRotation(icon0, icon90, true)
+	}
+}
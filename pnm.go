@@ -0,0 +1,270 @@
+package images4
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Decoder of Netpbm images (PNM): PBM (P1, P4), PGM (P2, P5) and
+// PPM (P3, P6), in ASCII and binary forms, with maximum sample
+// values up to 65535. It is registered with the image package,
+// so that func Open and image.Decode recognize PNM files.
+
+func init() {
+	for _, magic := range []string{"P1", "P2", "P3", "P4", "P5", "P6"} {
+		image.RegisterFormat("pnm", magic, decodePNM, decodePNMConfig)
+	}
+}
+
+var errPNMFormat = errors.New("images4: pnm: invalid format")
+
+type pnmHeader struct {
+	kind          byte // '1' to '6', as in the magic number.
+	width, height int
+	maxVal        int
+}
+
+// channels returns the number of samples per pixel.
+func (h *pnmHeader) channels() int {
+	if h.kind == '3' || h.kind == '6' {
+		return 3
+	}
+	return 1
+}
+
+// ascii reports whether samples are decimal numbers.
+func (h *pnmHeader) ascii() bool {
+	return h.kind <= '3'
+}
+
+func (h *pnmHeader) colorModel() color.Model {
+	switch {
+	case h.channels() == 1 && h.maxVal < 256:
+		return color.GrayModel
+	case h.channels() == 1:
+		return color.Gray16Model
+	case h.maxVal < 256:
+		return color.RGBAModel
+	}
+	return color.RGBA64Model
+}
+
+func readPNMHeader(br *bufio.Reader) (*pnmHeader, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if magic[0] != 'P' || magic[1] < '1' || magic[1] > '6' {
+		return nil, errPNMFormat
+	}
+	h := &pnmHeader{kind: magic[1], maxVal: 1}
+	var err error
+	if h.width, err = readPNMNumber(br); err != nil {
+		return nil, err
+	}
+	if h.height, err = readPNMNumber(br); err != nil {
+		return nil, err
+	}
+	if h.kind != '1' && h.kind != '4' {
+		if h.maxVal, err = readPNMNumber(br); err != nil {
+			return nil, err
+		}
+	}
+	if h.width <= 0 || h.height <= 0 ||
+		h.width > maxDecodePixels/h.height ||
+		h.maxVal <= 0 || h.maxVal > 65535 {
+		return nil, errPNMFormat
+	}
+	if !h.ascii() {
+		// Exactly one whitespace character precedes the raster.
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !pnmSpace(c) {
+			return nil, errPNMFormat
+		}
+	}
+	return h, nil
+}
+
+func pnmSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' ||
+		c == '\v' || c == '\f'
+}
+
+// skipPNMSpace skips whitespace and comments.
+func skipPNMSpace(br *bufio.Reader) error {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if c == '#' {
+			if _, err := br.ReadSlice('\n'); err != nil && err != bufio.ErrBufferFull {
+				return unexpectedEOF(err)
+			}
+			continue
+		}
+		if !pnmSpace(c) {
+			return br.UnreadByte()
+		}
+	}
+}
+
+// readPNMNumber reads a decimal number preceded by whitespace
+// or comments. It leaves the delimiter following the number unread.
+func readPNMNumber(br *bufio.Reader) (int, error) {
+	if err := skipPNMSpace(br); err != nil {
+		return 0, err
+	}
+	n, digits := 0, 0
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF && digits > 0 {
+			return n, nil
+		}
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if c < '0' || c > '9' {
+			if digits == 0 {
+				return 0, errPNMFormat
+			}
+			return n, br.UnreadByte()
+		}
+		if n > maxDecodePixels {
+			return 0, errPNMFormat
+		}
+		n = n*10 + int(c-'0')
+		digits++
+	}
+}
+
+func decodePNMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
+
+func decodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readPNMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if h.kind == '1' || h.kind == '4' {
+		return decodePBM(br, h)
+	}
+
+	// Samples scaled to 16 bits.
+	var sample func() (uint32, error)
+	switch {
+	case h.ascii():
+		sample = func() (uint32, error) {
+			v, err := readPNMNumber(br)
+			return uint32(v), err
+		}
+	case h.maxVal < 256:
+		sample = func() (uint32, error) {
+			c, err := br.ReadByte()
+			return uint32(c), unexpectedEOF(err)
+		}
+	default:
+		sample = func() (uint32, error) {
+			var b [2]byte
+			_, err := io.ReadFull(br, b[:])
+			return uint32(b[0])<<8 | uint32(b[1]), unexpectedEOF(err)
+		}
+	}
+	max := uint32(h.maxVal)
+	scaled := func() (uint32, error) {
+		v, err := sample()
+		if v > max { // Out of range values are clamped.
+			v = max
+		}
+		return (v*65535 + max/2) / max, err
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	var img image.Image
+	var pix []byte
+	var depth int // Bytes per sample.
+	switch h.colorModel() {
+	case color.GrayModel:
+		g := image.NewGray(rect)
+		img, pix, depth = g, g.Pix, 1
+	case color.Gray16Model:
+		g := image.NewGray16(rect)
+		img, pix, depth = g, g.Pix, 2
+	case color.RGBAModel:
+		c := image.NewRGBA(rect)
+		img, pix, depth = c, c.Pix, 1
+	default:
+		c := image.NewRGBA64(rect)
+		img, pix, depth = c, c.Pix, 2
+	}
+	channels := h.channels()
+	// Destination samples per pixel (RGB images have alpha).
+	stride := channels
+	if channels == 3 {
+		stride = 4
+	}
+	for i := 0; i < h.width*h.height; i++ {
+		for c := 0; c < stride; c++ {
+			v := uint32(65535)
+			if c < channels {
+				if v, err = scaled(); err != nil {
+					return nil, err
+				}
+			}
+			off := (i*stride + c) * depth
+			if depth == 1 {
+				pix[off] = uint8(v >> 8)
+			} else {
+				pix[off], pix[off+1] = uint8(v>>8), uint8(v)
+			}
+		}
+	}
+	return img, nil
+}
+
+// decodePBM decodes bilevel images, where 1 is black.
+func decodePBM(br *bufio.Reader, h *pnmHeader) (image.Image, error) {
+	img := image.NewGray(image.Rect(0, 0, h.width, h.height))
+	rowLen := (h.width + 7) / 8
+	row := make([]byte, rowLen)
+	for y := 0; y < h.height; y++ {
+		if h.ascii() {
+			for x := 0; x < h.width; x++ {
+				// Digits need not be separated by whitespace.
+				if err := skipPNMSpace(br); err != nil {
+					return nil, err
+				}
+				c, _ := br.ReadByte()
+				if c != '0' && c != '1' {
+					return nil, errPNMFormat
+				}
+				if c == '0' {
+					img.Pix[y*img.Stride+x] = 255
+				}
+			}
+			continue
+		}
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		for x := 0; x < h.width; x++ {
+			if row[x/8]&(0x80>>uint(x%8)) == 0 {
+				img.Pix[y*img.Stride+x] = 255
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,55 @@
+package images4
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestDecodePNM(t *testing.T) {
+	tables := []struct {
+		fileName, refName string
+	}{
+		{"p1.pbm", "bilevel.png"},
+		{"p4.pbm", "bilevel.png"},
+		{"p2.pgm", "gray.png"},
+		{"p5.pgm", "gray.png"},
+		{"p5-16bit.pgm", "gray16.png"},
+		{"p3.ppm", "rgb.png"},
+		{"p6.ppm", "rgb.png"},
+		{"p6-16bit.ppm", "rgb16.png"},
+	}
+	for _, table := range tables {
+		testDecodeFormat(table.fileName, table.refName, "pnm", t)
+	}
+}
+
+func TestReadPNMHeader(t *testing.T) {
+	tables := []struct {
+		header string
+		valid  bool
+	}{
+		{"P6 3 2 255\n", true},
+		{"P5\n# comment\n3 # another\n2\n1000\n", true},
+		{"P4\n3 2\n", true},
+		{"P6 3 2 255", false},     // No whitespace before raster.
+		{"P6 3 2 65536\n", false}, // Maximum value too large.
+		{"P6 0 2 255\n", false},
+		{"P6 3\n", false},
+		{"P7 3 2 255\n", false},
+	}
+	for _, table := range tables {
+		_, err := readPNMHeader(bufio.NewReader(strings.NewReader(table.header)))
+		if (err == nil) != table.valid {
+			t.Errorf("Header %q: expected valid %v, got error %v.",
+				table.header, table.valid, err)
+		}
+	}
+}
+
+func FuzzDecodePNM(f *testing.F) {
+	fuzzSeeds(f, "*.p?m")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, data, decodePNM, decodePNMConfig)
+	})
+}
@@ -0,0 +1,229 @@
+package images4
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Candidate is an image of a group of duplicates, as found by
+// func Similar. Funcs Rank and WriteReport take groups of them.
+type Candidate struct {
+	Path string
+	Icon IconT
+}
+
+// RankWeights weights quality signals of func Rank. Each signal is
+// scored in [0, 1] relative to the best candidate of the group, and
+// the score of a candidate is the weighted sum.
+type RankWeights struct {
+	Resolution  float64 // Pixels (of IconT.ImgSize).
+	FileSize    float64 // Bytes of the file.
+	Lossless    float64 // Lossless formats: PNG, BMP, TIFF, PNM, lossless WebP.
+	JPEGQuality float64 // Estimated from quantization tables. Lossless is 100.
+	Date        float64 // The earliest EXIF date, as of the original.
+}
+
+// DefaultRankWeights prefer resolution over compression quality,
+// and consider file size and EXIF date only to break near-ties.
+var DefaultRankWeights = RankWeights{
+	Resolution:  4,
+	FileSize:    0.5,
+	Lossless:    1,
+	JPEGQuality: 2,
+	Date:        0.25,
+}
+
+// RankedImage is a candidate ranked by func Rank.
+type RankedImage struct {
+	Candidate
+	Index       int     // Index in the group.
+	Score       float64 // Weighted sum of signal scores.
+	Explanation []string
+}
+
+// rankSignals are quality signals of a candidate file.
+type rankSignals struct {
+	pixels   int
+	fileSize int64 // -1 if unreadable.
+	format   string
+	lossless bool
+	quality  int // JPEG quality 1 to 100, 0 if unknown.
+	date     time.Time
+}
+
+// Rank orders a group of duplicates by quality, best first, to pick
+// the one to keep. Files are read for their size, format, JPEG
+// quality and EXIF date. Explanations list the signals with their
+// scores. Unreadable files get zero scores for the file signals.
+func Rank(group []Candidate, weights RankWeights) []RankedImage {
+	signals := make([]rankSignals, len(group))
+	var best rankSignals
+	for i, c := range group {
+		s := readRankSignals(c)
+		signals[i] = s
+		if s.pixels > best.pixels {
+			best.pixels = s.pixels
+		}
+		if s.fileSize > best.fileSize {
+			best.fileSize = s.fileSize
+		}
+		if !s.date.IsZero() && (best.date.IsZero() || s.date.Before(best.date)) {
+			best.date = s.date
+		}
+	}
+
+	ranked := make([]RankedImage, len(group))
+	for i, s := range signals {
+		r := RankedImage{Candidate: group[i], Index: i}
+		add := func(weight, score float64, format string, args ...interface{}) {
+			r.Score += weight * score
+			r.Explanation = append(r.Explanation,
+				fmt.Sprintf(format, args...)+fmt.Sprintf(": score %.2f, weight %g", score, weight))
+		}
+
+		score := 0.0
+		if best.pixels > 0 {
+			score = float64(s.pixels) / float64(best.pixels)
+		}
+		add(weights.Resolution, score, "resolution %dx%d",
+			group[i].Icon.ImgSize.X, group[i].Icon.ImgSize.Y)
+
+		if s.fileSize < 0 {
+			r.Explanation = append(r.Explanation, "file unreadable")
+			ranked[i] = r
+			continue
+		}
+		score = 0
+		if best.fileSize > 0 {
+			score = float64(s.fileSize) / float64(best.fileSize)
+		}
+		add(weights.FileSize, score, "file size %d bytes", s.fileSize)
+
+		score = 0
+		if s.lossless {
+			score = 1
+		}
+		add(weights.Lossless, score, "format %s", s.format)
+
+		switch {
+		case s.lossless:
+			add(weights.JPEGQuality, 1, "lossless quality")
+		case s.quality > 0:
+			add(weights.JPEGQuality, float64(s.quality)/100, "JPEG quality %d", s.quality)
+		default:
+			add(weights.JPEGQuality, 0, "unknown quality")
+		}
+
+		if !s.date.IsZero() {
+			score = 0
+			if s.date.Equal(best.date) {
+				score = 1
+			}
+			add(weights.Date, score, "EXIF date %s", s.date.Format("2006-01-02 15:04:05"))
+		}
+		ranked[i] = r
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+func readRankSignals(c Candidate) rankSignals {
+	s := rankSignals{pixels: c.Icon.ImgSize.X * c.Icon.ImgSize.Y, fileSize: -1}
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return s
+	}
+	s.fileSize = int64(len(data))
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		s.format = "unknown"
+		return s
+	}
+	s.format = format
+	switch format {
+	case "png", "bmp", "tiff", "pnm":
+		s.lossless = true
+	case "webp":
+		s.lossless = webpLossless(data)
+	case "jpeg":
+		s.quality = jpegQuality(data)
+	}
+	if info, ok := readEXIF(data); ok {
+		s.date = info.date
+	}
+	return s
+}
+
+// jpegStdLuminance is the luminance quantization table of the JPEG
+// specification (annex K), in natural order, which encoders such as
+// libjpeg and image/jpeg scale by quality.
+var jpegStdLuminance = [64]int32{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// jpegQuality estimates the quality setting (1 to 100) of a JPEG
+// encoder from the first quantization table, by inverting the
+// libjpeg scaling of the standard table. It returns 0 when there
+// is no table.
+func jpegQuality(data []byte) int {
+	var quant [64]int32
+	found := false
+	pos := 2
+	for !found && pos+4 <= len(data) && data[pos] == 0xff {
+		marker := data[pos+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		seg := data[pos+4 : pos+2+length]
+		pos += 2 + length
+		if marker != jpegDQT || len(seg) < 65 || seg[0]&15 != 0 {
+			continue
+		}
+		wide := seg[0]>>4 != 0 // 16-bit values.
+		if wide && len(seg) < 129 {
+			continue
+		}
+		for k := 0; k < 64; k++ {
+			if wide {
+				quant[jpegUnzigzag[k]] = int32(seg[1+2*k])<<8 | int32(seg[2+2*k])
+			} else {
+				quant[jpegUnzigzag[k]] = int32(seg[1+k])
+			}
+		}
+		found = true
+	}
+	if !found {
+		return 0
+	}
+	var sum, std int32
+	for i := range quant {
+		sum += quant[i]
+		std += jpegStdLuminance[i]
+	}
+	scale := 100 * float64(sum) / float64(std)
+	var q float64
+	if scale <= 100 {
+		q = (200 - scale) / 2
+	} else {
+		q = 5000 / scale
+	}
+	return clampInt(int(q+0.5), 1, 100)
+}
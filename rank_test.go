@@ -0,0 +1,96 @@
+package images4
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJPEGQuality(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "euclidean", "small.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	for _, quality := range []int{30, 50, 75, 90, 100} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal("Error encoding JPEG:", err)
+		}
+		if got := jpegQuality(buf.Bytes()); absInt(got-quality) > 1 {
+			t.Errorf("Expected quality %d, got %d.", quality, got)
+		}
+	}
+	if got := jpegQuality([]byte{0xff, 0xd8}); got != 0 {
+		t.Errorf("Expected unknown quality, got %d.", got)
+	}
+	// A 16-bit table (precision 1) truncated to 8-bit size.
+	short := append([]byte{0xff, 0xd8, 0xff, 0xdb, 0, 67, 0x10}, make([]byte, 64)...)
+	if got := jpegQuality(short); got != 0 {
+		t.Errorf("Expected unknown quality of a truncated table, got %d.", got)
+	}
+}
+
+func TestRank(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "euclidean", "small.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	small, _ := ResizeByNearest(img, image.Point{100, 75})
+	dir := t.TempDir()
+	write := func(name string, encode func(*os.File) error) Candidate {
+		p := filepath.Join(dir, name)
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal("Error creating file:", err)
+		}
+		defer f.Close()
+		if err := encode(f); err != nil {
+			t.Fatal("Error encoding:", err)
+		}
+		return Candidate{Path: p}
+	}
+	group := []Candidate{
+		write("q50.jpg", func(f *os.File) error {
+			return jpeg.Encode(f, img, &jpeg.Options{Quality: 50})
+		}),
+		write("small.png", func(f *os.File) error { return png.Encode(f, &small) }),
+		write("q95.jpg", func(f *os.File) error {
+			return jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+		}),
+		write("full.png", func(f *os.File) error { return png.Encode(f, img) }),
+		{Path: filepath.Join(dir, "missing.jpg")},
+	}
+	for i := range group {
+		group[i].Icon.ImgSize = img.Bounds().Size()
+	}
+	group[1].Icon.ImgSize = image.Point{100, 75}
+
+	ranked := Rank(group, DefaultRankWeights)
+	var order []string
+	for _, r := range ranked {
+		order = append(order, filepath.Base(r.Path))
+		if group[r.Index].Path != r.Path {
+			t.Errorf("Wrong index %d of %v.", r.Index, r.Path)
+		}
+	}
+	want := "full.png q95.jpg q50.jpg missing.jpg small.png"
+	if got := strings.Join(order, " "); got != want {
+		t.Errorf("Expected order %v, got %v.", want, got)
+	}
+	explanation := strings.Join(ranked[1].Explanation, "; ")
+	if !strings.Contains(explanation, "JPEG quality 95") ||
+		!strings.Contains(explanation, "format jpeg") {
+		t.Errorf("Unexpected explanation %q.", explanation)
+	}
+
+	// Resolution only.
+	ranked = Rank(group, RankWeights{Resolution: 1})
+	if ranked[len(ranked)-1].Path != group[1].Path {
+		t.Errorf("Expected small.png last, got %v.", ranked[len(ranked)-1].Path)
+	}
+}
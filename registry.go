@@ -0,0 +1,198 @@
+package images4
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Decoder is an image format decoder of a Registry.
+type Decoder struct {
+	// Name of the format, such as "jpeg".
+	Name string
+	// Magic strings identifying the format at the start of data.
+	// Byte '?' matches any byte, as in image.RegisterFormat.
+	Magic []string
+	// Decode decodes an image.
+	Decode func(io.Reader) (image.Image, error)
+	// DecodeConfig decodes the size and color model of an image.
+	// When nil, Limits on pixels and memory are not checked.
+	DecodeConfig func(io.Reader) (image.Config, error)
+	// Priority orders decoders of matching magic strings, higher
+	// first. For example, a decoder registered with priority 1
+	// replaces a default decoder of the same magic.
+	Priority int
+	// Limits protect from decompression bombs of the format.
+	Limits Limits
+}
+
+// Registry is a set of image decoders selected by the content of
+// image data, as an alternative to decoders registered globally
+// with the image package. It lets callers control which formats
+// are decoded per call, and add decoders, for example of HEIC or
+// AVIF from other packages. A Registry is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	decoders []Decoder // Sorted by priority, stable.
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// DefaultRegistry creates a registry of the formats of func Open:
+// JPEG, PNG, GIF, BMP, TIFF, PNM and WebP, with priority 0 and no
+// limits. Each call returns a new registry.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(Decoder{Name: "jpeg", Magic: []string{"\xff\xd8"},
+		Decode: jpeg.Decode, DecodeConfig: jpeg.DecodeConfig})
+	r.Register(Decoder{Name: "png", Magic: []string{"\x89PNG\r\n\x1a\n"},
+		Decode: png.Decode, DecodeConfig: png.DecodeConfig})
+	r.Register(Decoder{Name: "gif", Magic: []string{"GIF87a", "GIF89a"},
+		Decode: gif.Decode, DecodeConfig: gif.DecodeConfig})
+	r.Register(Decoder{Name: "bmp", Magic: []string{"BM"},
+		Decode: decodeBMP, DecodeConfig: decodeBMPConfig})
+	r.Register(Decoder{Name: "tiff", Magic: []string{"II\x2A\x00", "MM\x00\x2A"},
+		Decode: decodeTIFF, DecodeConfig: decodeTIFFConfig})
+	r.Register(Decoder{Name: "pnm", Magic: []string{"P1", "P2", "P3", "P4", "P5", "P6"},
+		Decode: decodePNM, DecodeConfig: decodePNMConfig})
+	r.Register(Decoder{Name: "webp", Magic: []string{"RIFF????WEBPVP8"},
+		Decode: decodeWebP, DecodeConfig: decodeWebPConfig})
+	return r
+}
+
+// Register adds a decoder. Decoders of equal priority are tried
+// in the order of registration.
+func (r *Registry) Register(d Decoder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decoders = append(r.decoders, d)
+	sort.SliceStable(r.decoders, func(i, j int) bool {
+		return r.decoders[i].Priority > r.decoders[j].Priority
+	})
+}
+
+// Formats returns names of registered formats, in the order
+// decoders are tried.
+func (r *Registry) Formats() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	seen := make(map[string]bool)
+	for _, d := range r.decoders {
+		if !seen[d.Name] {
+			seen[d.Name] = true
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}
+
+// Detect finds the decoder of image data by its magic strings.
+func (r *Registry) Detect(data []byte) (Decoder, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.decoders {
+		for _, magic := range d.Magic {
+			if matchMagic(magic, data) {
+				return d, true
+			}
+		}
+	}
+	return Decoder{}, false
+}
+
+// matchMagic reports whether data starts with magic, where '?'
+// matches any byte.
+func matchMagic(magic string, data []byte) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != data[i] && magic[i] != '?' {
+			return false
+		}
+	}
+	return true
+}
+
+// Open is like func Open, with decoders of the registry.
+func (r *Registry) Open(path string) (img image.Image, format string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", &OpenError{Op: "read", Name: path, Err: err}
+	}
+	defer file.Close()
+	return r.decode(path, file)
+}
+
+// OpenReader is like func OpenReader, with decoders of the
+// registry.
+func (r *Registry) OpenReader(rd io.Reader) (img image.Image, format string, err error) {
+	return r.decode("", rd)
+}
+
+func (r *Registry) decode(name string, rd io.Reader) (image.Image, string, error) {
+	// Only magic bytes are read before the format, and its limit on
+	// input size, are known.
+	head := make([]byte, r.magicLen())
+	n, err := io.ReadFull(rd, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", &OpenError{Op: "read", Name: name, Err: err}
+	}
+	head = head[:n]
+	d, ok := r.Detect(head)
+	if !ok {
+		return nil, "", &OpenError{Op: "decode", Name: name, Err: ErrUnknownFormat}
+	}
+	rest := io.MultiReader(bytes.NewReader(head), rd)
+	if d.Limits.MaxBytes > 0 {
+		rest = io.LimitReader(rest, d.Limits.MaxBytes+1)
+	}
+	data, err := ioutil.ReadAll(rest)
+	if err != nil {
+		return nil, d.Name, &OpenError{Op: "read", Name: name, Format: d.Name, Err: err}
+	}
+	if d.Limits.MaxBytes > 0 && int64(len(data)) > d.Limits.MaxBytes {
+		return nil, d.Name, &OpenError{Op: "read", Name: name, Format: d.Name,
+			Err: &LimitError{"bytes", int64(len(data)), d.Limits.MaxBytes}}
+	}
+	if d.DecodeConfig != nil && (d.Limits.MaxPixels > 0 || d.Limits.MaxMemory > 0) {
+		config, err := d.DecodeConfig(bytes.NewReader(data))
+		if err == nil {
+			err = d.Limits.check(config)
+		}
+		if err != nil {
+			return nil, d.Name, &OpenError{Op: "decode", Name: name, Format: d.Name, Err: err}
+		}
+	}
+	img, err := d.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, d.Name, &OpenError{Op: "decode", Name: name, Format: d.Name, Err: err}
+	}
+	return img, d.Name, nil
+}
+
+// magicLen returns the length of the longest magic string.
+func (r *Registry) magicLen() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var n int
+	for _, d := range r.decoders {
+		for _, magic := range d.Magic {
+			if len(magic) > n {
+				n = len(magic)
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package images4
+
+import (
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultRegistry(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "formats", "*"))
+	if err != nil || len(paths) == 0 {
+		t.Fatal("No test files.", err)
+	}
+	paths = append(paths, filepath.Join("testdata", "euclidean", "large.jpg"))
+	reg := DefaultRegistry()
+	for _, path := range paths {
+		img, format, err := reg.Open(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		want, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantFormat := strings.TrimPrefix(filepath.Ext(path), ".")
+		switch wantFormat {
+		case "jpg":
+			wantFormat = "jpeg"
+		case "pbm", "pgm", "ppm":
+			wantFormat = "pnm"
+		}
+		if format != wantFormat {
+			t.Errorf("%s: expected format %s, got %s.", path, wantFormat, format)
+		}
+		if img.Bounds() != want.Bounds() || !Similar(Icon(img), Icon(want)) {
+			t.Errorf("%s: image differs from func Open.", path)
+		}
+	}
+
+	want := []string{"jpeg", "png", "gif", "bmp", "tiff", "pnm", "webp"}
+	if got := reg.Formats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected formats %v, got %v.", want, got)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join("testdata", "euclidean", "large.jpg")
+	reg := NewRegistry()
+	_, _, err := reg.Open(path)
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got %v.", err)
+	}
+
+	// A custom decoder with a wildcard magic.
+	var calls int
+	fake := func(r io.Reader) (image.Image, error) {
+		calls++
+		return image.NewGray(image.Rect(0, 0, 2, 3)), nil
+	}
+	reg.Register(Decoder{Name: "fake", Magic: []string{"\xff?\x00"}, Decode: fake})
+	if _, _, err := reg.Open(path); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got %v.", err)
+	}
+
+	// Priority over the default decoder.
+	reg = DefaultRegistry()
+	reg.Register(Decoder{Name: "fake", Magic: []string{"\xff?"}, Decode: fake, Priority: 1})
+	img, format, err := reg.Open(path)
+	if err != nil || format != "fake" || img.Bounds().Dx() != 2 || calls != 1 {
+		t.Errorf("Expected the fake decoder, got %v, %v.", format, err)
+	}
+	if reg.Formats()[0] != "fake" {
+		t.Errorf("Expected fake first, got %v.", reg.Formats())
+	}
+
+	// Limits of a format.
+	reg = NewRegistry()
+	d, _ := DefaultRegistry().Detect([]byte("\x89PNG\r\n\x1a\n"))
+	d.Limits = Limits{MaxPixels: 100}
+	reg.Register(d)
+	file, err := os.Open(filepath.Join("testdata", "formats", "gray.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var limit *LimitError
+	if _, _, err := reg.OpenReader(file); !errors.As(err, &limit) || limit.Limit != "pixels" {
+		t.Errorf("Expected a pixels limit error, got %v.", err)
+	}
+}
+
+// endlessReader returns a header followed by endless zeros, and
+// counts bytes read.
+type endlessReader struct {
+	header []byte
+	n      int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	n := copy(p, r.header)
+	r.header = r.header[n:]
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+	r.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestRegistryMaxBytes(t *testing.T) {
+	reg := NewRegistry()
+	d, _ := DefaultRegistry().Detect([]byte("\x89PNG\r\n\x1a\n"))
+	d.Limits = Limits{MaxBytes: 1000}
+	reg.Register(d)
+	r := &endlessReader{header: []byte("\x89PNG\r\n\x1a\n")}
+	var limit *LimitError
+	if _, _, err := reg.OpenReader(r); !errors.As(err, &limit) || limit.Limit != "bytes" {
+		t.Errorf("Expected a bytes limit error, got %v.", err)
+	}
+	if r.n > 1001 {
+		t.Errorf("Expected reading to stop at the limit, read %d bytes.", r.n)
+	}
+}
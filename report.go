@@ -0,0 +1,174 @@
+package images4
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+// Thumbnail size of func WriteReport, for the longer image side.
+const reportThumbSize = 160
+
+// WriteReport writes a self-contained HTML report of duplicate
+// groups, for reviewers to decide what to delete. For each image it
+// shows a thumbnail (embedded as a data URI), the image size and the
+// file size. For each pair within a group it shows EucMetric values
+// as percentages of the Similar thresholds, and PropMetric. The
+// suggested "keep" candidate of a group is the first of func Rank
+// with DefaultRankWeights.
+//
+// Images are read again from their paths for thumbnails and file
+// sizes. Unreadable images show their icons instead.
+func WriteReport(w io.Writer, groups [][]Candidate) error {
+	y, cbcr, prop := Thresholds()
+	data := reportData{PropThreshold: prop}
+	for _, group := range groups {
+		var g reportGroup
+		for _, img := range group {
+			g.Images = append(g.Images, newReportImage(img))
+		}
+		if ranked := Rank(group, DefaultRankWeights); len(ranked) > 0 {
+			g.Images[ranked[0].Index].Keep = true
+		}
+		for i := 0; i < len(group); i++ {
+			for j := i + 1; j < len(group); j++ {
+				if len(group[i].Icon.Pixels) != 3*numPix ||
+					len(group[j].Icon.Pixels) != 3*numPix {
+					continue // Missing icons have no metrics.
+				}
+				m1, m2, m3 := EucMetric(group[i].Icon, group[j].Icon)
+				g.Pairs = append(g.Pairs, reportPair{
+					A: i + 1, B: j + 1,
+					Y: 100 * m1 / y, Cb: 100 * m2 / cbcr, Cr: 100 * m3 / cbcr,
+					Prop: PropMetric(group[i].Icon, group[j].Icon),
+				})
+			}
+		}
+		data.Groups = append(data.Groups, g)
+	}
+	return reportTemplate.Execute(w, data)
+}
+
+type reportData struct {
+	Groups        []reportGroup
+	PropThreshold float64 // Of func Similar.
+}
+
+type reportGroup struct {
+	Images []reportImage
+	Pairs  []reportPair
+}
+
+type reportImage struct {
+	Path     string
+	Size     image.Point
+	FileSize int64 // -1 if unknown.
+	Thumb    template.URL
+	Keep     bool
+}
+
+type reportPair struct {
+	A, B      int // Image numbers within the group, from 1.
+	Y, Cb, Cr float64
+	Prop      float64
+}
+
+func newReportImage(img Candidate) reportImage {
+	r := reportImage{Path: img.Path, Size: img.Icon.ImgSize, FileSize: -1}
+	if info, err := os.Stat(img.Path); err == nil {
+		r.FileSize = info.Size()
+	}
+	var thumb image.Image
+	if src, err := OpenReduced(img.Path); err == nil {
+		thumb = thumbnail(src)
+	} else if img.Icon.Pixels != nil {
+		thumb = img.Icon.Render(RenderOptions{Scale: reportThumbSize / IconSize})
+	}
+	if thumb != nil {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err == nil {
+			r.Thumb = template.URL("data:image/jpeg;base64," +
+				base64.StdEncoding.EncodeToString(buf.Bytes()))
+		}
+	}
+	return r
+}
+
+// thumbnail resizes an image to fit reportThumbSize, preserving
+// proportions.
+func thumbnail(img image.Image) image.Image {
+	size := img.Bounds().Size()
+	if size.X <= 0 || size.Y <= 0 {
+		return img
+	}
+	dst := image.Point{reportThumbSize, reportThumbSize}
+	if size.X > size.Y {
+		dst.Y = (reportThumbSize*size.Y + size.X/2) / size.X
+	} else {
+		dst.X = (reportThumbSize*size.X + size.Y/2) / size.Y
+	}
+	if dst.X < 1 {
+		dst.X = 1
+	}
+	if dst.Y < 1 {
+		dst.Y = 1
+	}
+	resized, _ := ResizeByNearest(img, dst)
+	return &resized
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": func(n int64) string {
+		switch {
+		case n < 0:
+			return "?"
+		case n < 1<<10:
+			return fmt.Sprintf("%d B", n)
+		case n < 1<<20:
+			return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+		}
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	},
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Duplicate images</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+section { border-top: 1px solid #ccc; padding: 1em 0; }
+figure { display: inline-block; vertical-align: top; margin: 0 1em 1em 0; padding: 0.5em; width: 170px; }
+figure.keep { background: #e4f6e4; }
+img { max-width: 160px; max-height: 160px; }
+figcaption { font-size: 0.8em; word-break: break-all; }
+table { border-collapse: collapse; font-size: 0.9em; }
+td, th { padding: 0.2em 0.8em; text-align: right; }
+</style>
+</head>
+<body>
+<h1>Duplicate images</h1>
+<p>{{len .Groups}} groups. Y, Cb and Cr distances are percentages of the thresholds of func Similar, which are 100%. The proportion threshold is {{.PropThreshold}}.</p>
+{{range $g, $group := .Groups}}<section>
+<h2>Group {{inc $g}}</h2>
+{{range $i, $img := $group.Images}}<figure{{if $img.Keep}} class="keep"{{end}}>
+{{if $img.Thumb}}<img src="{{$img.Thumb}}" alt="">{{end}}
+<figcaption>
+<b>{{inc $i}}</b>{{if $img.Keep}} <b>keep</b>{{end}}<br>
+{{$img.Path}}<br>
+{{$img.Size.X}}x{{$img.Size.Y}}, {{bytes $img.FileSize}}
+</figcaption>
+</figure>
+{{end}}{{if $group.Pairs}}<table>
+<tr><th>Pair</th><th>Y</th><th>Cb</th><th>Cr</th><th>Proportions</th></tr>
+{{range $group.Pairs}}<tr><td>{{.A}}-{{.B}}</td><td>{{printf "%.1f" .Y}}%</td><td>{{printf "%.1f" .Cb}}%</td><td>{{printf "%.1f" .Cr}}%</td><td>{{printf "%.3f" .Prop}}</td></tr>
+{{end}}</table>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,52 @@
+package images4
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	var group []Candidate
+	for _, name := range []string{"small.jpg", "large.jpg", "<missing>.jpg"} {
+		p := path.Join("testdata", "euclidean", name)
+		img := Candidate{Path: p, Icon: EmptyIcon()}
+		if src, err := Open(p); err == nil {
+			img.Icon = Icon(src)
+		}
+		group = append(group, img)
+	}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, [][]Candidate{group}); err != nil {
+		t.Fatal("Error writing report:", err)
+	}
+	html := buf.String()
+	for _, want := range []string{
+		"testdata/euclidean/small.jpg",
+		"&lt;missing&gt;.jpg", // Escaped.
+		"267x200",
+		"533x400, 42.8 KiB",
+		"0x0, ?", // Missing file.
+		`<img src="data:image/jpeg;base64,`,
+		"<td>1-2</td>",
+		fmt.Sprintf("The proportion threshold is %v.", thProp),
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Report does not contain %q.", want)
+		}
+	}
+	if strings.Contains(html, "<td>2-3</td>") {
+		t.Error("Pairs with an empty icon must have no metrics.")
+	}
+	if strings.Count(html, "<img") != 2 {
+		t.Errorf("Expected 2 thumbnails, got %d.", strings.Count(html, "<img"))
+	}
+	// The larger image is the keep candidate.
+	keep := strings.Index(html, `class="keep"`)
+	if keep < 0 || keep < strings.Index(html, "small.jpg") ||
+		keep > strings.Index(html, "large.jpg") {
+		t.Error("Expected large.jpg as the keep candidate.")
+	}
+}
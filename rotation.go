@@ -0,0 +1,179 @@
+package images4
+
+import (
+	"fmt"
+	"image"
+)
+
+// Rotation is an orientation of one image relative to another:
+// a clockwise rotation, optionally after a horizontal mirror.
+type Rotation int
+
+const (
+	Rotation0   Rotation = iota // Same orientation.
+	Rotation90                  // Rotated 90° clockwise.
+	Rotation180                 // Rotated 180°.
+	Rotation270                 // Rotated 270° clockwise (90° counterclockwise).
+	Mirror0                     // Mirrored horizontally.
+	Mirror90                    // Mirrored, then rotated 90° clockwise.
+	Mirror180                   // Mirrored, then rotated 180° (mirrored vertically).
+	Mirror270                   // Mirrored, then rotated 270° clockwise.
+)
+
+// Degrees returns the clockwise rotation angle.
+func (r Rotation) Degrees() int {
+	return 90 * (int(r) % 4)
+}
+
+// Mirrored tells whether the rotation includes a horizontal mirror.
+func (r Rotation) Mirrored() bool {
+	return r >= Mirror0
+}
+
+// Inverse returns the rotation turning the image back.
+// Mirrored rotations are their own inverses.
+func (r Rotation) Inverse() Rotation {
+	if r.Mirrored() {
+		return r
+	}
+	return (4 - r) % 4
+}
+
+func (r Rotation) String() string {
+	if r < Rotation0 || r > Mirror270 {
+		return fmt.Sprintf("Rotation(%d)", int(r))
+	}
+	if r.Mirrored() {
+		return fmt.Sprintf("mirror+%d°", r.Degrees())
+	}
+	return fmt.Sprintf("%d°", r.Degrees())
+}
+
+// Mirror flips an icon horizontally.
+func Mirror(icon IconT) IconT {
+	return turn(icon, Mirror0, nil)
+}
+
+// orientations are pixel permutations of a channel for each
+// Rotation: pixel i of a turned icon is pixel orientations[r][i]
+// of the original.
+var orientations = func() (perms [Mirror270 + 1][numPix]uint8) {
+	for i := range perms[Rotation0] {
+		perms[Rotation0][i] = uint8(i)
+	}
+	for x := 0; x < IconSize; x++ {
+		for y := 0; y < IconSize; y++ {
+			perms[Mirror0][arrIndex(image.Point{x, y}, IconSize, 0)] =
+				uint8(arrIndex(image.Point{IconSize - 1 - x, y}, IconSize, 0))
+		}
+	}
+	// Each following rotation turns the previous one by 90°.
+	for _, r := range []Rotation{Rotation90, Rotation180, Rotation270,
+		Mirror90, Mirror180, Mirror270} {
+		for x := 0; x < IconSize; x++ {
+			for y := 0; y < IconSize; y++ {
+				perms[r][arrIndex(image.Point{x, y}, IconSize, 0)] =
+					perms[r-1][arrIndex(image.Point{y, IconSize - 1 - x}, IconSize, 0)]
+			}
+		}
+	}
+	return perms
+}()
+
+// turn returns an icon turned by rotation r. Pixels are stored in
+// buf when it has room for them. Malformed icons keep their pixels.
+// Metadata is copied.
+func turn(icon IconT, r Rotation, buf []uint16) IconT {
+	turned := icon.ownMeta()
+	if r.Degrees()%180 != 0 {
+		turned.ImgSize.X, turned.ImgSize.Y = icon.ImgSize.Y, icon.ImgSize.X
+	}
+	if len(icon.Pixels) != 3*numPix {
+		return turned
+	}
+	if len(buf) < 3*numPix {
+		buf = make([]uint16, 3*numPix)
+	}
+	turned.Pixels = buf[: 3*numPix : 3*numPix]
+	perm := &orientations[r]
+	for ch := 0; ch < 3*numPix; ch += numPix {
+		for i, j := range perm {
+			turned.Pixels[ch+i] = icon.Pixels[ch+int(j)]
+		}
+	}
+	return turned
+}
+
+// SimilarAnyRotation works like Similar, but also considers
+// images rotated by 90°, 180° and 270°, and mirrored when
+// mirrors is true. It returns rotation r, with which image A
+// turned by r is similar to image B. When several orientations
+// match, the closest one is returned.
+// The verdict does not depend on the order of icons, and
+// SimilarAnyRotation(b, a) returns the inverse rotation.
+// Use func Orient and SimilarOriented for repeated comparisons.
+func SimilarAnyRotation(iconA, iconB IconT, mirrors bool) (Rotation, bool) {
+	return anyRotation(iconA, iconB, mirrors, nil)
+}
+
+// CustomSimilarAnyRotation is like SimilarAnyRotation with
+// thresholds of func CustomSimilar.
+func CustomSimilarAnyRotation(iconA, iconB IconT, coeff CustomCoefficients,
+	mirrors bool) (Rotation, bool) {
+	return anyRotation(iconA, iconB, mirrors, &coeff)
+}
+
+// anyRotation turns the icon first in order of func iconLess
+// and compares it with the other icon. Comparisons in one
+// direction only make results independent of the argument order.
+func anyRotation(iconA, iconB IconT, mirrors bool,
+	coeff *CustomCoefficients) (Rotation, bool) {
+	if iconLess(iconB, iconA) {
+		r, ok := closestRotation(Orient(iconB, mirrors).variants, iconA, coeff)
+		return r.Inverse(), ok
+	}
+	return closestRotation(Orient(iconA, mirrors).variants, iconB, coeff)
+}
+
+// closestRotation finds the orientation of turned icons most
+// similar to the icon, with thresholds of func Similar, or of
+// func CustomSimilar when coeff is not nil.
+func closestRotation(turned []IconT, icon IconT,
+	coeff *CustomCoefficients) (Rotation, bool) {
+
+	best, found, bestDist := Rotation0, false, 0.0
+	for r := range turned {
+		if coeff == nil && !Similar(turned[r], icon) ||
+			coeff != nil && !CustomSimilar(turned[r], icon, *coeff) {
+			continue
+		}
+		dist := relativeDistance(turned[r], icon)
+		if !found || dist < bestDist {
+			best, found, bestDist = Rotation(r), true, dist
+		}
+	}
+	return best, found
+}
+
+// relativeDistance sums metrics of func EucMetric relative to
+// the default thresholds.
+func relativeDistance(iconA, iconB IconT) float64 {
+	m1, m2, m3 := EucMetric(iconA, iconB)
+	return m1/thY + m2/thCbCr + m3/thCbCr
+}
+
+// iconLess orders icons by pixels, then by image size.
+func iconLess(a, b IconT) bool {
+	for i := 0; i < len(a.Pixels) && i < len(b.Pixels); i++ {
+		if a.Pixels[i] != b.Pixels[i] {
+			return a.Pixels[i] < b.Pixels[i]
+		}
+	}
+	if len(a.Pixels) != len(b.Pixels) {
+		return len(a.Pixels) < len(b.Pixels)
+	}
+	if a.ImgSize.X != b.ImgSize.X {
+		return a.ImgSize.X < b.ImgSize.X
+	}
+	return a.ImgSize.Y < b.ImgSize.Y
+}
@@ -0,0 +1,68 @@
+package images4
+
+import (
+	"image"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRotationInverse(t *testing.T) {
+	icon := sizedIcon(IconSize)
+	icon.ImgSize = image.Point{300, 200}
+	for i := range icon.Pixels {
+		icon.Pixels[i] = uint16(i * 97)
+	}
+	for r := Rotation0; r <= Mirror270; r++ {
+		back := turn(turn(icon, r, nil), r.Inverse(), nil)
+		if !reflect.DeepEqual(back, icon) {
+			t.Errorf("Rotation %v turned by %v is not the original icon.",
+				r, r.Inverse())
+		}
+	}
+}
+
+func TestSimilarAnyRotation(t *testing.T) {
+	icons := make(map[int]IconT)
+	for _, deg := range []int{0, 90, 180, 270} {
+		img, err := Open(path.Join("testdata", "rotate", strconv.Itoa(deg)+".jpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		icons[deg] = Icon(img)
+	}
+
+	for a, iconA := range icons {
+		for b, iconB := range icons {
+			want := Rotation(((b - a + 360) % 360) / 90)
+			r, ok := SimilarAnyRotation(iconA, iconB, false)
+			if !ok || r != want {
+				t.Errorf("%d.jpg and %d.jpg: expected %v, got %v, %v.",
+					a, b, want, r, ok)
+			}
+			rBA, okBA := SimilarAnyRotation(iconB, iconA, false)
+			if okBA != ok || rBA != r.Inverse() {
+				t.Errorf("%d.jpg and %d.jpg: expected %v, got %v, %v in reverse.",
+					a, b, r.Inverse(), rBA, okBA)
+			}
+		}
+	}
+
+	// Mirrors are found only when requested.
+	mirrored := Rotate90(Mirror(icons[0]))
+	if _, ok := SimilarAnyRotation(icons[0], mirrored, false); ok {
+		t.Error("Mirrored icon must not be similar without mirrors.")
+	}
+	if r, ok := SimilarAnyRotation(icons[0], mirrored, true); !ok || r != Mirror90 {
+		t.Errorf("Expected %v, got %v, %v.", Mirror90, r, ok)
+	}
+	if r, ok := SimilarAnyRotation(mirrored, icons[0], true); !ok || r != Mirror90 {
+		t.Errorf("Expected %v in reverse, got %v, %v.", Mirror90, r, ok)
+	}
+
+	other, _ := Open(path.Join("testdata", "euclidean", "uniform-green.png"))
+	if _, ok := SimilarAnyRotation(icons[0], Icon(other), true); ok {
+		t.Error("Distinct images must not be similar.")
+	}
+}
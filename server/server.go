@@ -0,0 +1,572 @@
+// Package server provides an HTTP JSON API of package images4,
+// for near-duplicate checks from services in other languages.
+//
+// Endpoints:
+//
+//	POST   /icon                          image → icon
+//	POST   /compare                       two images or icons → verdict
+//	GET    /indexes/{name}                ids of icons in an index
+//	PUT    /indexes/{name}/icons/{id}     image or icon → added icon
+//	DELETE /indexes/{name}/icons/{id}     removes an icon
+//	POST   /indexes/{name}/search         image or icon → matches
+//
+// Images are sent as request bodies of any content type except
+// application/json. Icons are sent as JSON {"icon": "..."}, in the
+// form of images4.IconT.MarshalJSON. Endpoint /compare takes a
+// multipart form with image files "a" and "b", or JSON
+// {"a": "...", "b": "..."} with icons.
+//
+// Comparison and search use the thresholds of func images4.Similar,
+// or of func images4.CustomSimilar when any of query parameters
+// y, cb, cr or prop is set (missing ones are 1). Query parameters
+// rotations=true and mirrors=true of /compare also match rotated
+// and mirrored images. Query parameter color=true of /compare adds
+// the check of func images4.CheckColor, with coefficients colorY,
+// colorCb, colorCr and colorRange (setting any enables the check,
+// missing ones are 1): images of different brightness, tint or
+// contrast are then not similar. Indexes are created, with
+// Options.Index, when their first icon is added. Other requests to
+// unknown indexes return 404.
+//
+// Errors are returned as JSON {"error": "..."}.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vitali-fedulov/images4"
+)
+
+// Options configure a Server.
+type Options struct {
+	// MaxUploadBytes limits the size of request bodies.
+	// 0 means no limit.
+	MaxUploadBytes int64
+	// Limits protect image decoding from decompression bombs.
+	Limits images4.Limits
+	// Index configures indexes created by the server.
+	Index images4.IndexOptions
+}
+
+// DefaultOptions are reasonable limits for a public service. Serve
+// it with an http.Server with read and write timeouts, as the serve
+// subcommand of cmd/images4 does.
+var DefaultOptions = Options{
+	MaxUploadBytes: 32 << 20,
+	Limits: images4.Limits{
+		MaxPixels: 100000000,
+		MaxMemory: 1 << 30,
+	},
+}
+
+// Server is an http.Handler serving the API. Indexes are kept
+// in memory.
+type Server struct {
+	opts    Options
+	mu      sync.Mutex
+	indexes map[string]*images4.Index
+}
+
+// New creates a server with no indexes.
+func New(opts Options) *Server {
+	return &Server{opts: opts, indexes: make(map[string]*images4.Index)}
+}
+
+// httpError is an error with an HTTP status code.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(status int, format string, a ...interface{}) error {
+	return &httpError{status, fmt.Errorf(format, a...)}
+}
+
+// IconResponse is the response of /icon and of adding an icon
+// to an index.
+type IconResponse struct {
+	ID     string        `json:"id,omitempty"`
+	Icon   images4.IconT `json:"icon"`
+	Format string        `json:"format,omitempty"`
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+}
+
+// CompareResponse is the response of /compare.
+type CompareResponse struct {
+	Similar bool `json:"similar"`
+	// Rotation in degrees clockwise turning image a to look like
+	// image b, after a horizontal mirror when Mirrored.
+	Rotation int     `json:"rotation"`
+	Mirrored bool    `json:"mirrored"`
+	Metrics  Metrics `json:"metrics"`
+	// Color is the color check, when requested.
+	Color *ColorCheck `json:"color,omitempty"`
+}
+
+// ColorCheck is the result of images4.CheckColor with the
+// thresholds it applied, which are the default thresholds
+// multiplied by the coefficients of the request. Differences are
+// of channels Y, Cb and Cr.
+type ColorCheck struct {
+	Checked bool       `json:"checked"`
+	Passed  bool       `json:"passed"`
+	Mean    [3]float64 `json:"mean"`
+	Range   [3]float64 `json:"range"`
+	// Thresholds of mean Y, Cb and Cr, and of ranges.
+	ThresholdY     float64 `json:"thresholdY"`
+	ThresholdCb    float64 `json:"thresholdCb"`
+	ThresholdCr    float64 `json:"thresholdCr"`
+	ThresholdRange float64 `json:"thresholdRange"`
+}
+
+// Metrics are values of images4.EucMetric and PropMetric with
+// their default thresholds.
+type Metrics struct {
+	Y          float64 `json:"y"`
+	Cb         float64 `json:"cb"`
+	Cr         float64 `json:"cr"`
+	Prop       float64 `json:"prop"`
+	ThresholdY float64 `json:"thresholdY"`
+	// Threshold of Cb and Cr.
+	ThresholdCbCr float64 `json:"thresholdCbCr"`
+	ThresholdProp float64 `json:"thresholdProp"`
+}
+
+// Match is an icon found by /indexes/{name}/search.
+type Match struct {
+	ID       string  `json:"id"`
+	Rotation int     `json:"rotation"`
+	Mirrored bool    `json:"mirrored"`
+	Distance float64 `json:"distance"`
+}
+
+// SearchResponse is the response of /indexes/{name}/search.
+type SearchResponse struct {
+	Matches []Match `json:"matches"`
+}
+
+// IndexResponse is the response of GET /indexes/{name}.
+type IndexResponse struct {
+	Name string   `json:"name"`
+	IDs  []string `json:"ids"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp interface{}
+		err  error
+	)
+	status := http.StatusOK
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "icon":
+		if err = allow(r, http.MethodPost); err == nil {
+			resp, err = s.icon(r)
+		}
+	case path == "compare":
+		if err = allow(r, http.MethodPost); err == nil {
+			resp, err = s.compare(r)
+		}
+	case len(parts) == 2 && parts[0] == "indexes":
+		if err = allow(r, http.MethodGet); err == nil {
+			resp, err = s.list(parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "indexes" && parts[2] == "search":
+		if err = allow(r, http.MethodPost); err == nil {
+			resp, err = s.search(r, parts[1])
+		}
+	case len(parts) == 4 && parts[0] == "indexes" && parts[2] == "icons":
+		switch r.Method {
+		case http.MethodPut:
+			resp, err = s.add(r, parts[1], parts[3])
+		case http.MethodDelete:
+			var x *images4.Index
+			if x, err = s.lookup(parts[1]); err != nil {
+				break
+			}
+			if x.Remove(parts[3]) {
+				status = http.StatusNoContent
+			} else {
+				err = errorf(http.StatusNotFound, "icon %q not found", parts[3])
+			}
+		default:
+			err = allow(r, http.MethodPut, http.MethodDelete)
+		}
+	default:
+		err = errorf(http.StatusNotFound, "unknown endpoint %q", r.URL.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		var e *httpError
+		if !errors.As(err, &e) {
+			e = &httpError{http.StatusBadRequest, err}
+		}
+		if e.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", e.err.Error())
+			e.err = fmt.Errorf("method %s not allowed", r.Method)
+		}
+		status, resp = e.status, struct {
+			Error string `json:"error"`
+		}{e.Error()}
+	}
+	var buf bytes.Buffer
+	if status != http.StatusNoContent {
+		if err := json.NewEncoder(&buf).Encode(resp); err != nil {
+			status = http.StatusInternalServerError
+			buf.Reset()
+			json.NewEncoder(&buf).Encode(struct {
+				Error string `json:"error"`
+			}{err.Error()})
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// allow checks the request method. Its error holds the allowed
+// methods for the Allow header.
+func allow(r *http.Request, methods ...string) error {
+	for _, m := range methods {
+		if r.Method == m {
+			return nil
+		}
+	}
+	return &httpError{http.StatusMethodNotAllowed,
+		errors.New(strings.Join(methods, ", "))}
+}
+
+func (s *Server) icon(r *http.Request) (interface{}, error) {
+	body, err := s.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.readImage(body)
+}
+
+func (s *Server) compare(r *http.Request) (interface{}, error) {
+	body, err := s.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var a, b images4.IconT
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var req struct{ A, B *images4.IconT }
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		if err := checkIcon(req.A); err != nil {
+			return nil, fmt.Errorf("icon a: %w", err)
+		}
+		if err := checkIcon(req.B); err != nil {
+			return nil, fmt.Errorf("icon b: %w", err)
+		}
+		a, b = *req.A, *req.B
+	case "multipart/form-data":
+		files, err := readParts(body, params["boundary"], "a", "b")
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.readImage(files[0])
+		if err != nil {
+			return nil, fmt.Errorf("image a: %w", err)
+		}
+		a = resp.Icon
+		if resp, err = s.readImage(files[1]); err != nil {
+			return nil, fmt.Errorf("image b: %w", err)
+		}
+		b = resp.Icon
+	default:
+		return nil, errorf(http.StatusUnsupportedMediaType,
+			"expected application/json or multipart/form-data")
+	}
+
+	if a.Normalization != b.Normalization || a.Linear != b.Linear {
+		return nil, errors.New("icons of different normalization or averaging are not comparable")
+	}
+
+	coeff, custom, err := coefficients(r)
+	if err != nil {
+		return nil, err
+	}
+	colorCoeff, color, err := colorCoefficients(r)
+	if err != nil {
+		return nil, err
+	}
+	rotations := r.URL.Query().Get("rotations") == "true"
+	mirrors := r.URL.Query().Get("mirrors") == "true"
+
+	var resp CompareResponse
+	var rot images4.Rotation
+	switch {
+	case rotations && custom:
+		rot, resp.Similar = images4.CustomSimilarAnyRotation(a, b, coeff, mirrors)
+	case rotations:
+		rot, resp.Similar = images4.SimilarAnyRotation(a, b, mirrors)
+	case custom:
+		resp.Similar = images4.CustomSimilar(a, b, coeff)
+	default:
+		resp.Similar = images4.Similar(a, b)
+	}
+	resp.Rotation, resp.Mirrored = rot.Degrees(), rot.Mirrored()
+
+	turned := images4.Orient(a, rot.Mirrored()).Turned(rot)
+	m := &resp.Metrics
+	m.Y, m.Cb, m.Cr = images4.EucMetric(turned, b)
+	m.Prop = images4.PropMetric(turned, b)
+	m.ThresholdY, m.ThresholdCbCr, m.ThresholdProp = images4.Thresholds()
+
+	if color {
+		check := images4.CheckColor(a, b, colorCoeff)
+		c := &ColorCheck{Checked: check.Checked, Passed: check.Passed,
+			Mean: check.Mean, Range: check.Range}
+		y, cbcr, rng := images4.ColorThresholds()
+		c.ThresholdY, c.ThresholdCb = y*colorCoeff.Y, cbcr*colorCoeff.Cb
+		c.ThresholdCr, c.ThresholdRange = cbcr*colorCoeff.Cr, rng*colorCoeff.Range
+		resp.Color = c
+		resp.Similar = resp.Similar && check.Passed
+	}
+	return resp, nil
+}
+
+func (s *Server) add(r *http.Request, name, id string) (interface{}, error) {
+	resp, err := s.readIconOrImage(r)
+	if err != nil {
+		return nil, err
+	}
+	s.index(name).Add(id, resp.Icon)
+	resp.ID = id
+	return resp, nil
+}
+
+func (s *Server) search(r *http.Request, name string) (interface{}, error) {
+	query, err := s.readIconOrImage(r)
+	if err != nil {
+		return nil, err
+	}
+	coeff, custom, err := coefficients(r)
+	if err != nil {
+		return nil, err
+	}
+	x, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	var found []images4.Match
+	if custom {
+		found = x.CustomSearch(query.Icon, coeff)
+	} else {
+		found = x.Search(query.Icon)
+	}
+	resp := SearchResponse{Matches: []Match{}}
+	for _, m := range found {
+		resp.Matches = append(resp.Matches, Match{
+			m.ID, m.Rotation.Degrees(), m.Rotation.Mirrored(), m.Distance})
+	}
+	return resp, nil
+}
+
+func (s *Server) list(name string) (interface{}, error) {
+	x, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return IndexResponse{Name: name, IDs: x.IDs()}, nil
+}
+
+// lookup returns an existing index, or a 404 error.
+func (s *Server) lookup(name string) (*images4.Index, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.indexes[name]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "index %q not found", name)
+	}
+	return x, nil
+}
+
+// index returns the named index, creating it when needed.
+func (s *Server) index(name string) *images4.Index {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.indexes[name]
+	if !ok {
+		x = images4.NewIndex(s.opts.Index)
+		s.indexes[name] = x
+	}
+	return x
+}
+
+// readBody reads the request body up to MaxUploadBytes.
+func (s *Server) readBody(r *http.Request) ([]byte, error) {
+	body := io.Reader(r.Body)
+	if s.opts.MaxUploadBytes > 0 {
+		body = io.LimitReader(body, s.opts.MaxUploadBytes+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.MaxUploadBytes > 0 && int64(len(data)) > s.opts.MaxUploadBytes {
+		return nil, errorf(http.StatusRequestEntityTooLarge,
+			"request body exceeds %d bytes", s.opts.MaxUploadBytes)
+	}
+	return data, nil
+}
+
+// readImage decodes an image and makes its icon.
+func (s *Server) readImage(data []byte) (*IconResponse, error) {
+	img, format, err := images4.OpenReaderLimited(bytes.NewReader(data), s.opts.Limits)
+	if err != nil {
+		var limit *images4.LimitError
+		switch {
+		case errors.As(err, &limit):
+			return nil, &httpError{http.StatusRequestEntityTooLarge, err}
+		case errors.Is(err, images4.ErrUnknownFormat):
+			return nil, &httpError{http.StatusUnsupportedMediaType, err}
+		}
+		return nil, err
+	}
+	icon := images4.Icon(img)
+	icon.Meta.Format = format
+	size := img.Bounds().Size()
+	return &IconResponse{Icon: icon, Format: format,
+		Width: size.X, Height: size.Y}, nil
+}
+
+// readIconOrImage reads JSON {"icon": "..."} or an image.
+func (s *Server) readIconOrImage(r *http.Request) (*IconResponse, error) {
+	body, err := s.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return s.readImage(body)
+	}
+	var req struct{ Icon *images4.IconT }
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if err := checkIcon(req.Icon); err != nil {
+		return nil, err
+	}
+	return &IconResponse{Icon: *req.Icon,
+		Width: req.Icon.ImgSize.X, Height: req.Icon.ImgSize.Y}, nil
+}
+
+// readParts returns the contents of the named files of a
+// multipart form.
+func readParts(body []byte, boundary string, names ...string) ([][]byte, error) {
+	files := make([][]byte, len(names))
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range names {
+			if part.FormName() == name && files[i] == nil {
+				if files[i], err = ioutil.ReadAll(part); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for i, name := range names {
+		if files[i] == nil {
+			return nil, fmt.Errorf("file %q is required", name)
+		}
+	}
+	return files, nil
+}
+
+// checkIcon rejects malformed icons of JSON requests.
+func checkIcon(icon *images4.IconT) error {
+	if icon == nil {
+		return errors.New("icon is required")
+	}
+	if len(icon.Pixels) != 3*images4.IconSize*images4.IconSize {
+		return fmt.Errorf("icon has %d values instead of %d",
+			len(icon.Pixels), 3*images4.IconSize*images4.IconSize)
+	}
+	return nil
+}
+
+// coefficients reads query parameters y, cb, cr and prop.
+// custom is false when none is set.
+func coefficients(r *http.Request) (
+	coeff images4.CustomCoefficients, custom bool, err error) {
+	coeff = images4.CustomCoefficients{Y: 1, Cb: 1, Cr: 1, Prop: 1}
+	q := r.URL.Query()
+	for _, p := range []struct {
+		name string
+		v    *float64
+	}{{"y", &coeff.Y}, {"cb", &coeff.Cb}, {"cr", &coeff.Cr}, {"prop", &coeff.Prop}} {
+		s := q.Get(p.name)
+		if s == "" {
+			continue
+		}
+		if *p.v, err = parseCoefficient(s); err != nil {
+			return coeff, false, fmt.Errorf("invalid coefficient %s=%q", p.name, s)
+		}
+		custom = true
+	}
+	return coeff, custom, nil
+}
+
+// colorCoefficients parses coefficients of images4.CheckColor, and
+// tells whether the check is requested.
+func colorCoefficients(r *http.Request) (
+	coeff images4.ColorCoefficients, color bool, err error) {
+	coeff = images4.ColorCoefficients{Y: 1, Cb: 1, Cr: 1, Range: 1}
+	q := r.URL.Query()
+	color = q.Get("color") == "true"
+	for _, p := range []struct {
+		name string
+		v    *float64
+	}{{"colorY", &coeff.Y}, {"colorCb", &coeff.Cb}, {"colorCr", &coeff.Cr},
+		{"colorRange", &coeff.Range}} {
+		s := q.Get(p.name)
+		if s == "" {
+			continue
+		}
+		if *p.v, err = parseCoefficient(s); err != nil {
+			return coeff, false, fmt.Errorf("invalid coefficient %s=%q", p.name, s)
+		}
+		color = true
+	}
+	return coeff, color, nil
+}
+
+// parseCoefficient parses a finite non-negative coefficient.
+func parseCoefficient(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(v >= 0) || math.IsInf(v, 1) {
+		return 0, errors.New("not a finite non-negative number")
+	}
+	return v, nil
+}
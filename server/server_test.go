@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitali-fedulov/images4"
+)
+
+func readFile(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// do sends a request and decodes the JSON response into resp.
+func do(t *testing.T, srv *httptest.Server, method, path, contentType string,
+	body []byte, resp interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	r, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if resp != nil && r.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return r.StatusCode
+}
+
+func TestIcon(t *testing.T) {
+	srv := httptest.NewServer(New(DefaultOptions))
+	defer srv.Close()
+
+	data := readFile(t, "rotate/0.jpg")
+	var resp IconResponse
+	if status := do(t, srv, "POST", "/icon", "image/jpeg", data, &resp); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d.", status)
+	}
+	img, _, err := images4.OpenBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !images4.Similar(resp.Icon, images4.Icon(img)) || resp.Format != "jpeg" ||
+		resp.Width != img.Bounds().Dx() || resp.Height != img.Bounds().Dy() {
+		t.Errorf("Wrong response %+v.", resp)
+	}
+
+	var e struct{ Error string }
+	if status := do(t, srv, "POST", "/icon", "", []byte("not an image"), &e); status != http.StatusUnsupportedMediaType || e.Error == "" {
+		t.Errorf("Expected status 415 with an error, got %d, %q.", status, e.Error)
+	}
+	if status := do(t, srv, "GET", "/icon", "", nil, &e); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d.", status)
+	}
+	if status := do(t, srv, "GET", "/unknown", "", nil, &e); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d.", status)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	data := readFile(t, "rotate/0.jpg")
+	for _, opts := range []Options{
+		{MaxUploadBytes: int64(len(data) - 1)},
+		{Limits: images4.Limits{MaxPixels: 100}},
+	} {
+		srv := httptest.NewServer(New(opts))
+		var e struct{ Error string }
+		if status := do(t, srv, "POST", "/icon", "image/jpeg", data, &e); status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%+v: expected status 413, got %d, %q.", opts, status, e.Error)
+		}
+		srv.Close()
+	}
+}
+
+func TestCompare(t *testing.T) {
+	srv := httptest.NewServer(New(DefaultOptions))
+	defer srv.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, file := range map[string]string{"a": "rotate/0.jpg", "b": "rotate/90.jpg"} {
+		w, err := mw.CreateFormFile(name, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(readFile(t, file))
+	}
+	mw.Close()
+
+	var resp CompareResponse
+	do(t, srv, "POST", "/compare", mw.FormDataContentType(), body.Bytes(), &resp)
+	if resp.Similar || resp.Metrics.ThresholdY == 0 {
+		t.Errorf("Images must not be similar without rotations, got %+v.", resp)
+	}
+	do(t, srv, "POST", "/compare?rotations=true", mw.FormDataContentType(), body.Bytes(), &resp)
+	if !resp.Similar || resp.Rotation != 90 || resp.Mirrored ||
+		resp.Metrics.Y >= resp.Metrics.ThresholdY {
+		t.Errorf("Expected similar images rotated 90°, got %+v.", resp)
+	}
+
+	// Icons as JSON, with custom coefficients.
+	var icons [2]IconResponse
+	do(t, srv, "POST", "/icon", "", readFile(t, "custom/1.jpg"), &icons[0])
+	do(t, srv, "POST", "/icon", "", readFile(t, "custom/2.jpg"), &icons[1])
+	req, _ := json.Marshal(map[string]images4.IconT{"a": icons[0].Icon, "b": icons[1].Icon})
+	do(t, srv, "POST", "/compare", "application/json", req, &resp)
+	if !resp.Similar {
+		t.Error("custom/1.jpg and custom/2.jpg must be similar.")
+	}
+	do(t, srv, "POST", "/compare?y=0.01&cb=0.01", "application/json", req, &resp)
+	if resp.Similar {
+		t.Error("Images must not be similar with small coefficients.")
+	}
+
+	// Color check. Mean luma of the images differs by about 9.
+	resp = CompareResponse{}
+	do(t, srv, "POST", "/compare?color=true", "application/json", req, &resp)
+	if !resp.Similar || resp.Color == nil || !resp.Color.Checked || !resp.Color.Passed ||
+		resp.Color.ThresholdY == 0 {
+		t.Errorf("Expected a passed color check, got %+v, %+v.", resp, resp.Color)
+	}
+	resp = CompareResponse{}
+	do(t, srv, "POST", "/compare?colorY=0.5", "application/json", req, &resp)
+	if resp.Similar || resp.Color == nil || resp.Color.Passed ||
+		resp.Color.Mean[0] <= resp.Color.ThresholdY {
+		t.Errorf("Expected a failed color check, got %+v, %+v.", resp, resp.Color)
+	}
+	if y, cbcr, _ := images4.ColorThresholds(); resp.Color != nil &&
+		(resp.Color.ThresholdY != y*0.5 || resp.Color.ThresholdCb != cbcr) {
+		t.Errorf("Expected thresholds scaled by coefficients, got %+v.", resp.Color)
+	}
+	resp = CompareResponse{}
+	do(t, srv, "POST", "/compare", "application/json", req, &resp)
+	if resp.Color != nil {
+		t.Errorf("Expected no color check, got %+v.", resp.Color)
+	}
+
+	var e struct{ Error string }
+	for _, bad := range []string{`{"a": "AQ"}`, `{"a": [1, 2], "b": [1, 2]}`, `{`} {
+		if status := do(t, srv, "POST", "/compare", "application/json", []byte(bad), &e); status != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d.", bad, status)
+		}
+	}
+	// Icons of different normalization have infinite distances.
+	nn := icons[0].Icon
+	nn.Normalization = images4.NormalizeNone
+	mixed, _ := json.Marshal(map[string]images4.IconT{"a": nn, "b": icons[1].Icon})
+	e.Error = ""
+	if status := do(t, srv, "POST", "/compare", "application/json", mixed, &e); status != http.StatusBadRequest || e.Error == "" {
+		t.Errorf("Expected status 400 with an error for icons of different normalization, got %d, %q.", status, e.Error)
+	}
+	for _, query := range []string{"y=x", "cb=NaN", "prop=Inf", "colorRange=-1", "colorY=nan"} {
+		if status := do(t, srv, "POST", "/compare?"+query, "application/json", req, &e); status != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 for a wrong coefficient, got %d.", query, status)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	opts := DefaultOptions
+	opts.Index.Rotations = true
+	srv := httptest.NewServer(New(opts))
+	defer srv.Close()
+
+	for _, name := range []string{"0.jpg", "90.jpg", "180.jpg"} {
+		var resp IconResponse
+		status := do(t, srv, "PUT", "/indexes/photos/icons/"+name, "image/jpeg",
+			readFile(t, "rotate/"+name), &resp)
+		if status != http.StatusOK || resp.ID != name {
+			t.Fatalf("Expected status 200 with id %s, got %d, %+v.", name, status, resp)
+		}
+	}
+	// An icon instead of an image.
+	var green IconResponse
+	do(t, srv, "POST", "/icon", "", readFile(t, "euclidean/uniform-green.png"), &green)
+	req, _ := json.Marshal(map[string]images4.IconT{"icon": green.Icon})
+	do(t, srv, "PUT", "/indexes/photos/icons/green", "application/json", req, nil)
+
+	var list IndexResponse
+	do(t, srv, "GET", "/indexes/photos", "", nil, &list)
+	if len(list.IDs) != 4 {
+		t.Errorf("Expected 4 icons, got %v.", list.IDs)
+	}
+
+	var found SearchResponse
+	do(t, srv, "POST", "/indexes/photos/search", "image/jpeg", readFile(t, "rotate/270.jpg"), &found)
+	if len(found.Matches) != 3 {
+		t.Fatalf("Expected 3 matches, got %+v.", found)
+	}
+	rotations := map[string]int{"0.jpg": 90, "90.jpg": 180, "180.jpg": 270}
+	for _, m := range found.Matches {
+		if rotations[m.ID] != m.Rotation {
+			t.Errorf("Expected rotation %d, got %+v.", rotations[m.ID], m)
+		}
+	}
+
+	if status := do(t, srv, "DELETE", "/indexes/photos/icons/green", "", nil, nil); status != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d.", status)
+	}
+	if status := do(t, srv, "DELETE", "/indexes/photos/icons/green", "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d.", status)
+	}
+	// Unknown indexes are not created by reads.
+	for _, r := range []struct{ method, path string }{
+		{"GET", "/indexes/other"},
+		{"DELETE", "/indexes/other/icons/green"},
+		{"POST", "/indexes/other/search"},
+	} {
+		if status := do(t, srv, r.method, r.path, "application/json", req, nil); status != http.StatusNotFound {
+			t.Errorf("%s %s: expected status 404, got %d.", r.method, r.path, status)
+		}
+	}
+	do(t, srv, "DELETE", "/indexes/photos/icons/0.jpg", "", nil, nil)
+	do(t, srv, "DELETE", "/indexes/photos/icons/90.jpg", "", nil, nil)
+	do(t, srv, "DELETE", "/indexes/photos/icons/180.jpg", "", nil, nil)
+	do(t, srv, "POST", "/indexes/photos/search", "application/json", req, &found)
+	if found.Matches == nil || len(found.Matches) != 0 {
+		t.Errorf("Expected no matches in an empty index, got %+v.", found)
+	}
+}
@@ -1,6 +1,9 @@
 package images4
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 // Similar returns similarity verdict based on Euclidean
 // and proportion similarity.
@@ -24,32 +27,24 @@ func propSimilar(iconA, iconB IconT) bool {
 
 // PropMetric gives image proportion similarity metric for image A
 // and B. The smaller the metric the more similar are images by their
-// x-y size.
+// x-y size. The metric is symmetric. Icons with an empty image size
+// give 1, unless their sizes are equal.
 func PropMetric(iconA, iconB IconT) (m float64) {
 
-	// Filtering is based on rescaling a narrower side of images to 1,
-	// then cutting off at threshold of a longer image vs shorter image.
-	xA, yA := float64(iconA.ImgSize.X), float64(iconA.ImgSize.Y)
-	xB, yB := float64(iconB.ImgSize.X), float64(iconB.ImgSize.Y)
-
-	if xA <= yA { // x to 1.
-		yA = yA / xA
-		yB = yB / xB
-		if yA > yB {
-			m = (yA - yB) / yA
-		} else {
-			m = (yB - yA) / yB
-		}
-	} else { // y to 1.
-		xA = xA / yA
-		xB = xB / yB
-		if xA > xB {
-			m = (xA - xB) / xA
-		} else {
-			m = (xB - xA) / xB
+	xA, yA := iconA.ImgSize.X, iconA.ImgSize.Y
+	xB, yB := iconB.ImgSize.X, iconB.ImgSize.Y
+	if xA <= 0 || yA <= 0 || xB <= 0 || yB <= 0 {
+		if iconA.ImgSize == iconB.ImgSize {
+			return 0
 		}
+		return 1
 	}
-	return m
+
+	// Relative difference of aspect ratios y/x. Ratios x/y give the
+	// same value, but one form keeps m(A, B) == m(B, A) exact.
+	a := float64(yA) / float64(xA)
+	b := float64(yB) / float64(xB)
+	return math.Abs(a-b) / math.Max(a, b)
 }
 
 // eucSimilar gives a similarity verdict for image A and B based
@@ -70,26 +65,37 @@ func eucSimilar(iconA, iconB IconT) bool {
 // These are 3 metrics corresponding to each color channel.
 // Distances are squared, not to waste CPU on square root calculations.
 // Note: color channels of icons are YCbCr (not RGB).
+// Malformed icons, with Pixels of a wrong length, and icons of
+// different normalization modes or of linear and non-linear
+// averaging are at infinite distance.
 func EucMetric(iconA, iconB IconT) (m1, m2, m3 float64) {
 
-	var cA, cB uint16
-	for i := 0; i < numPix; i++ {
-		// Channel 1.
-		cA = iconA.Pixels[i]
-		cB = iconB.Pixels[i]
-		m1 += ((float64(cA) - float64(cB)) * one255th2 * (float64(cA) - float64(cB)))
-		// Channel 2.
-		cA = iconA.Pixels[i+numPix]
-		cB = iconB.Pixels[i+numPix]
-		m2 += ((float64(cA) - float64(cB)) * one255th2 * (float64(cA) - float64(cB)))
-		// Channel 3.
-		cA = iconA.Pixels[i+2*numPix]
-		cB = iconB.Pixels[i+2*numPix]
-		m3 += ((float64(cA) - float64(cB)) * one255th2 * (float64(cA) - float64(cB)))
+	if len(iconA.Pixels) != 3*numPix || len(iconB.Pixels) != 3*numPix ||
+		iconA.Normalization != iconB.Normalization || iconA.Linear != iconB.Linear {
+		inf := math.Inf(1)
+		return inf, inf, inf
+	}
 
+	// Sums of squared differences are exact in integers.
+	var s1, s2, s3, d int64
+	a, b := iconA.Pixels, iconB.Pixels
+	for i := 0; i < numPix; i++ {
+		d = int64(a[i]) - int64(b[i]) // Channel 1.
+		s1 += d * d
+		d = int64(a[i+numPix]) - int64(b[i+numPix]) // Channel 2.
+		s2 += d * d
+		d = int64(a[i+2*numPix]) - int64(b[i+2*numPix]) // Channel 3.
+		s3 += d * d
 	}
 
-	return m1, m2, m3
+	return float64(s1) * one255th2, float64(s2) * one255th2, float64(s3) * one255th2
+}
+
+// Thresholds returns the default thresholds of func Similar:
+// y for metric m1 and cbcr for m2 and m3 of func EucMetric,
+// and prop for func PropMetric.
+func Thresholds() (y, cbcr, prop float64) {
+	return thY, thCbCr, thProp
 }
 
 // Print default thresholds for func Similar.
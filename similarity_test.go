@@ -1,7 +1,9 @@
 package images4
 
 import (
+	"image"
 	"path"
+	"reflect"
 	"testing"
 )
 
@@ -113,3 +115,57 @@ func TestSimilar90270(t *testing.T) {
 		t.Errorf("90.jpg must be NOT similar to 270.jpg")
 	}
 }
+
+// fuzzIcon builds an icon of arbitrary, also malformed, pixels.
+func fuzzIcon(data []byte, x, y int16) IconT {
+	icon := IconT{ImgSize: image.Point{int(x), int(y)}}
+	for i := 0; i+1 < len(data); i += 2 {
+		icon.Pixels = append(icon.Pixels, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+	return icon
+}
+
+func FuzzMetrics(f *testing.F) {
+	full := make([]byte, 6*numPix)
+	for i := range full {
+		full[i] = byte(i * 7)
+	}
+	f.Add(full, full[1:], int16(100), int16(130), int16(130), int16(100))
+	f.Add(full, full, int16(0), int16(0), int16(10), int16(10))
+	f.Add([]byte{1, 2, 3}, full, int16(-1), int16(5), int16(5), int16(5))
+	f.Fuzz(func(t *testing.T, dataA, dataB []byte, xA, yA, xB, yB int16) {
+		a, b := fuzzIcon(dataA, xA, yA), fuzzIcon(dataB, xB, yB)
+
+		a1, a2, a3 := EucMetric(a, b)
+		b1, b2, b3 := EucMetric(b, a)
+		if a1 != b1 || a2 != b2 || a3 != b3 {
+			t.Errorf("EucMetric is not symmetric: %v %v %v vs %v %v %v.",
+				a1, a2, a3, b1, b2, b3)
+		}
+		if pa, pb := PropMetric(a, b), PropMetric(b, a); pa != pb || pa < 0 || pa > 1 {
+			t.Errorf("PropMetric %v, %v is not symmetric or not in [0, 1].", pa, pb)
+		}
+		if Similar(a, b) != Similar(b, a) {
+			t.Error("Similar is not symmetric.")
+		}
+		coeff := CustomCoefficients{1.5, 1.5, 1.5, 1.5}
+		if CustomSimilar(a, b, coeff) != CustomSimilar(b, a, coeff) {
+			t.Error("CustomSimilar is not symmetric.")
+		}
+		rAB, okAB := SimilarAnyRotation(a, b, true)
+		rBA, okBA := SimilarAnyRotation(b, a, true)
+		if okAB != okBA || rAB != rBA.Inverse() {
+			t.Errorf("SimilarAnyRotation is not symmetric: %v, %v vs %v, %v.",
+				rAB, okAB, rBA, okBA)
+		}
+
+		// Four rotations give the original icon.
+		r := a
+		for i := 0; i < 4; i++ {
+			r = Rotate90(r)
+		}
+		if !reflect.DeepEqual(r, a) {
+			t.Error("Four rotations differ from the original icon.")
+		}
+	})
+}
@@ -0,0 +1,598 @@
+package images4
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// Decoder of baseline TIFF images: bilevel, grayscale, paletted
+// and RGB(A) images with 1 to 16 bits per sample, in strips or
+// tiles, uncompressed or compressed with PackBits, LZW or Deflate,
+// with optional horizontal differencing predictor. Only the first
+// image of a multi-page file is decoded. It is registered with
+// the image package, so that func Open and image.Decode recognize
+// TIFF files.
+
+func init() {
+	image.RegisterFormat("tiff", "II\x2A\x00", decodeTIFF, decodeTIFFConfig)
+	image.RegisterFormat("tiff", "MM\x00\x2A", decodeTIFF, decodeTIFFConfig)
+}
+
+var (
+	errTIFFFormat      = errors.New("images4: tiff: invalid format")
+	errTIFFUnsupported = errors.New("images4: tiff: unsupported format")
+)
+
+// TIFF tags.
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanarConfig    = 284
+	tiffPredictor       = 317
+	tiffColorMap        = 320
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffExtraSamples    = 338
+)
+
+// Compression, photometric interpretation and extra samples values.
+const (
+	tiffNone         = 1
+	tiffLZW          = 5
+	tiffDeflate      = 8
+	tiffDeflateOld   = 32946
+	tiffPackBits     = 32773
+	tiffWhiteIsZero  = 0
+	tiffBlackIsZero  = 1
+	tiffRGB          = 2
+	tiffPaletted     = 3
+	tiffAssocAlpha   = 1
+	tiffUnassocAlpha = 2
+)
+
+type tiffDecoder struct {
+	data []byte
+	bo   binary.ByteOrder
+	tags map[uint16][]uint
+
+	width, height  int
+	bps, spp       int // Bits per sample, samples per pixel.
+	photometric    uint
+	compression    uint
+	predictor      uint
+	alpha          uint // tiffAssocAlpha, tiffUnassocAlpha or 0.
+	palette        color.Palette
+	offsets        []uint
+	counts         []uint
+	blockW, blockH int // Strip or tile size.
+}
+
+// newTIFFDecoder parses the first IFD of a TIFF file.
+func newTIFFDecoder(r io.Reader) (*tiffDecoder, error) {
+	// TIFF needs random access, so the whole file is read.
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	d := &tiffDecoder{data: data, tags: map[uint16][]uint{}}
+	switch string(data[:4]) {
+	case "II\x2A\x00":
+		d.bo = binary.LittleEndian
+	case "MM\x00\x2A":
+		d.bo = binary.BigEndian
+	default:
+		return nil, errTIFFFormat
+	}
+	if err := d.readIFD(uint(d.bo.Uint32(data[4:]))); err != nil {
+		return nil, err
+	}
+	if err := d.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// readIFD reads integer valued entries of an image file directory.
+func (d *tiffDecoder) readIFD(offset uint) error {
+	if offset+2 > uint(len(d.data)) {
+		return errTIFFFormat
+	}
+	n := uint(d.bo.Uint16(d.data[offset:]))
+	if offset+2+12*n > uint(len(d.data)) {
+		return errTIFFFormat
+	}
+	for i := uint(0); i < n; i++ {
+		entry := d.data[offset+2+12*i:]
+		tag := d.bo.Uint16(entry)
+		count := uint(d.bo.Uint32(entry[4:]))
+		var size uint
+		switch d.bo.Uint16(entry[2:]) {
+		case 1, 6, 7: // BYTE, SBYTE, UNDEFINED.
+			size = 1
+		case 3, 8: // SHORT, SSHORT.
+			size = 2
+		case 4, 9: // LONG, SLONG.
+			size = 4
+		default: // Not needed by the decoder.
+			continue
+		}
+		if count > uint(len(d.data))/size {
+			return errTIFFFormat
+		}
+		raw := entry[8:12]
+		if count*size > 4 {
+			valOffset := uint(d.bo.Uint32(entry[8:]))
+			if valOffset+count*size > uint(len(d.data)) {
+				return errTIFFFormat
+			}
+			raw = d.data[valOffset : valOffset+count*size]
+		}
+		values := make([]uint, count)
+		for k := range values {
+			switch size {
+			case 1:
+				values[k] = uint(raw[k])
+			case 2:
+				values[k] = uint(d.bo.Uint16(raw[2*k:]))
+			case 4:
+				values[k] = uint(d.bo.Uint32(raw[4*k:]))
+			}
+		}
+		d.tags[tag] = values
+	}
+	return nil
+}
+
+// tag returns the first value of a tag, or def when the tag
+// is missing.
+func (d *tiffDecoder) tag(tag uint16, def uint) uint {
+	if v := d.tags[tag]; len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+func (d *tiffDecoder) parse() error {
+	width := d.tag(tiffImageWidth, 0)
+	height := d.tag(tiffImageLength, 0)
+	if width == 0 || height == 0 ||
+		width > maxDecodePixels || height > maxDecodePixels/width {
+		return errTIFFFormat
+	}
+	d.width, d.height = int(width), int(height)
+
+	d.spp = int(d.tag(tiffSamplesPerPixel, 1))
+	d.bps = int(d.tag(tiffBitsPerSample, 1))
+	for _, b := range d.tags[tiffBitsPerSample] {
+		if int(b) != d.bps { // Mixed sample sizes.
+			return errTIFFUnsupported
+		}
+	}
+	d.photometric = d.tag(tiffPhotometric, tiffBlackIsZero)
+	d.compression = d.tag(tiffCompression, tiffNone)
+	d.predictor = d.tag(tiffPredictor, 1)
+	if d.tag(tiffPlanarConfig, 1) != 1 || d.predictor > 2 {
+		return errTIFFUnsupported
+	}
+	switch d.compression {
+	case tiffNone, tiffLZW, tiffDeflate, tiffDeflateOld, tiffPackBits:
+	default:
+		return errTIFFUnsupported
+	}
+
+	// Color samples, not counting extra ones.
+	colors := 1
+	switch d.photometric {
+	case tiffWhiteIsZero, tiffBlackIsZero:
+		switch d.bps {
+		case 1, 2, 4, 8, 16:
+		default:
+			return errTIFFUnsupported
+		}
+	case tiffPaletted:
+		switch d.bps {
+		case 1, 2, 4, 8:
+		default:
+			return errTIFFUnsupported
+		}
+		cmap := d.tags[tiffColorMap]
+		n := 1 << uint(d.bps)
+		if len(cmap) != 3*n {
+			return errTIFFFormat
+		}
+		d.palette = make(color.Palette, n)
+		for i := range d.palette {
+			d.palette[i] = color.RGBA64{
+				uint16(cmap[i]), uint16(cmap[i+n]), uint16(cmap[i+2*n]), 0xffff}
+		}
+	case tiffRGB:
+		if d.bps != 8 && d.bps != 16 {
+			return errTIFFUnsupported
+		}
+		colors = 3
+	default:
+		return errTIFFUnsupported
+	}
+	if d.spp < colors || d.spp > colors+1 && d.photometric != tiffRGB {
+		return errTIFFUnsupported
+	}
+	if d.spp > colors+8 {
+		return errTIFFFormat
+	}
+	if d.spp > colors && d.photometric != tiffPaletted {
+		if a := d.tag(tiffExtraSamples, 0); a == tiffAssocAlpha ||
+			a == tiffUnassocAlpha {
+			d.alpha = a
+		}
+	}
+
+	if _, ok := d.tags[tiffTileWidth]; ok {
+		d.blockW = int(d.tag(tiffTileWidth, 0))
+		d.blockH = int(d.tag(tiffTileLength, 0))
+		d.offsets = d.tags[tiffTileOffsets]
+		d.counts = d.tags[tiffTileByteCounts]
+		if d.blockW <= 0 || d.blockH <= 0 ||
+			d.blockW > maxDecodePixels/d.blockH {
+			return errTIFFFormat
+		}
+	} else {
+		d.blockW = d.width
+		d.blockH = int(d.tag(tiffRowsPerStrip, uint(d.height)))
+		if d.blockH <= 0 || d.blockH > d.height {
+			d.blockH = d.height
+		}
+		d.offsets = d.tags[tiffStripOffsets]
+		d.counts = d.tags[tiffStripByteCounts]
+		if d.counts == nil && d.compression == tiffNone &&
+			len(d.offsets) == 1 {
+			// Some writers omit byte counts of single strips.
+			d.counts = []uint{uint(d.blockH * d.rowLen())}
+		}
+	}
+	blocks := ((d.width + d.blockW - 1) / d.blockW) *
+		((d.height + d.blockH - 1) / d.blockH)
+	if len(d.offsets) < blocks || len(d.counts) < blocks {
+		return errTIFFFormat
+	}
+	return nil
+}
+
+// rowLen returns the number of bytes in a row of a block.
+func (d *tiffDecoder) rowLen() int {
+	return (d.blockW*d.spp*d.bps + 7) / 8
+}
+
+func (d *tiffDecoder) colorModel() color.Model {
+	switch {
+	case d.photometric == tiffPaletted:
+		return d.palette
+	case d.photometric != tiffRGB && d.alpha == 0 && d.bps == 16:
+		return color.Gray16Model
+	case d.photometric != tiffRGB && d.alpha == 0:
+		return color.GrayModel
+	case d.bps == 16 && d.alpha == tiffUnassocAlpha:
+		return color.NRGBA64Model
+	case d.bps == 16:
+		return color.RGBA64Model
+	case d.alpha == tiffUnassocAlpha:
+		return color.NRGBAModel
+	}
+	return color.RGBAModel
+}
+
+func decodeTIFFConfig(r io.Reader) (image.Config, error) {
+	d, err := newTIFFDecoder(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: d.colorModel(), Width: d.width, Height: d.height}, nil
+}
+
+func decodeTIFF(r io.Reader) (image.Image, error) {
+	d, err := newTIFFDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, d.width, d.height)
+	var img image.Image
+	switch m := d.colorModel(); {
+	case d.photometric == tiffPaletted:
+		img = image.NewPaletted(rect, d.palette)
+	case m == color.GrayModel:
+		img = image.NewGray(rect)
+	case m == color.Gray16Model:
+		img = image.NewGray16(rect)
+	case m == color.NRGBAModel:
+		img = image.NewNRGBA(rect)
+	case m == color.NRGBA64Model:
+		img = image.NewNRGBA64(rect)
+	case m == color.RGBAModel:
+		img = image.NewRGBA(rect)
+	default:
+		img = image.NewRGBA64(rect)
+	}
+
+	rowLen := d.rowLen()
+	blockLen := rowLen * d.blockH
+	blocksAcross := (d.width + d.blockW - 1) / d.blockW
+	buf := make([]byte, blockLen)
+	for i := 0; i < len(d.offsets) && i < len(d.counts); i++ {
+		x0 := (i % blocksAcross) * d.blockW
+		y0 := (i / blocksAcross) * d.blockH
+		if y0 >= d.height {
+			break
+		}
+		offset, count := d.offsets[i], d.counts[i]
+		if offset > uint(len(d.data)) {
+			return nil, errTIFFFormat
+		}
+		if count > uint(len(d.data))-offset {
+			count = uint(len(d.data)) - offset // Truncated file.
+		}
+		src := d.data[offset : offset+count]
+
+		// Missing data of truncated blocks is left black.
+		for k := range buf {
+			buf[k] = 0
+		}
+		if err := d.decompress(buf, src); err != nil {
+			return nil, err
+		}
+		if d.predictor == 2 {
+			d.undoPredictor(buf, rowLen)
+		}
+		for y := y0; y < y0+d.blockH && y < d.height; y++ {
+			row := buf[(y-y0)*rowLen : (y-y0+1)*rowLen]
+			d.setRow(img, row, x0, y)
+		}
+	}
+	return img, nil
+}
+
+// decompress fills dst with decompressed src. Data exceeding dst
+// is ignored, and dst is left partially filled when src is short.
+func (d *tiffDecoder) decompress(dst, src []byte) error {
+	switch d.compression {
+	case tiffNone:
+		copy(dst, src)
+	case tiffPackBits:
+		unpackBits(dst, src)
+	case tiffLZW:
+		return tiffUnLZW(dst, src)
+	case tiffDeflate, tiffDeflateOld:
+		zr, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		if _, err := io.ReadFull(zr, dst); err != nil &&
+			err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoPredictor reverses horizontal differencing of 8 and 16 bit
+// samples.
+func (d *tiffDecoder) undoPredictor(buf []byte, rowLen int) {
+	for start := 0; start+rowLen <= len(buf); start += rowLen {
+		row := buf[start : start+rowLen]
+		switch d.bps {
+		case 8:
+			for i := d.spp; i < len(row); i++ {
+				row[i] += row[i-d.spp]
+			}
+		case 16:
+			for i := 2 * d.spp; i+1 < len(row); i += 2 {
+				d.bo.PutUint16(row[i:],
+					d.bo.Uint16(row[i:])+d.bo.Uint16(row[i-2*d.spp:]))
+			}
+		}
+	}
+}
+
+// sample returns sample i of a row scaled to 16 bits.
+func (d *tiffDecoder) sample(row []byte, i int) uint32 {
+	switch d.bps {
+	case 8:
+		return uint32(row[i]) * 0x101
+	case 16:
+		return uint32(d.bo.Uint16(row[2*i:]))
+	}
+	bit := i * d.bps
+	max := uint32(1)<<uint(d.bps) - 1
+	v := uint32(row[bit/8]>>uint(8-d.bps-bit%8)) & max
+	return v * 0xffff / max
+}
+
+// setRow copies a row of a block starting at x0 to the image.
+func (d *tiffDecoder) setRow(img image.Image, row []byte, x0, y int) {
+	var s [4]uint32 // Scaled samples: color channels, then alpha.
+	for x := x0; x < x0+d.blockW && x < d.width; x++ {
+		i := (x - x0) * d.spp
+		if d.photometric == tiffPaletted {
+			p := img.(*image.Paletted)
+			bit := i * d.bps
+			p.Pix[y*p.Stride+x] = row[bit/8] >> uint(8-d.bps-bit%8) &
+				byte(1<<uint(d.bps)-1)
+			continue
+		}
+		s[3] = 0xffff
+		if d.photometric == tiffRGB {
+			s[0], s[1], s[2] = d.sample(row, i), d.sample(row, i+1),
+				d.sample(row, i+2)
+			if d.alpha != 0 {
+				s[3] = d.sample(row, i+3)
+			}
+		} else {
+			v := d.sample(row, i)
+			if d.photometric == tiffWhiteIsZero {
+				v = 0xffff - v
+			}
+			s[0], s[1], s[2] = v, v, v
+			if d.alpha != 0 {
+				s[3] = d.sample(row, i+1)
+			}
+		}
+
+		switch img := img.(type) {
+		case *image.Gray:
+			img.Pix[y*img.Stride+x] = uint8(s[0] >> 8)
+		case *image.Gray16:
+			off := y*img.Stride + 2*x
+			img.Pix[off], img.Pix[off+1] = uint8(s[0]>>8), uint8(s[0])
+		case *image.RGBA:
+			setPix8(img.Pix[y*img.Stride+4*x:], s)
+		case *image.NRGBA:
+			setPix8(img.Pix[y*img.Stride+4*x:], s)
+		case *image.RGBA64:
+			setPix16(img.Pix[y*img.Stride+8*x:], s)
+		case *image.NRGBA64:
+			setPix16(img.Pix[y*img.Stride+8*x:], s)
+		}
+	}
+}
+
+func setPix8(pix []byte, s [4]uint32) {
+	for c := 0; c < 4; c++ {
+		pix[c] = uint8(s[c] >> 8)
+	}
+}
+
+func setPix16(pix []byte, s [4]uint32) {
+	for c := 0; c < 4; c++ {
+		pix[2*c], pix[2*c+1] = uint8(s[c]>>8), uint8(s[c])
+	}
+}
+
+// unpackBits decompresses PackBits data.
+func unpackBits(dst, src []byte) {
+	for i, n := 0, 0; i < len(src) && n < len(dst); {
+		h := int(int8(src[i]))
+		i++
+		switch {
+		case h >= 0: // Literal run of h+1 bytes.
+			k := h + 1
+			if i+k > len(src) {
+				k = len(src) - i
+			}
+			n += copy(dst[n:], src[i:i+k])
+			i += k
+		case h != -128: // Byte repeated 1-h times.
+			if i >= len(src) {
+				return
+			}
+			for k := 0; k < 1-h && n < len(dst); k++ {
+				dst[n] = src[i]
+				n++
+			}
+			i++
+		}
+	}
+}
+
+var errTIFFLZW = errors.New("images4: tiff: invalid LZW data")
+
+// tiffUnLZW decompresses TIFF flavored LZW data: codes are packed
+// MSB first and code width grows one code earlier than in
+// compress/lzw ("early change").
+func tiffUnLZW(dst, src []byte) error {
+	const (
+		clearCode = 256
+		eoiCode   = 257
+		maxCodes  = 4096
+	)
+	// Table entries of multi-byte strings point into dst, where
+	// each of them was written as a previous string plus one byte.
+	var offsets, lengths [maxCodes]int
+	var bitBuf uint32
+	var bitCount uint
+	width := uint(9)
+	next := 258
+	prev := -1 // Previous code, or -1 after a clear code.
+	prevStart, prevLen := 0, 0
+	n := 0 // Bytes written to dst.
+
+	for i := 0; ; {
+		for bitCount < width {
+			if i >= len(src) {
+				return nil // Missing EOI code is tolerated.
+			}
+			bitBuf = bitBuf<<8 | uint32(src[i])
+			bitCount += 8
+			i++
+		}
+		code := int(bitBuf>>(bitCount-width)) & (1<<width - 1)
+		bitCount -= width
+
+		switch {
+		case code == clearCode:
+			width, next, prev = 9, 258, -1
+			continue
+		case code == eoiCode:
+			return nil
+		}
+
+		start := n
+		switch {
+		case code < clearCode:
+			if n >= len(dst) {
+				return nil
+			}
+			dst[n] = byte(code)
+			n++
+		case code < next && prev >= 0:
+			// Copy from the earlier occurrence; overlapping
+			// regions are copied byte by byte.
+			for k := 0; k < lengths[code] && n < len(dst); k++ {
+				dst[n] = dst[offsets[code]+k]
+				n++
+			}
+		case code == next && prev >= 0:
+			// The previous string followed by its first byte.
+			for k := 0; k < prevLen && n < len(dst); k++ {
+				dst[n] = dst[prevStart+k]
+				n++
+			}
+			if n < len(dst) {
+				dst[n] = dst[prevStart]
+				n++
+			}
+		default:
+			return errTIFFLZW
+		}
+
+		if prev >= 0 && next < maxCodes {
+			offsets[next], lengths[next] = prevStart, prevLen+1
+			next++
+			if next == 1<<width-1 && width < 12 {
+				width++
+			}
+		}
+		if n >= len(dst) {
+			return nil
+		}
+		prev, prevStart, prevLen = code, start, n-start
+	}
+}
@@ -0,0 +1,43 @@
+package images4
+
+import "testing"
+
+func TestDecodeTIFF(t *testing.T) {
+	tables := []struct {
+		fileName, refName string
+	}{
+		{"bilevel-packbits.tiff", "bilevel.png"},
+		{"gray16.tiff", "gray16.png"},
+		{"pal4.tiff", "pal4.png"},
+		{"pal8-lzw.tiff", "pal8.png"},
+		{"rgb.tiff", "rgb.png"},
+		{"rgb-packbits.tiff", "rgb.png"},
+		{"rgb-lzw-predictor.tiff", "rgb.png"},
+		{"rgb-deflate-tiled.tiff", "rgb.png"},
+		{"rgb16-lzw.tiff", "rgb16.png"},
+	}
+	for _, table := range tables {
+		testDecodeFormat(table.fileName, table.refName, "tiff", t)
+	}
+}
+
+func TestUnpackBits(t *testing.T) {
+	// Example from the TIFF 6.0 specification.
+	src := []byte{0xFE, 0xAA, 0x02, 0x80, 0x00, 0x2A, 0xFD, 0xAA,
+		0x03, 0x80, 0x00, 0x2A, 0x22, 0xF7, 0xAA}
+	want := []byte{0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0xAA, 0xAA,
+		0xAA, 0xAA, 0x80, 0x00, 0x2A, 0x22, 0xAA, 0xAA, 0xAA, 0xAA,
+		0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	dst := make([]byte, len(want))
+	unpackBits(dst, src)
+	if string(dst) != string(want) {
+		t.Errorf("Expected %x, got %x.", want, dst)
+	}
+}
+
+func FuzzDecodeTIFF(f *testing.F) {
+	fuzzSeeds(f, "*.tiff")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, data, decodeTIFF, decodeTIFFConfig)
+	})
+}
@@ -0,0 +1,1118 @@
+package images4
+
+import (
+	"image"
+)
+
+// Decoder of VP8 key frames (RFC 6386), the lossy flavour of WebP.
+// Inter frames are not needed for still images and are rejected.
+// Section numbers below refer to the RFC.
+
+// Intra prediction modes. Subblock modes come first in the order
+// of the RFC; 16x16 luma and 8x8 chroma modes reuse the subblock
+// modes with the same meaning (DC, V, H, TM), and vp8BPred marks
+// macroblocks predicted per subblock.
+const (
+	vp8BDC = iota
+	vp8BTM
+	vp8BVE
+	vp8BHE
+	vp8BLD
+	vp8BRD
+	vp8BVR
+	vp8BVL
+	vp8BHD
+	vp8BHU
+	vp8NumBModes
+	vp8BPred = vp8NumBModes
+)
+
+// Token planes (section 13.3).
+const (
+	vp8PlaneYAfterY2 = iota
+	vp8PlaneY2
+	vp8PlaneUV
+	vp8PlaneYWithDC
+)
+
+var (
+	vp8Zigzag = [16]uint8{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8Bands  = [17]uint8{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+
+	// Probabilities of extra bits of DCT_CAT3 to DCT_CAT6 tokens.
+	vp8CatProbs = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// vp8BoolDecoder is the boolean entropy decoder of section 7.
+// Reading past the end of data yields zero bits, as in libvpx.
+type vp8BoolDecoder struct {
+	data     []byte
+	pos      int
+	value    uint32 // Two bytes window, big endian.
+	rng      uint32
+	bitCount int
+}
+
+func newVP8BoolDecoder(data []byte) *vp8BoolDecoder {
+	d := &vp8BoolDecoder{data: data, rng: 255}
+	d.value = uint32(d.nextByte())<<8 | uint32(d.nextByte())
+	return d
+}
+
+func (d *vp8BoolDecoder) nextByte() byte {
+	if d.pos >= len(d.data) {
+		return 0
+	}
+	d.pos++
+	return d.data[d.pos-1]
+}
+
+func (d *vp8BoolDecoder) readBool(prob uint8) bool {
+	split := 1 + (((d.rng - 1) * uint32(prob)) >> 8)
+	bigSplit := split << 8
+	var bit bool
+	if d.value >= bigSplit {
+		bit = true
+		d.rng -= split
+		d.value -= bigSplit
+	} else {
+		d.rng = split
+	}
+	for d.rng < 128 {
+		d.value <<= 1
+		d.rng <<= 1
+		d.bitCount++
+		if d.bitCount == 8 {
+			d.bitCount = 0
+			d.value |= uint32(d.nextByte())
+		}
+	}
+	return bit
+}
+
+func (d *vp8BoolDecoder) readBool01(prob uint8) int {
+	if d.readBool(prob) {
+		return 1
+	}
+	return 0
+}
+
+func (d *vp8BoolDecoder) readBit() int {
+	return d.readBool01(128)
+}
+
+// readLiteral reads an n-bit unsigned number, most significant
+// bit first.
+func (d *vp8BoolDecoder) readLiteral(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | d.readBit()
+	}
+	return v
+}
+
+// readOptionalSigned reads a flag and, if it is set, an n-bit
+// magnitude followed by a sign bit. Otherwise it returns 0.
+func (d *vp8BoolDecoder) readOptionalSigned(n int) int {
+	if d.readBit() == 0 {
+		return 0
+	}
+	v := d.readLiteral(n)
+	if d.readBit() == 1 {
+		return -v
+	}
+	return v
+}
+
+// vp8Quant holds dequantization factors of one segment,
+// DC and AC for each kind of block.
+type vp8Quant struct {
+	y, y2, uv [2]int32
+}
+
+// vp8MBFilter holds loop filter parameters of a macroblock.
+type vp8MBFilter struct {
+	level    uint8 // 0 means no filtering.
+	interior uint8
+	hevThr   uint8
+	inner    bool // Filter subblock edges too.
+}
+
+type vp8Decoder struct {
+	width, height int
+	mbw, mbh      int
+
+	// Segmentation (section 9.3).
+	segmentation  bool
+	updateMap     bool
+	absoluteDelta bool
+	segQuant      [4]int
+	segFilter     [4]int
+	segProbs      [3]uint8
+
+	// Loop filter (sections 9.6 and 15).
+	simpleFilter bool
+	filterLevel  int
+	sharpness    int
+	deltas       bool
+	refDelta     [4]int
+	modeDelta    [4]int
+
+	quant      [4]vp8Quant
+	coeffProbs [4][8][3][11]uint8
+	skipProb   int // -1 if macroblocks are never skipped.
+
+	header     *vp8BoolDecoder
+	partitions []*vp8BoolDecoder
+
+	img *image.YCbCr // Padded to whole macroblocks.
+
+	// Contexts of the macroblock row above and of the macroblock
+	// to the left: subblock modes of the adjacent subblock edge,
+	// and whether adjacent blocks had non-zero coefficients
+	// (4 luma, 2 U, 2 V, 1 Y2 entries).
+	aboveModes []uint8
+	leftModes  [4]uint8
+	aboveNZ    []uint8
+	leftNZ     [9]uint8
+
+	filters []vp8MBFilter
+
+	coeffs [25 * 16]int32 // 16 Y, 4 U, 4 V and the Y2 block.
+	// Reconstruction workspaces with one row of pixels above and
+	// one column to the left of the macroblock. The luma one also
+	// has 4 pixels above and to the right.
+	ws  [17 * 21]uint8
+	wsU [9 * 9]uint8
+	wsV [9 * 9]uint8
+}
+
+const (
+	vp8WS  = 21 // Stride of the luma workspace.
+	vp8WSC = 9  // Stride of chroma workspaces.
+)
+
+// readVP8FrameHeader parses the uncompressed data chunk at the
+// start of a key frame (section 9.1). It returns the frame size
+// and the size of the first partition.
+func readVP8FrameHeader(data []byte) (width, height, firstPart int, err error) {
+	if len(data) < 10 {
+		return 0, 0, 0, errWebPFormat
+	}
+	tag := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+	if tag&1 != 0 {
+		return 0, 0, 0, errWebPUnsupported // Inter frame.
+	}
+	if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+		return 0, 0, 0, errWebPFormat
+	}
+	// The upper 2 bits are upscaling hints, which we ignore.
+	width = int(data[6]) | int(data[7]&0x3f)<<8
+	height = int(data[8]) | int(data[9]&0x3f)<<8
+	if width == 0 || height == 0 {
+		return 0, 0, 0, errWebPFormat
+	}
+	return width, height, int(tag >> 5), nil
+}
+
+// decodeVP8 decodes a key frame.
+func decodeVP8(data []byte) (*image.YCbCr, error) {
+	width, height, firstPart, err := readVP8FrameHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	data = data[10:]
+	if firstPart > len(data) {
+		return nil, errWebPFormat
+	}
+	d := &vp8Decoder{
+		width:  width,
+		height: height,
+		mbw:    (width + 15) / 16,
+		mbh:    (height + 15) / 16,
+		header: newVP8BoolDecoder(data[:firstPart]),
+	}
+	if err := d.parseHeader(data[firstPart:]); err != nil {
+		return nil, err
+	}
+
+	d.img = image.NewYCbCr(image.Rect(0, 0, 16*d.mbw, 16*d.mbh),
+		image.YCbCrSubsampleRatio420)
+	d.aboveModes = make([]uint8, 4*d.mbw)
+	d.aboveNZ = make([]uint8, 9*d.mbw)
+	d.filters = make([]vp8MBFilter, d.mbw*d.mbh)
+	for mby := 0; mby < d.mbh; mby++ {
+		d.leftModes = [4]uint8{}
+		d.leftNZ = [9]uint8{}
+		tokens := d.partitions[mby%len(d.partitions)]
+		for mbx := 0; mbx < d.mbw; mbx++ {
+			d.decodeMacroblock(mbx, mby, tokens)
+		}
+	}
+	if d.filterLevel > 0 {
+		d.loopFilter()
+	}
+
+	img := d.img
+	img.Rect = image.Rect(0, 0, width, height)
+	return img, nil
+}
+
+// parseHeader reads the frame header from the first partition
+// (section 9.2 to 9.11) and sets up the token partitions, which
+// follow it.
+func (d *vp8Decoder) parseHeader(rest []byte) error {
+	h := d.header
+	h.readLiteral(2) // Color space and clamping type.
+
+	if d.segmentation = h.readBit() == 1; d.segmentation {
+		d.updateMap = h.readBit() == 1
+		updateData := h.readBit() == 1
+		if updateData {
+			d.absoluteDelta = h.readBit() == 1
+			for i := range d.segQuant {
+				d.segQuant[i] = h.readOptionalSigned(7)
+			}
+			for i := range d.segFilter {
+				d.segFilter[i] = h.readOptionalSigned(6)
+			}
+		}
+		if d.updateMap {
+			for i := range d.segProbs {
+				d.segProbs[i] = 255
+				if h.readBit() == 1 {
+					d.segProbs[i] = uint8(h.readLiteral(8))
+				}
+			}
+		}
+	}
+
+	d.simpleFilter = h.readBit() == 1
+	d.filterLevel = h.readLiteral(6)
+	d.sharpness = h.readLiteral(3)
+	if d.deltas = h.readBit() == 1; d.deltas {
+		if h.readBit() == 1 { // Update.
+			for i := range d.refDelta {
+				d.refDelta[i] = h.readOptionalSigned(6)
+			}
+			for i := range d.modeDelta {
+				d.modeDelta[i] = h.readOptionalSigned(6)
+			}
+		}
+	}
+
+	// Token partitions (section 9.5).
+	n := 1 << uint(h.readLiteral(2))
+	sizes := rest
+	if len(sizes) < 3*(n-1) {
+		return errWebPFormat
+	}
+	rest = rest[3*(n-1):]
+	for i := 0; i < n; i++ {
+		size := len(rest)
+		if i < n-1 {
+			size = int(sizes[3*i]) | int(sizes[3*i+1])<<8 | int(sizes[3*i+2])<<16
+			if size > len(rest) {
+				return errWebPFormat
+			}
+		}
+		d.partitions = append(d.partitions, newVP8BoolDecoder(rest[:size]))
+		rest = rest[size:]
+	}
+
+	d.parseQuant()
+	h.readBit() // Refresh entropy probs, irrelevant for one frame.
+
+	d.coeffProbs = vp8DefaultCoeffProbs
+	for i := range d.coeffProbs {
+		for j := range d.coeffProbs[i] {
+			for k := range d.coeffProbs[i][j] {
+				for l := range d.coeffProbs[i][j][k] {
+					if h.readBool(vp8CoeffUpdateProbs[i][j][k][l]) {
+						d.coeffProbs[i][j][k][l] = uint8(h.readLiteral(8))
+					}
+				}
+			}
+		}
+	}
+
+	d.skipProb = -1
+	if h.readBit() == 1 {
+		d.skipProb = h.readLiteral(8)
+	}
+	return nil
+}
+
+// parseQuant reads quantizer indices (section 9.6) and computes
+// dequantization factors of each segment (section 14.1).
+func (d *vp8Decoder) parseQuant() {
+	h := d.header
+	base := h.readLiteral(7)
+	yDC := h.readOptionalSigned(4)
+	y2DC := h.readOptionalSigned(4)
+	y2AC := h.readOptionalSigned(4)
+	uvDC := h.readOptionalSigned(4)
+	uvAC := h.readOptionalSigned(4)
+
+	lookup := func(table *[128]int32, q int) int32 {
+		return table[clampInt(q, 0, 127)]
+	}
+	for s := range d.quant {
+		q := base
+		if d.segmentation {
+			q = d.segQuant[s]
+			if !d.absoluteDelta {
+				q += base
+			}
+		}
+		qt := &d.quant[s]
+		qt.y[0] = lookup(&vp8DCQuant, q+yDC)
+		qt.y[1] = lookup(&vp8ACQuant, q)
+		qt.y2[0] = lookup(&vp8DCQuant, q+y2DC) * 2
+		qt.y2[1] = lookup(&vp8ACQuant, q+y2AC) * 155 / 100
+		if qt.y2[1] < 8 {
+			qt.y2[1] = 8
+		}
+		qt.uv[0] = lookup(&vp8DCQuant, q+uvDC)
+		if qt.uv[0] > 132 {
+			qt.uv[0] = 132
+		}
+		qt.uv[1] = lookup(&vp8ACQuant, q+uvAC)
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// decodeMacroblock parses modes and coefficients of a macroblock
+// and reconstructs it into d.img.
+func (d *vp8Decoder) decodeMacroblock(mbx, mby int, tokens *vp8BoolDecoder) {
+	h := d.header
+	segment := 0
+	if d.updateMap {
+		if !h.readBool(d.segProbs[0]) {
+			segment = h.readBool01(d.segProbs[1])
+		} else {
+			segment = 2 + h.readBool01(d.segProbs[2])
+		}
+	}
+	skip := d.skipProb >= 0 && h.readBool(uint8(d.skipProb))
+
+	// Modes (sections 11.2 to 11.4).
+	var yMode int
+	var bModes [16]uint8
+	above := d.aboveModes[4*mbx : 4*mbx+4]
+	if !h.readBool(145) {
+		yMode = vp8BPred
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				left := d.leftModes[y]
+				if x > 0 {
+					left = bModes[4*y+x-1]
+				}
+				up := above[x]
+				if y > 0 {
+					up = bModes[4*(y-1)+x]
+				}
+				bModes[4*y+x] = d.readBMode(&vp8BModeProbs[up][left])
+			}
+		}
+	} else {
+		switch {
+		case !h.readBool(156):
+			yMode = vp8BDC
+			if h.readBool(163) {
+				yMode = vp8BVE
+			}
+		case !h.readBool(128):
+			yMode = vp8BHE
+		default:
+			yMode = vp8BTM
+		}
+		for i := range bModes {
+			bModes[i] = uint8(yMode)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		above[i] = bModes[12+i]
+		d.leftModes[i] = bModes[4*i+3]
+	}
+	uvMode := vp8BDC
+	if h.readBool(142) {
+		switch {
+		case !h.readBool(114):
+			uvMode = vp8BVE
+		case !h.readBool(183):
+			uvMode = vp8BHE
+		default:
+			uvMode = vp8BTM
+		}
+	}
+
+	// Coefficients.
+	d.coeffs = [25 * 16]int32{}
+	var nz uint32 // Bit i is set if block i has non-zero coefficients.
+	aboveNZ := d.aboveNZ[9*mbx : 9*mbx+9]
+	if skip {
+		for i := 0; i < 8; i++ {
+			aboveNZ[i], d.leftNZ[i] = 0, 0
+		}
+		if yMode != vp8BPred {
+			aboveNZ[8], d.leftNZ[8] = 0, 0
+		}
+	} else {
+		nz = d.readResiduals(tokens, yMode, &d.quant[segment], aboveNZ)
+	}
+
+	d.reconstruct(mbx, mby, yMode, uvMode, bModes, nz)
+	d.setFilter(mbx, mby, segment, yMode, nz != 0)
+}
+
+// readBMode reads a subblock mode with the tree of section 11.2.
+func (d *vp8Decoder) readBMode(p *[vp8NumBModes - 1]uint8) uint8 {
+	h := d.header
+	switch {
+	case !h.readBool(p[0]):
+		return vp8BDC
+	case !h.readBool(p[1]):
+		return vp8BTM
+	case !h.readBool(p[2]):
+		return vp8BVE
+	case !h.readBool(p[3]):
+		switch {
+		case !h.readBool(p[4]):
+			return vp8BHE
+		case !h.readBool(p[5]):
+			return vp8BRD
+		}
+		return vp8BVR
+	case !h.readBool(p[6]):
+		return vp8BLD
+	case !h.readBool(p[7]):
+		return vp8BVL
+	case !h.readBool(p[8]):
+		return vp8BHD
+	}
+	return vp8BHU
+}
+
+// readResiduals reads and dequantizes coefficients of all blocks
+// of a macroblock (section 13). It returns the mask of blocks
+// with non-zero coefficients; bit 24 is the Y2 block.
+func (d *vp8Decoder) readResiduals(t *vp8BoolDecoder, yMode int,
+	q *vp8Quant, aboveNZ []uint8) uint32 {
+	var nz uint32
+	yPlane, first := vp8PlaneYWithDC, 0
+	if yMode != vp8BPred {
+		ctx := aboveNZ[8] + d.leftNZ[8]
+		y2 := d.coeffs[24*16:]
+		if d.readCoeffs(t, y2, vp8PlaneY2, ctx, 0, q.y2) {
+			aboveNZ[8], d.leftNZ[8] = 1, 1
+			nz |= 1 << 24
+			vp8InverseWHT(y2, d.coeffs[:])
+			for i := 0; i < 16; i++ {
+				if d.coeffs[16*i] != 0 {
+					nz |= 1 << uint(i)
+				}
+			}
+		} else {
+			aboveNZ[8], d.leftNZ[8] = 0, 0
+		}
+		yPlane, first = vp8PlaneYAfterY2, 1
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			i := 4*y + x
+			ctx := aboveNZ[x] + d.leftNZ[y]
+			var v uint8
+			if d.readCoeffs(t, d.coeffs[16*i:], yPlane, ctx, first, q.y) {
+				v = 1
+				nz |= 1 << uint(i)
+			}
+			aboveNZ[x], d.leftNZ[y] = v, v
+		}
+	}
+	// U blocks use context entries 4 and 5, V blocks 6 and 7.
+	for c := 4; c < 8; c += 2 {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				i := 16 + 2*(c-4) + 2*y + x
+				ctx := aboveNZ[c+x] + d.leftNZ[c+y]
+				var v uint8
+				if d.readCoeffs(t, d.coeffs[16*i:], vp8PlaneUV, ctx, 0, q.uv) {
+					v = 1
+					nz |= 1 << uint(i)
+				}
+				aboveNZ[c+x], d.leftNZ[c+y] = v, v
+			}
+		}
+	}
+	return nz
+}
+
+// readCoeffs reads tokens of a block starting at coefficient
+// first (section 13.2) and stores dequantized coefficients in
+// natural order. It reports whether any token other than an
+// immediate end of block was read.
+func (d *vp8Decoder) readCoeffs(t *vp8BoolDecoder, out []int32, plane int,
+	ctx uint8, first int, q [2]int32) bool {
+	probs := &d.coeffProbs[plane]
+	i := first
+	p := &probs[vp8Bands[i]][ctx]
+	if !t.readBool(p[0]) {
+		return false
+	}
+	for {
+		if !t.readBool(p[1]) { // Zero, never followed by end of block.
+			i++
+			if i == 16 {
+				return true
+			}
+			p = &probs[vp8Bands[i]][0]
+			continue
+		}
+		var v int32
+		next := 2
+		switch {
+		case !t.readBool(p[2]):
+			v, next = 1, 1
+		case !t.readBool(p[3]):
+			v = 2
+			if t.readBool(p[4]) {
+				v = 3 + int32(t.readBool01(p[5]))
+			}
+		case !t.readBool(p[6]):
+			if !t.readBool(p[7]) {
+				v = 5 + int32(t.readBool01(159))
+			} else {
+				v = 7 + 2*int32(t.readBool01(165)) + int32(t.readBool01(145))
+			}
+		default:
+			b1 := t.readBool01(p[8])
+			b0 := t.readBool01(p[9+b1])
+			cat := 2*b1 + b0
+			for _, prob := range vp8CatProbs[cat] {
+				v = 2*v + int32(t.readBool01(prob))
+			}
+			v += 3 + 8<<uint(cat)
+		}
+		if t.readBit() == 1 {
+			v = -v
+		}
+		if i == 0 {
+			out[0] = v * q[0]
+		} else {
+			out[vp8Zigzag[i]] = v * q[1]
+		}
+		i++
+		if i == 16 {
+			return true
+		}
+		p = &probs[vp8Bands[i]][next]
+		if !t.readBool(p[0]) {
+			return true
+		}
+	}
+}
+
+// vp8InverseWHT transforms the Y2 block and distributes the
+// results to DC coefficients of the 16 luma blocks (section 14.3).
+func vp8InverseWHT(in []int32, coeffs []int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		a1 := in[i] + in[12+i]
+		b1 := in[4+i] + in[8+i]
+		c1 := in[4+i] - in[8+i]
+		d1 := in[i] - in[12+i]
+		tmp[i] = a1 + b1
+		tmp[4+i] = c1 + d1
+		tmp[8+i] = a1 - b1
+		tmp[12+i] = d1 - c1
+	}
+	for i := 0; i < 4; i++ {
+		a1 := tmp[4*i] + tmp[4*i+3]
+		b1 := tmp[4*i+1] + tmp[4*i+2]
+		c1 := tmp[4*i+1] - tmp[4*i+2]
+		d1 := tmp[4*i] - tmp[4*i+3]
+		coeffs[16*(4*i)] = (a1 + b1 + 3) >> 3
+		coeffs[16*(4*i+1)] = (c1 + d1 + 3) >> 3
+		coeffs[16*(4*i+2)] = (a1 - b1 + 3) >> 3
+		coeffs[16*(4*i+3)] = (d1 - c1 + 3) >> 3
+	}
+}
+
+// vp8InverseDCT adds the inverse transform of a block of
+// coefficients to 4x4 predicted pixels (section 14.3).
+func vp8InverseDCT(in []int32, dst []uint8, off, stride int) {
+	const c1, c2 = 20091, 35468 // cos(π/8)√2-1 and sin(π/8)√2, ×65536.
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		a := in[i] + in[8+i]
+		b := in[i] - in[8+i]
+		c := (in[4+i]*c2)>>16 - (in[12+i] + (in[12+i]*c1)>>16)
+		d := in[4+i] + (in[4+i]*c1)>>16 + (in[12+i]*c2)>>16
+		tmp[i] = a + d
+		tmp[4+i] = b + c
+		tmp[8+i] = b - c
+		tmp[12+i] = a - d
+	}
+	for i := 0; i < 4; i++ {
+		t := tmp[4*i:]
+		a := t[0] + t[2]
+		b := t[0] - t[2]
+		c := (t[1]*c2)>>16 - (t[3] + (t[3]*c1)>>16)
+		d := t[1] + (t[1]*c1)>>16 + (t[3]*c2)>>16
+		p := off + i*stride
+		dst[p] = vp8Clip(int32(dst[p]) + (a+d+4)>>3)
+		dst[p+1] = vp8Clip(int32(dst[p+1]) + (b+c+4)>>3)
+		dst[p+2] = vp8Clip(int32(dst[p+2]) + (b-c+4)>>3)
+		dst[p+3] = vp8Clip(int32(dst[p+3]) + (a-d+4)>>3)
+	}
+}
+
+func vp8Clip(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// reconstruct predicts the macroblock, adds the residuals and
+// stores the result in d.img (sections 12 and 14). Prediction uses
+// pixels of neighbouring macroblocks before loop filtering.
+func (d *vp8Decoder) reconstruct(mbx, mby, yMode, uvMode int,
+	bModes [16]uint8, nz uint32) {
+	img := d.img
+	x0, y0 := 16*mbx, 16*mby
+	ws := d.ws[:]
+	d.loadEdges(ws, vp8WS, 16, img.Y, img.YStride, x0, y0)
+	d.loadEdges(d.wsU[:], vp8WSC, 8, img.Cb, img.CStride, x0/2, y0/2)
+	d.loadEdges(d.wsV[:], vp8WSC, 8, img.Cr, img.CStride, x0/2, y0/2)
+
+	if yMode == vp8BPred {
+		// Pixels above and to the right of the macroblock serve
+		// as above-right pixels for the whole right column of
+		// subblocks.
+		if mby > 0 {
+			above := img.Y[(y0-1)*img.YStride:]
+			for i := 0; i < 4; i++ {
+				if mbx < d.mbw-1 {
+					ws[17+i] = above[x0+16+i]
+				} else {
+					ws[17+i] = above[x0+15]
+				}
+			}
+		}
+		for y := 4; y < 16; y += 4 {
+			copy(ws[y*vp8WS+17:y*vp8WS+21], ws[17:21])
+		}
+		for i := 0; i < 16; i++ {
+			off := (1+4*(i/4))*vp8WS + 1 + 4*(i%4)
+			vp8Predict4(ws, off, vp8WS, bModes[i])
+			if nz&(1<<uint(i)) != 0 {
+				vp8InverseDCT(d.coeffs[16*i:], ws, off, vp8WS)
+			}
+		}
+	} else {
+		vp8Predict(ws, vp8WS+1, vp8WS, 16, yMode, mbx > 0, mby > 0)
+		for i := 0; i < 16; i++ {
+			if nz&(1<<uint(i)) != 0 {
+				off := (1+4*(i/4))*vp8WS + 1 + 4*(i%4)
+				vp8InverseDCT(d.coeffs[16*i:], ws, off, vp8WS)
+			}
+		}
+	}
+	for y := 0; y < 16; y++ {
+		copy(img.Y[(y0+y)*img.YStride+x0:], ws[(y+1)*vp8WS+1:(y+1)*vp8WS+17])
+	}
+
+	for c, wsC := range [2][]uint8{d.wsU[:], d.wsV[:]} {
+		vp8Predict(wsC, vp8WSC+1, vp8WSC, 8, uvMode, mbx > 0, mby > 0)
+		for j := 0; j < 4; j++ {
+			i := 16 + 4*c + j
+			if nz&(1<<uint(i)) != 0 {
+				off := (1+4*(j/2))*vp8WSC + 1 + 4*(j%2)
+				vp8InverseDCT(d.coeffs[16*i:], wsC, off, vp8WSC)
+			}
+		}
+		plane := img.Cb
+		if c == 1 {
+			plane = img.Cr
+		}
+		for y := 0; y < 8; y++ {
+			copy(plane[(y0/2+y)*img.CStride+x0/2:],
+				wsC[(y+1)*vp8WSC+1:(y+1)*vp8WSC+9])
+		}
+	}
+}
+
+// loadEdges fills row 0 and column 0 of a workspace with pixels
+// above and to the left of an n×n block at (x0, y0) of a plane.
+// Outside the frame, rows above are 127 and columns to the left
+// are 129, as in libvpx and libwebp.
+func (d *vp8Decoder) loadEdges(ws []uint8, stride, n int,
+	plane []uint8, planeStride, x0, y0 int) {
+	if y0 == 0 {
+		for i := 0; i < stride; i++ {
+			ws[i] = 127
+		}
+	} else {
+		above := plane[(y0-1)*planeStride:]
+		copy(ws[1:1+n], above[x0:x0+n])
+		if x0 == 0 {
+			ws[0] = 129
+		} else {
+			ws[0] = above[x0-1]
+		}
+	}
+	for y := 0; y < n; y++ {
+		if x0 == 0 {
+			ws[(y+1)*stride] = 129
+		} else {
+			ws[(y+1)*stride] = plane[(y0+y)*planeStride+x0-1]
+		}
+	}
+}
+
+// vp8Predict predicts an n×n block with one of the DC, V, H and
+// TM modes (section 12.2). The DC mode only averages pixels inside
+// the frame.
+func vp8Predict(ws []uint8, off, stride, n, mode int, hasLeft, hasTop bool) {
+	above := ws[off-stride : off-stride+n]
+	switch mode {
+	case vp8BDC:
+		sum, count := 0, 0
+		if hasTop {
+			for _, v := range above {
+				sum += int(v)
+			}
+			count += n
+		}
+		if hasLeft {
+			for y := 0; y < n; y++ {
+				sum += int(ws[off+y*stride-1])
+			}
+			count += n
+		}
+		v := uint8(128)
+		if count > 0 {
+			v = uint8((sum + count/2) / count)
+		}
+		for y := 0; y < n; y++ {
+			row := ws[off+y*stride : off+y*stride+n]
+			for x := range row {
+				row[x] = v
+			}
+		}
+	case vp8BVE:
+		for y := 0; y < n; y++ {
+			copy(ws[off+y*stride:off+y*stride+n], above)
+		}
+	case vp8BHE:
+		for y := 0; y < n; y++ {
+			row := ws[off+y*stride : off+y*stride+n]
+			v := ws[off+y*stride-1]
+			for x := range row {
+				row[x] = v
+			}
+		}
+	default: // vp8BTM
+		p := int32(ws[off-stride-1])
+		for y := 0; y < n; y++ {
+			left := int32(ws[off+y*stride-1]) - p
+			row := ws[off+y*stride : off+y*stride+n]
+			for x := range row {
+				row[x] = vp8Clip(left + int32(above[x]))
+			}
+		}
+	}
+}
+
+func avg2(a, b uint8) uint8 {
+	return uint8((int(a) + int(b) + 1) >> 1)
+}
+
+func avg3(a, b, c uint8) uint8 {
+	return uint8((int(a) + 2*int(b) + int(c) + 2) >> 2)
+}
+
+// vp8Predict4 predicts a 4×4 subblock (section 12.3). Letters
+// follow the RFC and libwebp: P is the pixel above and to the left,
+// A to H are 8 pixels above (E to H above and to the right), and
+// I to L are pixels to the left.
+func vp8Predict4(ws []uint8, off, stride int, mode uint8) {
+	t := ws[off-stride-1 : off-stride+8]
+	P, A, B, C, D, E, F, G, H := t[0], t[1], t[2], t[3], t[4], t[5], t[6], t[7], t[8]
+	I, J, K, L := ws[off-1], ws[off+stride-1], ws[off+2*stride-1], ws[off+3*stride-1]
+	var b [4][4]uint8 // b[y][x]
+	switch mode {
+	case vp8BDC:
+		sum := int(A) + int(B) + int(C) + int(D) + int(I) + int(J) + int(K) + int(L)
+		v := uint8((sum + 4) >> 3)
+		for y := range b {
+			b[y] = [4]uint8{v, v, v, v}
+		}
+	case vp8BTM:
+		left := [4]uint8{I, J, K, L}
+		for y := range b {
+			for x := range b[y] {
+				b[y][x] = vp8Clip(int32(left[y]) + int32(t[1+x]) - int32(P))
+			}
+		}
+	case vp8BVE:
+		row := [4]uint8{avg3(P, A, B), avg3(A, B, C), avg3(B, C, D), avg3(C, D, E)}
+		for y := range b {
+			b[y] = row
+		}
+	case vp8BHE:
+		col := [4]uint8{avg3(P, I, J), avg3(I, J, K), avg3(J, K, L), avg3(K, L, L)}
+		for y := range b {
+			b[y] = [4]uint8{col[y], col[y], col[y], col[y]}
+		}
+	case vp8BLD:
+		for y := range b {
+			for x := range b[y] {
+				i := x + y
+				last := i + 2
+				if last > 7 {
+					last = 7
+				}
+				b[y][x] = avg3(t[1+i], t[2+i], t[1+last])
+			}
+		}
+	case vp8BRD:
+		e := [9]uint8{L, K, J, I, P, A, B, C, D}
+		for y := range b {
+			for x := range b[y] {
+				i := 3 - y + x
+				b[y][x] = avg3(e[i], e[i+1], e[i+2])
+			}
+		}
+	case vp8BVR:
+		b[0][0], b[2][1] = avg2(P, A), avg2(P, A)
+		b[0][1], b[2][2] = avg2(A, B), avg2(A, B)
+		b[0][2], b[2][3] = avg2(B, C), avg2(B, C)
+		b[0][3] = avg2(C, D)
+		b[3][0] = avg3(K, J, I)
+		b[2][0] = avg3(J, I, P)
+		b[1][0], b[3][1] = avg3(I, P, A), avg3(I, P, A)
+		b[1][1], b[3][2] = avg3(P, A, B), avg3(P, A, B)
+		b[1][2], b[3][3] = avg3(A, B, C), avg3(A, B, C)
+		b[1][3] = avg3(B, C, D)
+	case vp8BVL:
+		b[0][0] = avg2(A, B)
+		b[0][1], b[2][0] = avg2(B, C), avg2(B, C)
+		b[0][2], b[2][1] = avg2(C, D), avg2(C, D)
+		b[0][3], b[2][2] = avg2(D, E), avg2(D, E)
+		b[1][0] = avg3(A, B, C)
+		b[1][1], b[3][0] = avg3(B, C, D), avg3(B, C, D)
+		b[1][2], b[3][1] = avg3(C, D, E), avg3(C, D, E)
+		b[1][3], b[3][2] = avg3(D, E, F), avg3(D, E, F)
+		// The last two values do not follow the pattern.
+		b[2][3] = avg3(E, F, G)
+		b[3][3] = avg3(F, G, H)
+	case vp8BHD:
+		b[0][0], b[1][2] = avg2(I, P), avg2(I, P)
+		b[1][0], b[2][2] = avg2(J, I), avg2(J, I)
+		b[2][0], b[3][2] = avg2(K, J), avg2(K, J)
+		b[3][0] = avg2(L, K)
+		b[0][3] = avg3(A, B, C)
+		b[0][2] = avg3(P, A, B)
+		b[0][1], b[1][3] = avg3(I, P, A), avg3(I, P, A)
+		b[1][1], b[2][3] = avg3(J, I, P), avg3(J, I, P)
+		b[2][1], b[3][3] = avg3(K, J, I), avg3(K, J, I)
+		b[3][1] = avg3(L, K, J)
+	case vp8BHU:
+		b[0][0] = avg2(I, J)
+		b[0][2], b[1][0] = avg2(J, K), avg2(J, K)
+		b[1][2], b[2][0] = avg2(K, L), avg2(K, L)
+		b[0][1] = avg3(I, J, K)
+		b[0][3], b[1][1] = avg3(J, K, L), avg3(J, K, L)
+		b[1][3], b[2][1] = avg3(K, L, L), avg3(K, L, L)
+		b[2][2], b[2][3], b[3][0], b[3][1], b[3][2], b[3][3] = L, L, L, L, L, L
+	}
+	for y := range b {
+		copy(ws[off+y*stride:off+y*stride+4], b[y][:])
+	}
+}
+
+// setFilter computes loop filter parameters of a macroblock
+// (sections 9.6 and 15.1).
+func (d *vp8Decoder) setFilter(mbx, mby, segment, yMode int, coeffs bool) {
+	level := d.filterLevel
+	if d.segmentation {
+		level = d.segFilter[segment]
+		if !d.absoluteDelta {
+			level += d.filterLevel
+		}
+	}
+	if d.deltas {
+		level += d.refDelta[0] // Intra frame.
+		if yMode == vp8BPred {
+			level += d.modeDelta[0]
+		}
+	}
+	level = clampInt(level, 0, 63)
+
+	interior := level
+	if d.sharpness > 0 {
+		if d.sharpness > 4 {
+			interior >>= 2
+		} else {
+			interior >>= 1
+		}
+		if interior > 9-d.sharpness {
+			interior = 9 - d.sharpness
+		}
+	}
+	if interior < 1 {
+		interior = 1
+	}
+	hevThr := 0
+	if level >= 40 {
+		hevThr = 2
+	} else if level >= 15 {
+		hevThr = 1
+	}
+	d.filters[mby*d.mbw+mbx] = vp8MBFilter{
+		level:    uint8(level),
+		interior: uint8(interior),
+		hevThr:   uint8(hevThr),
+		inner:    yMode == vp8BPred || coeffs,
+	}
+}
+
+// loopFilter filters macroblock and subblock edges of the whole
+// frame, in raster order of macroblocks (section 15).
+func (d *vp8Decoder) loopFilter() {
+	img := d.img
+	for mby := 0; mby < d.mbh; mby++ {
+		for mbx := 0; mbx < d.mbw; mbx++ {
+			f := &d.filters[mby*d.mbw+mbx]
+			if f.level == 0 {
+				continue
+			}
+			d.filterMacroblock(img.Y, img.YStride, 16*mbx, 16*mby, 16, f)
+			if !d.simpleFilter {
+				d.filterMacroblock(img.Cb, img.CStride, 8*mbx, 8*mby, 8, f)
+				d.filterMacroblock(img.Cr, img.CStride, 8*mbx, 8*mby, 8, f)
+			}
+		}
+	}
+}
+
+// filterMacroblock filters edges of an n×n block of a plane: the
+// left edge, inner vertical edges, the top edge, and inner
+// horizontal edges, in this order.
+func (d *vp8Decoder) filterMacroblock(pix []uint8, stride, x0, y0, n int,
+	f *vp8MBFilter) {
+	limit := 2*int(f.level) + int(f.interior)
+	off := y0*stride + x0
+	if x0 > 0 {
+		d.filterEdge(pix, off, 1, stride, n, limit+4, f, true)
+	}
+	if f.inner {
+		for x := 4; x < n; x += 4 {
+			d.filterEdge(pix, off+x, 1, stride, n, limit, f, false)
+		}
+	}
+	if y0 > 0 {
+		d.filterEdge(pix, off, stride, 1, n, limit+4, f, true)
+	}
+	if f.inner {
+		for y := 4; y < n; y += 4 {
+			d.filterEdge(pix, off+y*stride, stride, 1, n, limit, f, false)
+		}
+	}
+}
+
+// filterEdge filters n pixel positions along an edge. Pixel q0 of
+// position i is at off+i*along, and step crosses the edge.
+func (d *vp8Decoder) filterEdge(pix []uint8, off, step, along, n, limit int,
+	f *vp8MBFilter, mbEdge bool) {
+	interior, hevThr := int(f.interior), int(f.hevThr)
+	for i := 0; i < n; i++ {
+		q := off + i*along
+		p1, p0 := int(pix[q-2*step]), int(pix[q-step])
+		q0, q1 := int(pix[q]), int(pix[q+step])
+		if 2*absInt(p0-q0)+absInt(p1-q1)/2 > limit {
+			continue
+		}
+		if d.simpleFilter {
+			vp8CommonAdjust(pix, q, step, true)
+			continue
+		}
+		p3, p2 := int(pix[q-4*step]), int(pix[q-3*step])
+		q2, q3 := int(pix[q+2*step]), int(pix[q+3*step])
+		if absInt(p3-p2) > interior || absInt(p2-p1) > interior ||
+			absInt(p1-p0) > interior || absInt(q1-q0) > interior ||
+			absInt(q2-q1) > interior || absInt(q3-q2) > interior {
+			continue
+		}
+		hev := absInt(p1-p0) > hevThr || absInt(q1-q0) > hevThr
+		switch {
+		case hev:
+			vp8CommonAdjust(pix, q, step, true)
+		case mbEdge:
+			w := vp8ClampS8(vp8ClampS8(p1-q1) + 3*(q0-p0))
+			a := (27*w + 63) >> 7
+			pix[q-step], pix[q] = vp8Clip(int32(p0+a)), vp8Clip(int32(q0-a))
+			a = (18*w + 63) >> 7
+			pix[q-2*step], pix[q+step] = vp8Clip(int32(p1+a)), vp8Clip(int32(q1-a))
+			a = (9*w + 63) >> 7
+			pix[q-3*step], pix[q+2*step] = vp8Clip(int32(p2+a)), vp8Clip(int32(q2-a))
+		default:
+			a := (vp8CommonAdjust(pix, q, step, false) + 1) >> 1
+			pix[q-2*step], pix[q+step] = vp8Clip(int32(p1+a)), vp8Clip(int32(q1-a))
+		}
+	}
+}
+
+// vp8CommonAdjust moves p0 and q0 towards each other and returns
+// the adjustment of q0 (section 15.2).
+func vp8CommonAdjust(pix []uint8, q, step int, outerTaps bool) int {
+	p1, p0 := int(pix[q-2*step]), int(pix[q-step])
+	q0, q1 := int(pix[q]), int(pix[q+step])
+	a := 3 * (q0 - p0)
+	if outerTaps {
+		a += vp8ClampS8(p1 - q1)
+	}
+	a = vp8ClampS8(a)
+	b := vp8ClampS8(a+3) >> 3
+	a = vp8ClampS8(a+4) >> 3
+	pix[q-step], pix[q] = vp8Clip(int32(p0+b)), vp8Clip(int32(q0-a))
+	return a
+}
+
+// vp8ClampS8 clamps to the range of int8.
+func vp8ClampS8(v int) int {
+	if v < -128 {
+		return -128
+	}
+	if v > 127 {
+		return 127
+	}
+	return v
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
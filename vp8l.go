@@ -0,0 +1,634 @@
+package images4
+
+import (
+	"image"
+)
+
+// Decoder of VP8L, the lossless flavour of WebP, as specified in
+// "WebP Lossless Bitstream Specification" (RFC 9649). It also
+// decodes alpha planes of lossy images, which use the same
+// format without the header.
+
+// Transform types.
+const (
+	vp8lPredictor = iota
+	vp8lColor
+	vp8lSubtractGreen
+	vp8lColorIndexing
+)
+
+// Alphabet sizes of the 5 prefix codes of a group, without the
+// color cache part of the green code.
+var vp8lAlphabets = [5]int{256 + 24, 256, 256, 256, 40}
+
+// Order of code lengths of the code length code.
+var vp8lCodeLengthOrder = [19]uint8{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lDistanceMap maps the first 120 distance codes to (dx, dy)
+// offsets of nearby pixels.
+var vp8lDistanceMap = [120][2]int8{
+	{0, 1}, {1, 0}, {1, 1}, {-1, 1}, {0, 2}, {2, 0}, {1, 2}, {-1, 2},
+	{2, 1}, {-2, 1}, {2, 2}, {-2, 2}, {0, 3}, {3, 0}, {1, 3}, {-1, 3},
+	{3, 1}, {-3, 1}, {2, 3}, {-2, 3}, {3, 2}, {-3, 2}, {0, 4}, {4, 0},
+	{1, 4}, {-1, 4}, {4, 1}, {-4, 1}, {3, 3}, {-3, 3}, {2, 4}, {-2, 4},
+	{4, 2}, {-4, 2}, {0, 5}, {3, 4}, {-3, 4}, {4, 3}, {-4, 3}, {5, 0},
+	{1, 5}, {-1, 5}, {5, 1}, {-5, 1}, {2, 5}, {-2, 5}, {5, 2}, {-5, 2},
+	{4, 4}, {-4, 4}, {3, 5}, {-3, 5}, {5, 3}, {-5, 3}, {0, 6}, {6, 0},
+	{1, 6}, {-1, 6}, {6, 1}, {-6, 1}, {2, 6}, {-2, 6}, {6, 2}, {-6, 2},
+	{4, 5}, {-4, 5}, {5, 4}, {-5, 4}, {3, 6}, {-3, 6}, {6, 3}, {-6, 3},
+	{0, 7}, {7, 0}, {1, 7}, {-1, 7}, {5, 5}, {-5, 5}, {7, 1}, {-7, 1},
+	{4, 6}, {-4, 6}, {6, 4}, {-6, 4}, {2, 7}, {-2, 7}, {7, 2}, {-7, 2},
+	{3, 7}, {-3, 7}, {7, 3}, {-7, 3}, {5, 6}, {-5, 6}, {6, 5}, {-6, 5},
+	{8, 0}, {4, 7}, {-4, 7}, {7, 4}, {-7, 4}, {8, 1}, {8, 2}, {6, 6},
+	{-6, 6}, {8, 3}, {5, 7}, {-5, 7}, {7, 5}, {-7, 5}, {8, 4}, {6, 7},
+	{-6, 7}, {7, 6}, {-7, 6}, {8, 5}, {7, 7}, {-7, 7}, {8, 6}, {8, 7},
+}
+
+// vp8lBitReader reads bits least significant first. Reading past
+// the end of data yields zero bits and is detected by func overrun.
+type vp8lBitReader struct {
+	data []byte
+	pos  int
+	bits uint64
+	n    uint // Number of valid bits.
+	over int  // Number of zero bytes fed past the end.
+}
+
+func (r *vp8lBitReader) fill() {
+	for r.n <= 56 {
+		var b byte
+		if r.pos < len(r.data) {
+			b = r.data[r.pos]
+			r.pos++
+		} else {
+			r.over++
+		}
+		r.bits |= uint64(b) << r.n
+		r.n += 8
+	}
+}
+
+// read returns the next n bits, n <= 32.
+func (r *vp8lBitReader) read(n uint) uint32 {
+	if r.n < n {
+		r.fill()
+	}
+	v := uint32(r.bits & (1<<n - 1))
+	r.bits >>= n
+	r.n -= n
+	return v
+}
+
+// overrun reports whether bits past the end of data were consumed.
+func (r *vp8lBitReader) overrun() bool {
+	return 8*r.over > int(r.n)
+}
+
+// Prefix (Huffman) code lookup. Codes up to vp8lLUTBits bits are
+// decoded with one table lookup; longer ones bit by bit.
+const vp8lLUTBits = 8
+
+type vp8lCode struct {
+	single  int // The only symbol, or -1.
+	lut     []uint16
+	count   [16]uint16 // Number of codes of each length.
+	symbols []uint16   // Sorted by code.
+}
+
+// build makes a canonical prefix code from code lengths. Only
+// complete codes are valid, except for codes with one symbol,
+// which take zero bits.
+func (c *vp8lCode) build(lengths []uint8) error {
+	*c = vp8lCode{single: -1}
+	n := 0
+	for s, l := range lengths {
+		if l != 0 {
+			c.count[l]++
+			c.single = s
+			n++
+		}
+	}
+	if n == 0 {
+		return errWebPFormat
+	}
+	if n == 1 {
+		return nil
+	}
+	c.single = -1
+	left := 1
+	for l := 1; l < 16; l++ {
+		left = left<<1 - int(c.count[l])
+		if left < 0 {
+			return errWebPFormat
+		}
+	}
+	if left != 0 {
+		return errWebPFormat
+	}
+
+	var offsets, codes [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		offsets[l] = offsets[l-1] + int(c.count[l-1])
+		code = (code + int(c.count[l-1])) << 1
+		codes[l] = code
+	}
+	c.symbols = make([]uint16, n)
+	c.lut = make([]uint16, 1<<vp8lLUTBits)
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c.symbols[offsets[l]] = uint16(s)
+		offsets[l]++
+		if l <= vp8lLUTBits {
+			// Codes are read most significant bit first.
+			rev := 0
+			for i, v := uint8(0), codes[l]; i < l; i++ {
+				rev = rev<<1 | v>>i&1
+			}
+			for i := rev; i < len(c.lut); i += 1 << l {
+				c.lut[i] = uint16(s)<<4 | uint16(l)
+			}
+		}
+		codes[l]++
+	}
+	return nil
+}
+
+// readSymbol decodes one symbol.
+func (r *vp8lBitReader) readSymbol(c *vp8lCode) int {
+	if c.single >= 0 {
+		return c.single
+	}
+	if r.n < vp8lLUTBits {
+		r.fill()
+	}
+	if e := c.lut[r.bits&(1<<vp8lLUTBits-1)]; e != 0 {
+		r.bits >>= e & 15
+		r.n -= uint(e & 15)
+		return int(e >> 4)
+	}
+	// Canonical decoding, one bit at a time.
+	code, first, index := 0, 0, 0
+	for l := 1; l < 16; l++ {
+		code |= int(r.read(1))
+		count := int(c.count[l])
+		if code-first < count {
+			return int(c.symbols[index+code-first])
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0 // Unreachable for complete codes.
+}
+
+type vp8lDecoder struct {
+	r *vp8lBitReader
+}
+
+// readVP8LHeader parses the 5 byte header of a VP8L chunk.
+func readVP8LHeader(data []byte) (width, height int, err error) {
+	if len(data) < 5 || data[0] != 0x2f {
+		return 0, 0, errWebPFormat
+	}
+	r := &vp8lBitReader{data: data[1:5]}
+	width = int(r.read(14)) + 1
+	height = int(r.read(14)) + 1
+	r.read(1) // Alpha hint.
+	if r.read(3) != 0 {
+		return 0, 0, errWebPUnsupported // Version.
+	}
+	return width, height, nil
+}
+
+// decodeVP8L decodes a VP8L chunk.
+func decodeVP8L(data []byte) (*image.NRGBA, error) {
+	width, height, err := readVP8LHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	pix, err := decodeVP8LPixels(data[5:], width, height)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, p := range pix {
+		img.Pix[4*i] = uint8(p >> 16)
+		img.Pix[4*i+1] = uint8(p >> 8)
+		img.Pix[4*i+2] = uint8(p)
+		img.Pix[4*i+3] = uint8(p >> 24)
+	}
+	return img, nil
+}
+
+// decodeVP8LPixels decodes a headerless image stream into ARGB
+// pixels.
+func decodeVP8LPixels(data []byte, width, height int) ([]uint32, error) {
+	if width > maxDecodePixels/height {
+		return nil, errWebPFormat
+	}
+	d := &vp8lDecoder{r: &vp8lBitReader{data: data}}
+
+	// Transforms, applied in reverse order after decoding.
+	type transform struct {
+		kind  int
+		bits  uint
+		width int // Image width before the transform.
+		data  []uint32
+	}
+	var transforms []transform
+	var seen [4]bool
+	w := width
+	for d.r.read(1) == 1 {
+		t := transform{kind: int(d.r.read(2)), width: w}
+		if seen[t.kind] {
+			return nil, errWebPFormat
+		}
+		seen[t.kind] = true
+		var err error
+		switch t.kind {
+		case vp8lPredictor, vp8lColor:
+			t.bits = uint(d.r.read(3)) + 2
+			t.data, err = d.decodeImage(vp8lSubSize(w, t.bits),
+				vp8lSubSize(height, t.bits), false)
+		case vp8lColorIndexing:
+			n := int(d.r.read(8)) + 1
+			switch {
+			case n <= 2:
+				t.bits = 3
+			case n <= 4:
+				t.bits = 2
+			case n <= 16:
+				t.bits = 1
+			}
+			var colors []uint32
+			colors, err = d.decodeImage(n, 1, false)
+			// Out of range indices give transparent black.
+			t.data = make([]uint32, 256)
+			for i := range colors {
+				if i > 0 {
+					colors[i] = vp8lAdd(colors[i], colors[i-1])
+				}
+				t.data[i] = colors[i]
+			}
+			w = vp8lSubSize(w, t.bits)
+		}
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+
+	pix, err := d.decodeImage(w, height, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(transforms) - 1; i >= 0; i-- {
+		t := &transforms[i]
+		switch t.kind {
+		case vp8lPredictor:
+			vp8lInversePredictor(pix, t.width, t.bits, t.data)
+		case vp8lColor:
+			vp8lInverseColor(pix, t.width, t.bits, t.data)
+		case vp8lSubtractGreen:
+			for j, p := range pix {
+				g := p >> 8 & 0xff
+				pix[j] = p&0xff00ff00 | (p+g<<16)&0xff0000 | (p+g)&0xff
+			}
+		case vp8lColorIndexing:
+			pix = vp8lInverseIndexing(pix, t.width, w, height, t.bits, t.data)
+			w = t.width
+		}
+	}
+	return pix, nil
+}
+
+// vp8lSubSize is the size of a subsampled image dimension.
+func vp8lSubSize(size int, bits uint) int {
+	return (size + 1<<bits - 1) >> bits
+}
+
+// vp8lAdd adds ARGB pixels per channel, modulo 256.
+func vp8lAdd(a, b uint32) uint32 {
+	return (a&0xff00ff00+b&0xff00ff00)&0xff00ff00 |
+		(a&0x00ff00ff+b&0x00ff00ff)&0x00ff00ff
+}
+
+// decodeImage decodes an entropy coded image. Only the main image
+// (top) may use several prefix code groups.
+func (d *vp8lDecoder) decodeImage(w, h int, top bool) ([]uint32, error) {
+	r := d.r
+	var cacheBits uint
+	if r.read(1) == 1 {
+		cacheBits = uint(r.read(4))
+		if cacheBits < 1 || cacheBits > 11 {
+			return nil, errWebPFormat
+		}
+	}
+
+	var metaBits uint
+	var meta []uint32 // Group index of each block.
+	metaW := 0
+	numGroups := 1
+	if top && r.read(1) == 1 {
+		metaBits = uint(r.read(3)) + 2
+		metaW = vp8lSubSize(w, metaBits)
+		var err error
+		meta, err = d.decodeImage(metaW, vp8lSubSize(h, metaBits), false)
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range meta {
+			meta[i] = p >> 8 & 0xffff
+			if int(meta[i]) >= numGroups {
+				numGroups = int(meta[i]) + 1
+			}
+		}
+	}
+
+	groups := make([][5]vp8lCode, numGroups)
+	for i := range groups {
+		for j := range groups[i] {
+			size := vp8lAlphabets[j]
+			if j == 0 && cacheBits > 0 {
+				size += 1 << cacheBits
+			}
+			if err := d.readCode(&groups[i][j], size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r.overrun() {
+		return nil, errWebPFormat
+	}
+
+	var cache []uint32
+	if cacheBits > 0 {
+		cache = make([]uint32, 1<<cacheBits)
+	}
+	pix := make([]uint32, w*h)
+	cached := 0 // Pixels before this one are in the cache.
+	g := &groups[0]
+	for pos, x, y := 0, 0, 0; pos < len(pix); {
+		if meta != nil {
+			g = &groups[meta[(y>>metaBits)*metaW+x>>metaBits]]
+		}
+		s := r.readSymbol(&g[0])
+		switch {
+		case s < 256:
+			red := uint32(r.readSymbol(&g[1]))
+			blue := uint32(r.readSymbol(&g[2]))
+			alpha := uint32(r.readSymbol(&g[3]))
+			pix[pos] = alpha<<24 | red<<16 | uint32(s)<<8 | blue
+			pos++
+		case s < 256+24:
+			length := d.readLZ77Value(s - 256)
+			dist := d.readLZ77Value(r.readSymbol(&g[4]))
+			if dist > 120 {
+				dist -= 120
+			} else {
+				m := vp8lDistanceMap[dist-1]
+				dist = int(m[0]) + int(m[1])*w
+				if dist < 1 {
+					dist = 1
+				}
+			}
+			if dist > pos || length > len(pix)-pos {
+				return nil, errWebPFormat
+			}
+			for i := 0; i < length; i++ {
+				pix[pos+i] = pix[pos+i-dist]
+			}
+			pos += length
+		default:
+			if cache == nil {
+				return nil, errWebPFormat
+			}
+			for ; cached < pos; cached++ {
+				p := pix[cached]
+				cache[(0x1e35a7bd*p)>>(32-cacheBits)] = p
+			}
+			pix[pos] = cache[s-256-24]
+			pos++
+		}
+		x, y = pos%w, pos/w
+		if x == 0 && r.overrun() {
+			return nil, errWebPFormat
+		}
+	}
+	if r.overrun() {
+		return nil, errWebPFormat
+	}
+	return pix, nil
+}
+
+// readLZ77Value reads a length or a distance from its prefix
+// symbol and extra bits.
+func (d *vp8lDecoder) readLZ77Value(symbol int) int {
+	if symbol < 4 {
+		return symbol + 1
+	}
+	extra := uint(symbol-2) >> 1
+	offset := (2 + symbol&1) << extra
+	return offset + int(d.r.read(extra)) + 1
+}
+
+// readCode reads a prefix code for an alphabet of the given size.
+func (d *vp8lDecoder) readCode(c *vp8lCode, size int) error {
+	r := d.r
+	lengths := make([]uint8, size)
+	if r.read(1) == 1 { // Simple code with 1 or 2 symbols.
+		n := r.read(1) + 1
+		s := int(r.read(1 + 7*uint(r.read(1))))
+		if s >= size {
+			return errWebPFormat
+		}
+		lengths[s] = 1
+		if n == 2 {
+			if s = int(r.read(8)); s >= size {
+				return errWebPFormat
+			}
+			lengths[s] = 1
+		}
+		return c.build(lengths)
+	}
+
+	var clLengths [19]uint8
+	n := int(r.read(4)) + 4
+	for i := 0; i < n; i++ {
+		clLengths[vp8lCodeLengthOrder[i]] = uint8(r.read(3))
+	}
+	var cl vp8lCode
+	if err := cl.build(clLengths[:]); err != nil {
+		return err
+	}
+
+	max := size
+	if r.read(1) == 1 {
+		bits := 2 + 2*uint(r.read(3))
+		if max = 2 + int(r.read(bits)); max > size {
+			return errWebPFormat
+		}
+	}
+	prev := uint8(8)
+	for s := 0; s < size && max > 0; max-- {
+		l := r.readSymbol(&cl)
+		if l < 16 {
+			lengths[s] = uint8(l)
+			if l != 0 {
+				prev = uint8(l)
+			}
+			s++
+			continue
+		}
+		repeat, v := 0, uint8(0)
+		switch l {
+		case 16:
+			repeat, v = 3+int(r.read(2)), prev
+		case 17:
+			repeat = 3 + int(r.read(3))
+		default:
+			repeat = 11 + int(r.read(7))
+		}
+		if s+repeat > size {
+			return errWebPFormat
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[s] = v
+			s++
+		}
+	}
+	return c.build(lengths)
+}
+
+// vp8lInversePredictor undoes the predictor transform.
+func vp8lInversePredictor(pix []uint32, w int, bits uint, modes []uint32) {
+	if len(pix) == 0 {
+		return
+	}
+	h := len(pix) / w
+	pix[0] = vp8lAdd(pix[0], 0xff000000)
+	for x := 1; x < w; x++ {
+		pix[x] = vp8lAdd(pix[x], pix[x-1])
+	}
+	blocksW := vp8lSubSize(w, bits)
+	for y := 1; y < h; y++ {
+		row := y * w
+		pix[row] = vp8lAdd(pix[row], pix[row-w])
+		for x := 1; x < w; x++ {
+			mode := modes[(y>>bits)*blocksW+x>>bits] >> 8 & 0xf
+			i := row + x
+			// The top-right pixel of the last column is the first
+			// pixel of the current row.
+			L, T, TR, TL := pix[i-1], pix[i-w], pix[i-w+1], pix[i-w-1]
+			var p uint32
+			switch mode {
+			case 0:
+				p = 0xff000000
+			case 1:
+				p = L
+			case 2:
+				p = T
+			case 3:
+				p = TR
+			case 4:
+				p = TL
+			case 5:
+				p = vp8lAverage(vp8lAverage(L, TR), T)
+			case 6:
+				p = vp8lAverage(L, TL)
+			case 7:
+				p = vp8lAverage(L, T)
+			case 8:
+				p = vp8lAverage(TL, T)
+			case 9:
+				p = vp8lAverage(T, TR)
+			case 10:
+				p = vp8lAverage(vp8lAverage(L, TL), vp8lAverage(T, TR))
+			case 11:
+				p = vp8lSelect(L, T, TL)
+			case 12:
+				p = vp8lClampAddSubtractFull(L, T, TL)
+			case 13:
+				p = vp8lClampAddSubtractHalf(vp8lAverage(L, T), TL)
+			default: // Modes 14 and 15 behave as mode 0 in libwebp.
+				p = 0xff000000
+			}
+			pix[i] = vp8lAdd(pix[i], p)
+		}
+	}
+}
+
+func vp8lAverage(a, b uint32) uint32 {
+	return (a^b)&0xfefefefe>>1 + a&b
+}
+
+// vp8lSelect chooses whichever of L and T is closer to the
+// gradient estimate L+T-TL.
+func vp8lSelect(L, T, TL uint32) uint32 {
+	pL, pT := 0, 0 // Manhattan distances of the estimate to L and T.
+	for shift := uint(0); shift < 32; shift += 8 {
+		l, t, tl := int(L>>shift&0xff), int(T>>shift&0xff), int(TL>>shift&0xff)
+		pL += absInt(t - tl)
+		pT += absInt(l - tl)
+	}
+	if pL < pT {
+		return L
+	}
+	return T
+}
+
+func vp8lClampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := int(a>>shift&0xff) + int(b>>shift&0xff) - int(c>>shift&0xff)
+		p |= uint32(clampInt(v, 0, 255)) << shift
+	}
+	return p
+}
+
+func vp8lClampAddSubtractHalf(a, b uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		av, bv := int(a>>shift&0xff), int(b>>shift&0xff)
+		p |= uint32(clampInt(av+(av-bv)/2, 0, 255)) << shift
+	}
+	return p
+}
+
+// vp8lInverseColor undoes the color transform.
+func vp8lInverseColor(pix []uint32, w int, bits uint, elems []uint32) {
+	blocksW := vp8lSubSize(w, bits)
+	delta := func(t, c uint32) uint32 {
+		return uint32(int32(int8(t)) * int32(int8(c)) >> 5)
+	}
+	for i, p := range pix {
+		x, y := i%w, i/w
+		e := elems[(y>>bits)*blocksW+x>>bits]
+		greenToRed, greenToBlue, redToBlue := e&0xff, e>>8&0xff, e>>16&0xff
+		g := p >> 8 & 0xff
+		red := (p>>16 + delta(greenToRed, g)) & 0xff
+		blue := (p + delta(greenToBlue, g) + delta(redToBlue, red)) & 0xff
+		pix[i] = p&0xff00ff00 | red<<16 | blue
+	}
+}
+
+// vp8lInverseIndexing expands packed palette indices of a w×h
+// image to colors of a (width)×h image.
+func vp8lInverseIndexing(pix []uint32, width, w, h int, bits uint,
+	palette []uint32) []uint32 {
+	out := make([]uint32, width*h)
+	perPixel := 8 >> bits // Bits per index.
+	mask := uint32(1)<<uint(perPixel) - 1
+	for y := 0; y < h; y++ {
+		for x := 0; x < width; x++ {
+			packed := pix[y*w+x>>bits] >> 8
+			shift := uint(x&(1<<bits-1)) * uint(perPixel)
+			out[y*width+x] = palette[packed>>shift&mask]
+		}
+	}
+	return out
+}
@@ -0,0 +1,213 @@
+package images4
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WatchEventType is the kind of a WatchEvent.
+type WatchEventType string
+
+const (
+	WatchNew       WatchEventType = "new"       // New or changed file without duplicates.
+	WatchDuplicate WatchEventType = "duplicate" // New or changed file similar to indexed ones.
+	WatchRemoved   WatchEventType = "removed"   // File removed.
+	WatchError     WatchEventType = "error"     // File or directory which cannot be read.
+)
+
+// WatchEvent is a change found by a Watcher.
+type WatchEvent struct {
+	Type WatchEventType `json:"type"`
+	Path string         `json:"path"`
+	// Duplicates are paths (ids) of similar icons of the index,
+	// the most similar first.
+	Duplicates []string `json:"duplicates,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// WatchOptions configure a Watcher.
+type WatchOptions struct {
+	// Interval between scans of func Watch. 0 means 2 seconds.
+	Interval time.Duration
+	// Index of icons to compare with. Icons of new files are added
+	// with their paths as ids. A new index is created when nil.
+	// Its IndexOptions.UniformSameColor keeps blank images from
+	// matching other images.
+	Index *Index
+	// Coefficients, when not nil, replace the default thresholds
+	// with those of func CustomSimilar.
+	Coefficients *CustomCoefficients
+	// Limits protect decoding from decompression bombs.
+	Limits Limits
+}
+
+// Watcher finds new, changed and removed image files in
+// directories and their subdirectories by polling, and finds
+// duplicates of new images. It does not depend on file system
+// notifications of the OS. Files are processed once their size
+// and modification time stay unchanged for one scan, so that
+// files being written are not read too early. Files in formats
+// unknown to func Open are ignored. A directory which cannot be
+// read, such as a missing one, is reported once, and again only
+// after it was readable or its error changed.
+type Watcher struct {
+	dirs    []string
+	opts    WatchOptions
+	known   map[string]fileState // Processed files.
+	pending map[string]fileState // Files changed in the last scan.
+	failed  map[string]string    // Errors of the last scan by path.
+}
+
+// fileState tells whether a file changed between scans.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewWatcher creates a watcher of directories.
+func NewWatcher(dirs []string, opts WatchOptions) *Watcher {
+	if opts.Interval <= 0 {
+		opts.Interval = 2 * time.Second
+	}
+	if opts.Index == nil {
+		opts.Index = NewIndex(IndexOptions{})
+	}
+	return &Watcher{
+		dirs:    dirs,
+		opts:    opts,
+		known:   make(map[string]fileState),
+		pending: make(map[string]fileState),
+		failed:  make(map[string]string),
+	}
+}
+
+// Index returns the index of the watcher.
+func (w *Watcher) Index() *Index {
+	return w.opts.Index
+}
+
+// Watch scans directories every interval and calls fn for each
+// event, until ctx is done. It returns ctx.Err().
+func (w *Watcher) Watch(ctx context.Context, fn func(WatchEvent)) error {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		w.Scan(fn)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan checks directories once and calls fn for each event.
+// Events of a scan are ordered by path, after all changes are
+// processed.
+func (w *Watcher) Scan(fn func(WatchEvent)) {
+	seen := make(map[string]fileState)
+	failed := make(map[string]string)
+	var changed []string
+	var events []WatchEvent
+	for _, dir := range w.dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				failed[path] = err.Error()
+				if w.failed[path] != failed[path] {
+					events = append(events, WatchEvent{Type: WatchError, Path: path, Error: failed[path]})
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil // Removed meanwhile.
+			}
+			state := fileState{info.Size(), info.ModTime()}
+			seen[path] = state
+			if known, ok := w.known[path]; ok && known == state {
+				return nil
+			}
+			if pending, ok := w.pending[path]; ok && pending == state {
+				changed = append(changed, path)
+				return nil
+			}
+			w.pending[path] = state
+			return nil
+		})
+	}
+	w.failed = failed
+
+	var removed []string
+	for path := range w.known {
+		if _, ok := seen[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	for _, path := range removed {
+		delete(w.known, path)
+		if w.opts.Index.Remove(path) {
+			events = append(events, WatchEvent{Type: WatchRemoved, Path: path})
+		}
+	}
+	for path := range w.pending {
+		if _, ok := seen[path]; !ok {
+			delete(w.pending, path)
+		}
+	}
+
+	sort.Strings(changed)
+	for _, path := range changed {
+		w.known[path] = w.pending[path]
+		delete(w.pending, path)
+		if event, ok := w.process(path); ok {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Path < events[j].Path
+	})
+	for _, e := range events {
+		fn(e)
+	}
+}
+
+// process computes the icon of a new or changed file and
+// searches for its duplicates.
+func (w *Watcher) process(path string) (WatchEvent, bool) {
+	index := w.opts.Index
+	index.Remove(path) // The file may have changed.
+	img, err := OpenLimited(path, w.opts.Limits)
+	if errors.Is(err, ErrUnknownFormat) {
+		return WatchEvent{}, false
+	}
+	if err != nil {
+		return WatchEvent{Type: WatchError, Path: path, Error: err.Error()}, true
+	}
+	icon := Icon(img)
+
+	var matches []Match
+	if w.opts.Coefficients != nil {
+		matches = index.CustomSearch(icon, *w.opts.Coefficients)
+	} else {
+		matches = index.Search(icon)
+	}
+	index.Add(path, icon)
+
+	event := WatchEvent{Type: WatchNew, Path: path}
+	if len(matches) > 0 {
+		event.Type = WatchDuplicate
+		for _, m := range matches {
+			event.Duplicates = append(event.Duplicates, m.ID)
+		}
+	}
+	return event, true
+}
@@ -0,0 +1,180 @@
+package images4
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func copyTestFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherScan(t *testing.T) {
+	dir := t.TempDir()
+	copyTestFile(t, "custom/1.jpg", filepath.Join(dir, "a.jpg"))
+	copyTestFile(t, "euclidean/uniform-green.png", filepath.Join(dir, "b.png"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("text"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher([]string{dir}, WatchOptions{})
+	var events []WatchEvent
+	collect := func(e WatchEvent) { events = append(events, e) }
+
+	// Files are processed when unchanged for one scan.
+	w.Scan(collect)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events on the first scan, got %v.", events)
+	}
+	w.Scan(collect)
+	want := []WatchEvent{
+		{Type: WatchNew, Path: filepath.Join(dir, "a.jpg")},
+		{Type: WatchNew, Path: filepath.Join(dir, "b.png")},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Expected %v, got %v.", want, events)
+	}
+
+	// A duplicate in a subdirectory.
+	events = nil
+	dup := filepath.Join(dir, "sub", "c.jpg")
+	copyTestFile(t, "custom/2.jpg", dup)
+	w.Scan(collect)
+	w.Scan(collect)
+	want = []WatchEvent{{Type: WatchDuplicate, Path: dup,
+		Duplicates: []string{filepath.Join(dir, "a.jpg")}}}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Expected %v, got %v.", want, events)
+	}
+	w.Scan(collect)
+	if len(events) != 1 {
+		t.Errorf("Unchanged files must not give events, got %v.", events[1:])
+	}
+
+	// A changed file is compared again, without itself.
+	events = nil
+	copyTestFile(t, "euclidean/uniform-green.png", dup)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(dup, later, later)
+	w.Scan(collect)
+	w.Scan(collect)
+	want = []WatchEvent{{Type: WatchDuplicate, Path: dup,
+		Duplicates: []string{filepath.Join(dir, "b.png")}}}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Expected %v, got %v.", want, events)
+	}
+
+	events = nil
+	os.Remove(filepath.Join(dir, "a.jpg"))
+	os.Remove(filepath.Join(dir, "notes.txt"))
+	w.Scan(collect)
+	want = []WatchEvent{{Type: WatchRemoved, Path: filepath.Join(dir, "a.jpg")}}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Expected %v, got %v.", want, events)
+	}
+	if w.Index().Len() != 2 {
+		t.Errorf("Expected 2 icons in the index, got %v.", w.Index().IDs())
+	}
+}
+
+func TestWatcherExistingIndex(t *testing.T) {
+	dir := t.TempDir()
+	copyTestFile(t, "custom/2.jpg", filepath.Join(dir, "new.jpg"))
+	img, err := Open(filepath.Join("testdata", "custom", "1.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := NewIndex(IndexOptions{})
+	index.Add("archive/1.jpg", Icon(img))
+
+	for _, c := range []struct {
+		coeff *CustomCoefficients
+		want  WatchEventType
+	}{{nil, WatchDuplicate}, {&CustomCoefficients{0, 0, 0, 0}, WatchNew}} {
+		index.Remove(filepath.Join(dir, "new.jpg"))
+		ctx, cancel := context.WithCancel(context.Background())
+		w := NewWatcher([]string{dir, filepath.Join(dir, "missing")},
+			WatchOptions{Interval: time.Millisecond, Index: index, Coefficients: c.coeff})
+		var got []WatchEvent
+		err := w.Watch(ctx, func(e WatchEvent) {
+			got = append(got, e)
+			if e.Type != WatchError {
+				cancel()
+			}
+		})
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v.", err)
+		}
+		last := got[len(got)-1]
+		if got[0].Type != WatchError || last.Type != c.want {
+			t.Errorf("Expected an error for the missing directory and %s, got %v.", c.want, got)
+		}
+	}
+}
+
+func TestWatcherMissingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	w := NewWatcher([]string{dir}, WatchOptions{})
+	var events []WatchEvent
+	collect := func(e WatchEvent) { events = append(events, e) }
+
+	w.Scan(collect)
+	w.Scan(collect)
+	if len(events) != 1 || events[0].Type != WatchError || events[0].Path != dir {
+		t.Fatalf("Expected one error for the missing directory, got %v.", events)
+	}
+
+	// Reported again after the directory was readable.
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.Scan(collect)
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	w.Scan(collect)
+	w.Scan(collect)
+	if len(events) != 2 || events[1].Type != WatchError {
+		t.Errorf("Expected a second error after the directory was removed, got %v.", events)
+	}
+}
+
+func TestWatcherScanOrder(t *testing.T) {
+	dir := t.TempDir()
+	copyTestFile(t, "custom/1.jpg", filepath.Join(dir, "b.jpg"))
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := os.Mkdir(missing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher([]string{dir, missing}, WatchOptions{})
+	w.Scan(func(WatchEvent) {})
+	w.Scan(func(WatchEvent) {})
+
+	// An error, a new file and a removed file in one scan.
+	copyTestFile(t, "euclidean/uniform-green.png", filepath.Join(dir, "a.png"))
+	w.Scan(func(WatchEvent) {})
+	os.Remove(filepath.Join(dir, "b.jpg"))
+	os.Remove(missing)
+	var paths []string
+	w.Scan(func(e WatchEvent) { paths = append(paths, e.Path) })
+	want := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "b.jpg"), missing}
+	sort.Strings(want)
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected events for %v, got %v.", want, paths)
+	}
+}
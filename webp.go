@@ -0,0 +1,351 @@
+package images4
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"io/ioutil"
+)
+
+// Decoder of WebP images: lossy (VP8 key frames), lossless (VP8L),
+// lossy with an alpha channel, and the extended format (VP8X), of
+// which animations are decoded as their first frame. It is
+// registered with the image package, so that func Open and
+// image.Decode recognize WebP files.
+//
+// Lossy images decode to *image.YCbCr or, with alpha, to
+// *image.NYCbCrA. Lossless images and animations decode to
+// *image.NRGBA.
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBPVP8", decodeWebP, decodeWebPConfig)
+}
+
+var (
+	errWebPFormat      = errors.New("images4: webp: invalid format")
+	errWebPUnsupported = errors.New("images4: webp: unsupported format")
+)
+
+// Animation flag of the VP8X chunk.
+const webpAnimationFlag = 0x02
+
+// webpChunk reads a chunk header and returns the chunk id and
+// the payload size with padding.
+func webpChunk(r io.Reader) (id string, size int64, err error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return "", 0, unexpectedEOF(err)
+	}
+	size = int64(binary.LittleEndian.Uint32(b[4:]))
+	return string(b[:4]), size + size&1, nil
+}
+
+func readWebPHeader(r io.Reader) error {
+	var b [12]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if string(b[:4]) != "RIFF" || string(b[8:]) != "WEBP" {
+		return errWebPFormat
+	}
+	return nil
+}
+
+// decodeWebPConfig reads chunk headers up to the first image data.
+func decodeWebPConfig(r io.Reader) (image.Config, error) {
+	if err := readWebPHeader(r); err != nil {
+		return image.Config{}, err
+	}
+	var canvas *image.Config
+	alpha := false
+	for {
+		id, size, err := webpChunk(r)
+		if err != nil {
+			return image.Config{}, err
+		}
+		var n int64
+		switch id {
+		case "VP8X":
+			var b [10]byte
+			if size < int64(len(b)) {
+				return image.Config{}, errWebPFormat
+			}
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return image.Config{}, unexpectedEOF(err)
+			}
+			n = int64(len(b))
+			canvas = &image.Config{
+				ColorModel: color.NRGBAModel,
+				Width:      int(b[4]) | int(b[5])<<8 | int(b[6])<<16 + 1,
+				Height:     int(b[7]) | int(b[8])<<8 | int(b[9])<<16 + 1,
+			}
+			if b[0]&webpAnimationFlag != 0 {
+				return *canvas, nil
+			}
+		case "ALPH":
+			alpha = true
+		case "VP8 ":
+			var b [10]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return image.Config{}, unexpectedEOF(err)
+			}
+			w, h, _, err := readVP8FrameHeader(b[:])
+			if err != nil {
+				return image.Config{}, err
+			}
+			c := image.Config{ColorModel: color.YCbCrModel, Width: w, Height: h}
+			if alpha {
+				c.ColorModel = color.NYCbCrAModel
+			}
+			return c, webpCheckSize(canvas, w, h)
+		case "VP8L":
+			var b [5]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return image.Config{}, unexpectedEOF(err)
+			}
+			w, h, err := readVP8LHeader(b[:])
+			if err != nil {
+				return image.Config{}, err
+			}
+			return image.Config{ColorModel: color.NRGBAModel, Width: w, Height: h},
+				webpCheckSize(canvas, w, h)
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, size-n); err != nil {
+			return image.Config{}, unexpectedEOF(err)
+		}
+	}
+}
+
+// webpCheckSize checks that image data fills the VP8X canvas or
+// the animation frame, if any.
+func webpCheckSize(canvas *image.Config, width, height int) error {
+	if canvas != nil && (canvas.Width != width || canvas.Height != height) {
+		return errWebPFormat
+	}
+	return nil
+}
+
+func decodeWebP(r io.Reader) (image.Image, error) {
+	var b [12]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if string(b[:4]) != "RIFF" || string(b[8:]) != "WEBP" {
+		return nil, errWebPFormat
+	}
+	size := int64(binary.LittleEndian.Uint32(b[4:])) - 4
+	data, err := ioutil.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, err
+	}
+	return decodeWebPChunks(data, nil)
+}
+
+// decodeWebPChunks decodes chunks following the RIFF header, or
+// the payload of an animation frame. The canvas is the VP8X canvas
+// size, if any.
+func decodeWebPChunks(data []byte, canvas *image.Config) (image.Image, error) {
+	var alpha []byte
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		chunk := data[:size]
+		data = data[size:]
+		if size&1 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+
+		switch id {
+		case "VP8X":
+			if len(chunk) < 10 || canvas != nil {
+				return nil, errWebPFormat
+			}
+			canvas = &image.Config{
+				Width:  int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16 + 1,
+				Height: int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16 + 1,
+			}
+			if canvas.Width > maxDecodePixels/canvas.Height {
+				return nil, errWebPFormat
+			}
+			if chunk[0]&webpAnimationFlag != 0 {
+				return decodeWebPFirstFrame(data, canvas)
+			}
+		case "ALPH":
+			alpha = chunk
+		case "VP8 ":
+			w, h, _, err := readVP8FrameHeader(chunk)
+			if err != nil {
+				return nil, err
+			}
+			if err := webpCheckSize(canvas, w, h); err != nil {
+				return nil, err
+			}
+			img, err := decodeVP8(chunk)
+			if err != nil {
+				return nil, err
+			}
+			if alpha == nil {
+				return img, nil
+			}
+			a, err := decodeWebPAlpha(alpha, w, h)
+			if err != nil {
+				return nil, err
+			}
+			return &image.NYCbCrA{YCbCr: *img, A: a, AStride: w}, nil
+		case "VP8L":
+			w, h, err := readVP8LHeader(chunk)
+			if err != nil {
+				return nil, err
+			}
+			if err := webpCheckSize(canvas, w, h); err != nil {
+				return nil, err
+			}
+			return decodeVP8L(chunk)
+		}
+	}
+	return nil, errWebPFormat
+}
+
+// decodeWebPFirstFrame finds the first ANMF chunk of an animation
+// and renders it on a transparent canvas.
+func decodeWebPFirstFrame(data []byte, canvas *image.Config) (image.Image, error) {
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if id != "ANMF" {
+			if size += size & 1; size > len(data) {
+				break
+			}
+			data = data[size:]
+			continue
+		}
+		// Frame position (in units of 2 pixels), size, duration
+		// and flags, followed by frame data chunks.
+		frame := data[:size]
+		if len(frame) < 16 {
+			return nil, errWebPFormat
+		}
+		x := 2 * (int(frame[0]) | int(frame[1])<<8 | int(frame[2])<<16)
+		y := 2 * (int(frame[3]) | int(frame[4])<<8 | int(frame[5])<<16)
+		frameSize := &image.Config{
+			Width:  int(frame[6]) | int(frame[7])<<8 | int(frame[8])<<16 + 1,
+			Height: int(frame[9]) | int(frame[10])<<8 | int(frame[11])<<16 + 1,
+		}
+		if x+frameSize.Width > canvas.Width || y+frameSize.Height > canvas.Height {
+			return nil, errWebPFormat
+		}
+		img, err := decodeWebPChunks(frame[16:], frameSize)
+		if err != nil {
+			return nil, err
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, canvas.Width, canvas.Height))
+		r := img.Bounds().Add(image.Point{x, y})
+		draw.Draw(dst, r, img, img.Bounds().Min, draw.Src)
+		return dst, nil
+	}
+	return nil, errWebPFormat
+}
+
+// webpLossless tells whether the image data of a WebP file, of the
+// first frame for animations, is lossless (VP8L) rather than lossy
+// (VP8). Both decode to *image.NRGBA when animated.
+func webpLossless(data []byte) bool {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false
+	}
+	data = data[12:]
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return false
+		}
+		switch id {
+		case "VP8L":
+			return true
+		case "VP8 ":
+			return false
+		case "ANMF":
+			if size < 16 {
+				return false
+			}
+			data = data[16:size] // Frame data chunks.
+			continue
+		}
+		if size += size & 1; size > len(data) {
+			return false
+		}
+		data = data[size:]
+	}
+	return false
+}
+
+// decodeWebPAlpha decodes an ALPH chunk into a w×h alpha plane.
+func decodeWebPAlpha(chunk []byte, w, h int) ([]uint8, error) {
+	if len(chunk) < 1 {
+		return nil, errWebPFormat
+	}
+	// Bits 0-1 are compression, bits 2-3 filtering. Bits 4-5 are
+	// a preprocessing hint which needs no action.
+	compression, filter := chunk[0]&3, chunk[0]>>2&3
+	a := make([]uint8, w*h)
+	switch compression {
+	case 0:
+		if len(chunk)-1 < len(a) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		copy(a, chunk[1:])
+	case 1:
+		pix, err := decodeVP8LPixels(chunk[1:], w, h)
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range pix {
+			a[i] = uint8(p >> 8) // Green channel.
+		}
+	default:
+		return nil, errWebPFormat
+	}
+
+	// Unfiltering. The first pixel is predicted by 0, the rest of
+	// the first row by the left pixel and the rest of the first
+	// column by the pixel above.
+	if filter == 0 {
+		return a, nil
+	}
+	for x := 1; x < w; x++ {
+		a[x] += a[x-1]
+	}
+	for y := 1; y < h; y++ {
+		row := a[y*w : (y+1)*w]
+		above := a[(y-1)*w : y*w]
+		row[0] += above[0]
+		for x := 1; x < w; x++ {
+			switch filter {
+			case 1: // Horizontal.
+				row[x] += row[x-1]
+			case 2: // Vertical.
+				row[x] += above[x]
+			default: // Gradient.
+				row[x] += uint8(clampInt(
+					int(row[x-1])+int(above[x])-int(above[x-1]), 0, 255))
+			}
+		}
+	}
+	return a, nil
+}
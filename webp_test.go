@@ -0,0 +1,85 @@
+package images4
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Reference images are decoded with golang.org/x/image/webp,
+// which is verified against libwebp.
+func TestDecodeWebP(t *testing.T) {
+	tables := []struct {
+		fileName, refName string
+	}{
+		{"lossy.webp", "lossy-webp.png"},
+		{"lossy-alpha.webp", "lossy-alpha-webp.png"},
+		{"lossless.webp", "lossless-webp.png"},
+		{"lossless-palette.webp", "lossless-palette-webp.png"},
+		// First frame of 2, at an offset on a larger canvas.
+		{"animated.webp", "animated-webp.png"},
+	}
+	for _, table := range tables {
+		testDecodeFormat(table.fileName, table.refName, "webp", t)
+	}
+}
+
+func TestWebPLossless(t *testing.T) {
+	read := func(name string) []byte {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "formats", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	for name, want := range map[string]bool{
+		"lossy.webp": false, "lossy-alpha.webp": false, "lossless.webp": true,
+		"lossless-palette.webp": true, "animated.webp": true,
+	} {
+		if got := webpLossless(read(name)); got != want {
+			t.Errorf("%s: expected lossless %v, got %v.", name, want, got)
+		}
+	}
+
+	// An animation of a lossy frame, which decodes to NRGBA too.
+	vp8 := read("lossy.webp")[12:]
+	anmf := append(make([]byte, 16), vp8...)
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00")
+	data = append(data, webpAnimationFlag, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, "ANMF"...)
+	data = append(data, byte(len(anmf)), byte(len(anmf)>>8), byte(len(anmf)>>16), 0)
+	data = append(data, anmf...)
+	if webpLossless(data) {
+		t.Error("Expected a lossy animation.")
+	}
+}
+
+func TestDecodeWebPAlpha(t *testing.T) {
+	want := []uint8{10, 20, 30, 40, 60, 90}
+	tables := []struct {
+		filter    byte
+		residuals []uint8
+	}{
+		{0, want},
+		{1, []uint8{10, 10, 10, 30, 20, 30}}, // Horizontal.
+		{2, []uint8{10, 10, 10, 30, 40, 60}}, // Vertical.
+		{3, []uint8{10, 10, 10, 30, 10, 20}}, // Gradient.
+	}
+	for _, table := range tables {
+		chunk := append([]byte{table.filter << 2}, table.residuals...)
+		got, err := decodeWebPAlpha(chunk, 3, 2)
+		if err != nil {
+			t.Fatal("Error decoding alpha:", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("Filter %d: expected %v, got %v.", table.filter, want, got)
+		}
+	}
+}
+
+func FuzzDecodeWebP(f *testing.F) {
+	fuzzSeeds(f, "*.webp")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, data, decodeWebP, decodeWebPConfig)
+	})
+}